@@ -8,9 +8,14 @@ import (
 
 type BuiltinFunc func(argv []*value, lineno int) *value
 
+// Builtin function that needs the call stack, to call the functions it receives as arguments
+type BuiltinStackFunc func(stack []CallFrame, argv []*value, lineno int) *value
+
 type BuiltinFn struct {
-	nargs int
-	fn    BuiltinFunc
+	nargs   int // minimum number of arguments
+	maxargs int // maximum number of arguments, negative if unlimited
+	fn      BuiltinFunc
+	stackFn BuiltinStackFunc // used instead of fn if set
 }
 
 type AstNode interface {
@@ -74,19 +79,35 @@ func (n *VarNode) Line() int {
 }
 
 type FnCallNode struct {
-	name   string
+	name   string  // name of the called function, empty if callee is not a variable
+	callee AstNode // expression evaluating to the called function
 	args   []AstNode
 	lineno int
 }
 
 func NewFnCallNode(name string, args []AstNode, lineno int) *FnCallNode {
-	return &FnCallNode{name, args, lineno}
+	return &FnCallNode{name, NewVarNode(name, lineno), args, lineno}
+}
+
+func NewExprCallNode(callee AstNode, args []AstNode, lineno int) *FnCallNode {
+	return &FnCallNode{"", callee, args, lineno}
 }
 
 func (n *FnCallNode) String() string {
+	if n.name == "" {
+		return fmt.Sprintf("FnCallNode<%s, %s>", n.callee, n.args)
+	}
 	return fmt.Sprintf("FnCallNode<%s, %s>", n.name, n.args)
 }
 
+// Returns the name of the called function for error messages
+func (n *FnCallNode) fnName() string {
+	if n.name == "" {
+		return "anonymous function"
+	}
+	return n.name
+}
+
 func (n *FnCallNode) Line() int {
 	return n.lineno
 }
@@ -118,6 +139,12 @@ func NewTimeNode(x int64, lineno int) *ConstNode {
 }
 
 func (n *ConstNode) String() string {
+	if n.v.kind == SVAL {
+		return fmt.Sprintf("ConstNode<%d, %q>", n.v.kind, n.v.sval)
+	}
+	if n.v.kind == CVAL {
+		return fmt.Sprintf("ConstNode<%d, %s>", n.v.kind, fmtcomplex(n.v.cval))
+	}
 	return fmt.Sprintf("ConstNode<%d, %s, %g>", n.v.kind, n.v.ival.String(), n.v.dval)
 }
 
@@ -149,19 +176,87 @@ func (n *BinOpNode) Line() int {
 	return n.lineno
 }
 
+// Short-circuit boolean operator (&& and ||), the second operand is only executed if the first one doesn't determine the result
+type ShortCircuitNode struct {
+	name   string
+	fn     BinOpFunc
+	op1    AstNode
+	op2    AstNode
+	lineno int
+}
+
+func NewShortCircuitNode(tok token, op1, op2 AstNode) *ShortCircuitNode {
+	return &ShortCircuitNode{tok.ttype.Name, tok.ttype.BinFn, op1, op2, tok.lineno}
+}
+
+func (n *ShortCircuitNode) String() string {
+	return fmt.Sprintf("ShortCircuitNode<%s, %s, %s>", n.name, n.op1.String(), n.op2.String())
+}
+
+func (n *ShortCircuitNode) Line() int {
+	return n.lineno
+}
+
+// Conditional expression cond ? ifTrue : ifFalse
+type CondNode struct {
+	cond    AstNode
+	ifTrue  AstNode
+	ifFalse AstNode
+	lineno  int
+}
+
+func NewCondNode(cond, ifTrue, ifFalse AstNode, lineno int) *CondNode {
+	return &CondNode{cond, ifTrue, ifFalse, lineno}
+}
+
+func (n *CondNode) String() string {
+	return fmt.Sprintf("CondNode<%s, %s, %s>", n.cond.String(), n.ifTrue.String(), n.ifFalse.String())
+}
+
+func (n *CondNode) Line() int {
+	return n.lineno
+}
+
+// Conversion of the value of expr to the unit u (expr -> u)
+type ConvNode struct {
+	expr   AstNode
+	u      unit
+	lineno int
+}
+
+func NewConvNode(expr AstNode, u unit, lineno int) *ConvNode {
+	return &ConvNode{expr, u, lineno}
+}
+
+func (n *ConvNode) String() string {
+	return fmt.Sprintf("ConvNode<%s, %s>", n.expr, n.u)
+}
+
+func (n *ConvNode) Line() int {
+	return n.lineno
+}
+
 type SetOpNode struct {
 	name    string
 	fnOp    BinOpFunc
 	varName string
+	target  *IndexNode // element assigned, nil when assigning to varName
 	op1     AstNode
 	lineno  int
 }
 
 func NewSetOpNode(tok token, varName string, op1 AstNode) *SetOpNode {
-	return &SetOpNode{tok.val, tok.ttype.BinFn, varName, op1, tok.lineno}
+	return &SetOpNode{tok.val, tok.ttype.BinFn, varName, nil, op1, tok.lineno}
+}
+
+func NewSetIndexNode(tok token, target *IndexNode, op1 AstNode) *SetOpNode {
+	return &SetOpNode{tok.val, tok.ttype.BinFn, "", target, op1, tok.lineno}
 }
 
 func (n *SetOpNode) String() string {
+	if n.target != nil {
+		return fmt.Sprintf("SetOpNode<%s, %s, %s>", n.name, n.target, n.op1)
+	}
 	return fmt.Sprintf("SetOpNode<%s, %s, %s>", n.name, n.varName, n.op1)
 }
 
@@ -169,6 +264,69 @@ func (n *SetOpNode) Line() int {
 	return n.lineno
 }
 
+type ListNode struct {
+	elems  []AstNode
+	lineno int
+}
+
+func NewListNode(elems []AstNode, lineno int) *ListNode {
+	return &ListNode{elems, lineno}
+}
+
+func (n *ListNode) String() string {
+	return fmt.Sprintf("ListNode<%s>", n.elems)
+}
+
+func (n *ListNode) Line() int {
+	return n.lineno
+}
+
+type MapNode struct {
+	keys   []AstNode
+	vals   []AstNode
+	lineno int
+}
+
+func NewMapNode(keys, vals []AstNode, lineno int) *MapNode {
+	return &MapNode{keys, vals, lineno}
+}
+
+func (n *MapNode) String() string {
+	return fmt.Sprintf("MapNode<%s, %s>", n.keys, n.vals)
+}
+
+func (n *MapNode) Line() int {
+	return n.lineno
+}
+
+// Indexing (expr[index]) or slicing (expr[lo:hi]) of a value
+type IndexNode struct {
+	expr   AstNode
+	index  AstNode // for slices this is the lower bound, can be nil
+	hi     AstNode // upper bound of a slice, can be nil
+	slice  bool
+	lineno int
+}
+
+func NewIndexNode(expr, index AstNode, lineno int) *IndexNode {
+	return &IndexNode{expr, index, nil, false, lineno}
+}
+
+func NewSliceNode(expr, lo, hi AstNode, lineno int) *IndexNode {
+	return &IndexNode{expr, lo, hi, true, lineno}
+}
+
+func (n *IndexNode) String() string {
+	if n.slice {
+		return fmt.Sprintf("SliceNode<%s, %v, %v>", n.expr, n.index, n.hi)
+	}
+	return fmt.Sprintf("IndexNode<%s, %s>", n.expr, n.index)
+}
+
+func (n *IndexNode) Line() int {
+	return n.lineno
+}
+
 type WhileNode struct {
 	guard  AstNode
 	body   AstNode
@@ -207,6 +365,26 @@ func (n *ForNode) Line() int {
 	return n.lineno
 }
 
+// Iteration over the elements of a list, the keys of a map or the characters of a string
+type ForInNode struct {
+	varName string
+	expr    AstNode
+	body    AstNode
+	lineno  int
+}
+
+func NewForInNode(varName string, expr, body AstNode, lineno int) AstNode {
+	return &ForInNode{varName, expr, body, lineno}
+}
+
+func (n *ForInNode) String() string {
+	return fmt.Sprintf("ForInNode<%s, %s, %s>", n.varName, n.expr, n.body)
+}
+
+func (n *ForInNode) Line() int {
+	return n.lineno
+}
+
 type IfNode struct {
 	guard    AstNode
 	ifBody   AstNode
@@ -231,7 +409,7 @@ func (n *IfNode) Line() int {
 }
 
 type FnDefNode struct {
-	name   string
+	name   string // empty for anonymous functions
 	args   []string
 	body   AstNode
 	lineno int
@@ -265,11 +443,29 @@ func (n *NilNode) Line() int {
 }
 
 type DpyNode struct {
-	expr        AstNode
-	toggleProg  bool
-	changeComma bool
-	commaMode   commaMode
-	lineno      int
+	expr           AstNode
+	toggleProg     bool
+	changeComma    bool
+	commaMode      commaMode
+	floatPrec      uint
+	changeStrict   bool
+	strict         bool
+	changeBits     bool
+	bits           int
+	changeIntMode  bool
+	intMode        intType
+	changeOverflow bool
+	overflow       bool
+	changeScale    bool
+	scale          int
+	changeRounding bool
+	rounding       roundingMode
+	changeModulus  bool
+	modulus        *big.Int // nil disables modular arithmetic
+	defineUnit     bool
+	unitName       string
+	unit           *unitDef
+	lineno         int
 }
 
 func (n *DpyNode) String() string {
@@ -295,3 +491,72 @@ func (n *ExitNode) String() string {
 func (n *ExitNode) Line() int {
 	return n.lineno
 }
+
+// Declaration of local variables, inits contains the initial value of each variable (or nil)
+type LocalNode struct {
+	names  []string
+	inits  []AstNode
+	lineno int
+}
+
+func (n *LocalNode) String() string {
+	return fmt.Sprintf("LocalNode<%s, %s>", n.names, n.inits)
+}
+
+func (n *LocalNode) Line() int {
+	return n.lineno
+}
+
+// Declaration of global variables used by a function
+type GlobalNode struct {
+	names  []string
+	lineno int
+}
+
+func (n *GlobalNode) String() string {
+	return fmt.Sprintf("GlobalNode<%s>", n.names)
+}
+
+func (n *GlobalNode) Line() int {
+	return n.lineno
+}
+
+type ReturnNode struct {
+	expr   AstNode
+	lineno int
+}
+
+func (n *ReturnNode) String() string {
+	if n.expr == nil {
+		return "ReturnNode<>"
+	}
+	return fmt.Sprintf("ReturnNode<%s>", n.expr)
+}
+
+func (n *ReturnNode) Line() int {
+	return n.lineno
+}
+
+type BreakNode struct {
+	lineno int
+}
+
+func (n *BreakNode) String() string {
+	return "BreakNode<>"
+}
+
+func (n *BreakNode) Line() int {
+	return n.lineno
+}
+
+type ContinueNode struct {
+	lineno int
+}
+
+func (n *ContinueNode) String() string {
+	return "ContinueNode<>"
+}
+
+func (n *ContinueNode) Line() int {
+	return n.lineno
+}
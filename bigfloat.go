@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Arbitrary precision elementary functions used in big float mode (@:b).
+// Every function computes with guardBits extra bits of precision and rounds the result to the requested precision,
+// functions return nil when the argument is outside of their (real) domain.
+
+const guardBits = 64
+
+var bigPiCache = map[uint]*big.Float{}
+var bigLn2Cache = map[uint]*big.Float{}
+
+func newBigFloat(prec uint) *big.Float {
+	return new(big.Float).SetPrec(prec)
+}
+
+// Returns true if term is too small to change sum at precision prec
+func negligible(term, sum *big.Float, prec uint) bool {
+	return term.Sign() == 0 || (sum.Sign() != 0 && term.MantExp(nil) < sum.MantExp(nil)-int(prec))
+}
+
+// Sums the series x + s*x**3/3 + x**5/5 + s*x**7/7 + … where s is -1 if alternating is set (atan) and 1 otherwise (atanh)
+func bigOddSeries(x *big.Float, prec uint, alternating bool) *big.Float {
+	x2 := newBigFloat(prec).Mul(x, x)
+	if alternating {
+		x2.Neg(x2)
+	}
+	pow := newBigFloat(prec).Set(x)
+	sum := newBigFloat(prec).Set(x)
+	for k := int64(3); ; k += 2 {
+		pow.Mul(pow, x2)
+		term := newBigFloat(prec).Quo(pow, new(big.Float).SetInt64(k))
+		if negligible(term, sum, prec) {
+			break
+		}
+		sum.Add(sum, term)
+	}
+	return sum
+}
+
+// Returns pi, computed with Machin's formula pi = 16*atan(1/5) - 4*atan(1/239)
+func bigPi(prec uint) *big.Float {
+	if pi, ok := bigPiCache[prec]; ok {
+		return pi
+	}
+	wp := prec + guardBits
+	a := bigOddSeries(newBigFloat(wp).Quo(big.NewFloat(1), big.NewFloat(5)), wp, true)
+	b := bigOddSeries(newBigFloat(wp).Quo(big.NewFloat(1), big.NewFloat(239)), wp, true)
+	a.Mul(a, big.NewFloat(16))
+	b.Mul(b, big.NewFloat(4))
+	pi := newBigFloat(prec).Sub(a, b)
+	bigPiCache[prec] = pi
+	return pi
+}
+
+// Returns ln(2) = 2*atanh(1/3)
+func bigLn2(prec uint) *big.Float {
+	if ln2, ok := bigLn2Cache[prec]; ok {
+		return ln2
+	}
+	wp := prec + guardBits
+	s := bigOddSeries(newBigFloat(wp).Quo(big.NewFloat(1), big.NewFloat(3)), wp, false)
+	ln2 := newBigFloat(prec).Mul(s, big.NewFloat(2))
+	bigLn2Cache[prec] = ln2
+	return ln2
+}
+
+func bigSqrt(x *big.Float, prec uint) *big.Float {
+	if x.Sign() < 0 {
+		return nil
+	}
+	return newBigFloat(prec).Sqrt(x)
+}
+
+func bigExp(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBigFloat(prec).SetInt64(1)
+	}
+	if x.IsInf() {
+		if x.Sign() > 0 {
+			return newBigFloat(prec).SetInf(false)
+		}
+		return newBigFloat(prec)
+	}
+	if f, _ := x.Float64(); math.Abs(f) > 1e9 {
+		// the result doesn't fit the exponent of a big.Float
+		if f > 0 {
+			return newBigFloat(prec).SetInf(false)
+		}
+		return newBigFloat(prec)
+	}
+
+	// x = k*ln2 + r with |r| <= ln2/2, then exp(r) = exp(r/2**m)**(2**m)
+	const m = 16
+	wp := prec + guardBits + m
+	ln2 := bigLn2(wp + 32)
+	kf := newBigFloat(wp).Quo(x, ln2)
+	kf.Add(kf, big.NewFloat(0.5))
+	if kf.Sign() < 0 && !kf.IsInt() {
+		kf.Sub(kf, big.NewFloat(1))
+	}
+	k, _ := kf.Int64()
+	r := newBigFloat(wp+32).Mul(new(big.Float).SetInt64(k), ln2)
+	r.Sub(x, r)
+	r.SetMantExp(r, -m)
+
+	sum := newBigFloat(wp).SetInt64(1)
+	term := newBigFloat(wp).SetInt64(1)
+	for n := int64(1); ; n++ {
+		term.Mul(term, r)
+		term.Quo(term, new(big.Float).SetInt64(n))
+		if negligible(term, sum, wp) {
+			break
+		}
+		sum.Add(sum, term)
+	}
+	for i := 0; i < m; i++ {
+		sum.Mul(sum, sum)
+	}
+	r = newBigFloat(prec).Set(sum)
+	return r.SetMantExp(r, int(k))
+}
+
+// Natural logarithm: x = m*2**e with m in [1/sqrt(2), sqrt(2)), ln(x) = 2*atanh((m-1)/(m+1)) + e*ln(2)
+func bigLog(x *big.Float, prec uint) *big.Float {
+	if x.Sign() <= 0 {
+		return nil
+	}
+	if x.IsInf() {
+		return newBigFloat(prec).SetInf(false)
+	}
+	wp := prec + guardBits
+	m := newBigFloat(wp)
+	e := x.MantExp(m)
+	if m.Cmp(big.NewFloat(math.Sqrt2/2)) < 0 {
+		m.SetMantExp(m, 1)
+		e--
+	}
+	num := newBigFloat(wp).Sub(m, big.NewFloat(1))
+	den := newBigFloat(wp).Add(m, big.NewFloat(1))
+	s := bigOddSeries(num.Quo(num, den), wp, false)
+	s.Mul(s, big.NewFloat(2))
+	if e != 0 {
+		t := newBigFloat(wp).Mul(new(big.Float).SetInt64(int64(e)), bigLn2(wp))
+		s.Add(s, t)
+	}
+	return newBigFloat(prec).Set(s)
+}
+
+func bigLogBase(x *big.Float, base float64, prec uint) *big.Float {
+	l := bigLog(x, prec+guardBits)
+	if l == nil {
+		return nil
+	}
+	b := bigLog(big.NewFloat(base), prec+guardBits)
+	return newBigFloat(prec).Quo(l, b)
+}
+
+// Returns sin(x) and cos(x). The argument is reduced to r = x - n*pi/2 with |r| <= pi/4, then Taylor series are used
+func bigSinCos(x *big.Float, prec uint) (*big.Float, *big.Float) {
+	if x.IsInf() {
+		return nil, nil
+	}
+	wp := prec + guardBits
+	extra := uint(0)
+	if e := x.MantExp(nil); e > 0 {
+		extra = uint(e)
+	}
+	halfpi := newBigFloat(wp+extra).Quo(bigPi(wp+extra), big.NewFloat(2))
+	nf := newBigFloat(wp+extra).Quo(x, halfpi)
+	if nf.Sign() >= 0 {
+		nf.Add(nf, big.NewFloat(0.5))
+	} else {
+		nf.Sub(nf, big.NewFloat(0.5))
+	}
+	n, _ := nf.Int(nil)
+	r := newBigFloat(wp+extra).Mul(new(big.Float).SetInt(n), halfpi)
+	r.Sub(x, r)
+	r.SetPrec(wp)
+
+	r2 := newBigFloat(wp).Mul(r, r)
+	r2.Neg(r2)
+
+	sin := newBigFloat(wp).Set(r)
+	term := newBigFloat(wp).Set(r)
+	for k := int64(2); ; k += 2 {
+		term.Mul(term, r2)
+		term.Quo(term, new(big.Float).SetInt64(k*(k+1)))
+		if negligible(term, sin, wp) {
+			break
+		}
+		sin.Add(sin, term)
+	}
+
+	cos := newBigFloat(wp).SetInt64(1)
+	term.SetInt64(1)
+	for k := int64(1); ; k += 2 {
+		term.Mul(term, r2)
+		term.Quo(term, new(big.Float).SetInt64(k*(k+1)))
+		if negligible(term, cos, wp) {
+			break
+		}
+		cos.Add(cos, term)
+	}
+
+	switch new(big.Int).Mod(n, big.NewInt(4)).Int64() {
+	case 1:
+		sin, cos = cos, sin.Neg(sin)
+	case 2:
+		sin, cos = sin.Neg(sin), cos.Neg(cos)
+	case 3:
+		sin, cos = cos.Neg(cos), sin
+	}
+	return newBigFloat(prec).Set(sin), newBigFloat(prec).Set(cos)
+}
+
+func bigSin(x *big.Float, prec uint) *big.Float {
+	sin, _ := bigSinCos(x, prec)
+	return sin
+}
+
+func bigCos(x *big.Float, prec uint) *big.Float {
+	_, cos := bigSinCos(x, prec)
+	return cos
+}
+
+func bigTan(x *big.Float, prec uint) *big.Float {
+	sin, cos := bigSinCos(x, prec+guardBits)
+	if sin == nil {
+		return nil
+	}
+	return newBigFloat(prec).Quo(sin, cos)
+}
+
+// Arctangent: atan(x) = pi/2 - atan(1/x) for |x| > 1, then the argument is halved three times
+// with atan(x) = 2*atan(x/(1+sqrt(1+x**2))) before using the Taylor series
+func bigAtan(x *big.Float, prec uint) *big.Float {
+	wp := prec + guardBits
+	if x.IsInf() {
+		r := newBigFloat(prec).Quo(bigPi(wp), big.NewFloat(2))
+		if x.Sign() < 0 {
+			r.Neg(r)
+		}
+		return r
+	}
+	neg := x.Sign() < 0
+	y := newBigFloat(wp).Abs(x)
+	inverted := false
+	if y.Cmp(big.NewFloat(1)) > 0 {
+		y.Quo(big.NewFloat(1), y)
+		inverted = true
+	}
+	const halvings = 3
+	for i := 0; i < halvings; i++ {
+		d := newBigFloat(wp).Mul(y, y)
+		d.Add(d, big.NewFloat(1))
+		d.Sqrt(d)
+		d.Add(d, big.NewFloat(1))
+		y.Quo(y, d)
+	}
+	r := bigOddSeries(y, wp, true)
+	r.SetMantExp(r, halvings)
+	if inverted {
+		halfpi := newBigFloat(wp).Quo(bigPi(wp), big.NewFloat(2))
+		r.Sub(halfpi, r)
+	}
+	if neg {
+		r.Neg(r)
+	}
+	return newBigFloat(prec).Set(r)
+}
+
+func bigAsin(x *big.Float, prec uint) *big.Float {
+	wp := prec + guardBits
+	ax := newBigFloat(wp).Abs(x)
+	switch ax.Cmp(big.NewFloat(1)) {
+	case 1:
+		return nil
+	case 0:
+		r := newBigFloat(prec).Quo(bigPi(wp), big.NewFloat(2))
+		if x.Sign() < 0 {
+			r.Neg(r)
+		}
+		return r
+	}
+	d := newBigFloat(wp).Mul(x, x)
+	d.Sub(big.NewFloat(1), d)
+	d.Sqrt(d)
+	return bigAtan(d.Quo(x, d), prec)
+}
+
+func bigAcos(x *big.Float, prec uint) *big.Float {
+	wp := prec + guardBits
+	asin := bigAsin(x, wp)
+	if asin == nil {
+		return nil
+	}
+	r := newBigFloat(wp).Quo(bigPi(wp), big.NewFloat(2))
+	return newBigFloat(prec).Sub(r, asin)
+}
+
+// Returns exp(x) and exp(-x), with enough extra precision to compute their difference for small x
+func bigExpPair(x *big.Float, prec uint) (*big.Float, *big.Float, uint) {
+	wp := smallArgPrec(x, prec)
+	ep := bigExp(x, wp)
+	en := newBigFloat(wp).Quo(big.NewFloat(1), ep)
+	return ep, en, wp
+}
+
+func bigSinh(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBigFloat(prec)
+	}
+	ep, en, wp := bigExpPair(x, prec)
+	r := newBigFloat(wp).Sub(ep, en)
+	return newBigFloat(prec).Quo(r, big.NewFloat(2))
+}
+
+func bigCosh(x *big.Float, prec uint) *big.Float {
+	ep, en, wp := bigExpPair(x, prec)
+	r := newBigFloat(wp).Add(ep, en)
+	return newBigFloat(prec).Quo(r, big.NewFloat(2))
+}
+
+func bigTanh(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBigFloat(prec)
+	}
+	if f, _ := x.Float64(); math.Abs(f) > float64(prec) {
+		// tanh(x) is ±1 at this precision
+		return newBigFloat(prec).SetInt64(int64(x.Sign()))
+	}
+	ep, en, wp := bigExpPair(x, prec)
+	num := newBigFloat(wp).Sub(ep, en)
+	den := newBigFloat(wp).Add(ep, en)
+	return newBigFloat(prec).Quo(num, den)
+}
+
+// Angle of the point (x, y): atan(y/x) moved to the quadrant of the point
+func bigAtan2(y, x *big.Float, prec uint) *big.Float {
+	wp := prec + guardBits
+	if x.Sign() == 0 {
+		if y.Sign() == 0 {
+			return newBigFloat(prec)
+		}
+		r := newBigFloat(prec).Quo(bigPi(wp), big.NewFloat(2))
+		if y.Sign() < 0 {
+			r.Neg(r)
+		}
+		return r
+	}
+	r := bigAtan(newBigFloat(wp).Quo(y, x), wp)
+	if x.Sign() < 0 {
+		if y.Sign() < 0 {
+			r.Sub(r, bigPi(wp))
+		} else {
+			r.Add(r, bigPi(wp))
+		}
+	}
+	return newBigFloat(prec).Set(r)
+}
+
+// Returns the working precision for a function f with f(x) ≈ x near 0, computed from an expression where x is added to
+// numbers near 1: the bits of x lost in the sum are added to the guard bits
+func smallArgPrec(x *big.Float, prec uint) uint {
+	wp := prec + guardBits
+	if e := x.MantExp(nil); e < 0 {
+		wp += uint(-e)
+	}
+	return wp
+}
+
+// exp(x) - 1, accurate for small x
+func bigExpm1(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBigFloat(prec)
+	}
+	wp := smallArgPrec(x, prec)
+	r := bigExp(x, wp)
+	return newBigFloat(prec).Sub(r, big.NewFloat(1))
+}
+
+// ln(1 + x), accurate for small x
+func bigLog1p(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBigFloat(prec)
+	}
+	wp := smallArgPrec(x, prec)
+	r := bigLog(newBigFloat(wp).Add(x, big.NewFloat(1)), wp)
+	if r == nil {
+		return nil
+	}
+	return newBigFloat(prec).Set(r)
+}
+
+// asinh(x) = ln(x + sqrt(x**2 + 1)), computed for |x| since the sum cancels for negative x
+func bigAsinh(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 || x.IsInf() {
+		return newBigFloat(prec).Set(x)
+	}
+	wp := smallArgPrec(x, prec)
+	ax := newBigFloat(wp).Abs(x)
+	d := newBigFloat(wp).Mul(ax, ax)
+	d.Add(d, big.NewFloat(1))
+	d.Sqrt(d)
+	r := bigLog(d.Add(d, ax), wp)
+	if x.Sign() < 0 {
+		r.Neg(r)
+	}
+	return newBigFloat(prec).Set(r)
+}
+
+// acosh(x) = ln(x + sqrt((x - 1)*(x + 1))), defined for x >= 1
+func bigAcosh(x *big.Float, prec uint) *big.Float {
+	if x.Cmp(big.NewFloat(1)) < 0 {
+		return nil
+	}
+	if x.IsInf() {
+		return newBigFloat(prec).Set(x)
+	}
+	wp := prec + guardBits
+	d := newBigFloat(wp).Sub(x, big.NewFloat(1))
+	d.Mul(d, newBigFloat(wp).Add(x, big.NewFloat(1)))
+	d.Sqrt(d)
+	return newBigFloat(prec).Set(bigLog(d.Add(d, x), wp))
+}
+
+// atanh(x) = ln((1 + x)/(1 - x))/2, defined for |x| < 1
+func bigAtanh(x *big.Float, prec uint) *big.Float {
+	if newBigFloat(prec).Abs(x).Cmp(big.NewFloat(1)) >= 0 {
+		return nil
+	}
+	if x.Sign() == 0 {
+		return newBigFloat(prec)
+	}
+	wp := smallArgPrec(x, prec)
+	num := newBigFloat(wp).Add(big.NewFloat(1), x)
+	den := newBigFloat(wp).Sub(big.NewFloat(1), x)
+	r := bigLog(num.Quo(num, den), wp)
+	return newBigFloat(prec).Quo(r, big.NewFloat(2))
+}
+
+// Cube root with Newton's method, starting from the floating point cube root of the mantissa
+func bigCbrt(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 || x.IsInf() {
+		return newBigFloat(prec).Set(x)
+	}
+	wp := prec + guardBits
+	ax := newBigFloat(wp).Abs(x)
+	// x = m*2**(3*k + r) with 0 <= r < 3, cbrt(x) = cbrt(m*2**r)*2**k
+	m := newBigFloat(wp)
+	e := ax.MantExp(m)
+	k := e / 3
+	if e%3 < 0 {
+		k--
+	}
+	mf, _ := m.Float64()
+	y := newBigFloat(wp).SetFloat64(math.Cbrt(math.Ldexp(mf, e-3*k)))
+	y.SetMantExp(y, k)
+	for i := 0; i < 100; i++ {
+		// y = y - (y**3 - x)/(3*y**2)
+		y2 := newBigFloat(wp).Mul(y, y)
+		d := newBigFloat(wp).Mul(y2, y)
+		d.Sub(d, ax)
+		d.Quo(d, y2.Mul(y2, big.NewFloat(3)))
+		y.Sub(y, d)
+		if negligible(d, y, wp) {
+			break
+		}
+	}
+	if x.Sign() < 0 {
+		y.Neg(y)
+	}
+	return newBigFloat(prec).Set(y)
+}
+
+// Rounds x to an integer with rounding mode mode (big.ToNegativeInf for floor, big.ToPositiveInf for ceil)
+func bigRound(x *big.Float, mode big.RoundingMode, lineno int) *big.Int {
+	if x.IsInf() {
+		panic(fmt.Errorf("Can not convert %s to an integer at line %d", x, lineno))
+	}
+	z, acc := x.Int(nil)
+	switch {
+	case acc == big.Below && mode == big.ToPositiveInf:
+		z.Add(z, big.NewInt(1))
+	case acc == big.Above && mode == big.ToNegativeInf:
+		z.Sub(z, big.NewInt(1))
+	}
+	return z
+}
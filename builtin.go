@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"math/cmplx"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 func intAbs(x int64) int64 {
@@ -15,26 +19,80 @@ func intAbs(x int64) int64 {
 	return x
 }
 
-var btnAbs = makeFuncValue(1, func(argv []*value, lineno int) *value {
+var btnAbs = quantityFuncValue(1, makeFuncValue(1, func(argv []*value, lineno int) *value {
 	switch argv[0].kind {
 	case IVAL:
 		v := newZeroVal(IVAL, argv[0].flavor, 0)
 		v.ival.Abs(&argv[0].ival)
-		return v
+		v.inexact = argv[0].inexact
+		return v.setIntType(argv[0].itype, true, "abs", lineno)
 	case RVAL:
 		var r big.Rat
 		r.Abs(&argv[0].rval)
-		return newRatval(r, argv[0].prec)
+		v := newRatval(r, argv[0].prec)
+		v.inexact = argv[0].inexact
+		return v
 	case DVAL:
 		return newFloatval(math.Abs(argv[0].dval), argv[0].flavor)
+	case CVAL:
+		return newFloatval(cmplx.Abs(argv[0].cval), DECFLV)
+	case FVAL:
+		return newBigFloatval(newBigFloat(argv[0].fval.Prec()).Abs(argv[0].fval))
+	case DECVAL:
+		v := newDecimalval(new(big.Int).Abs(&argv[0].ival), argv[0].prec)
+		v.inexact = argv[0].inexact
+		return v
+	case IVLVAL:
+		return newIntervalval(ivAbs(argv[0].ivl))
+	case UVAL:
+		u := argv[0].unc
+		if u.x < 0 {
+			return newUncertainval(uncApply(-u.x, u, -1))
+		}
+		return argv[0]
+	case DUVAL:
+		if argv[0].dual.x.Real(lineno) < 0 {
+			return TokenTypes["-"].UniFn(argv[0], lineno)
+		}
+		return argv[0]
 	}
 	panic(fmt.Errorf("Can not apply abs to non-number value"))
+}))
+
+// Returns 1 if x is an integer, a rational or a decimal number that was computed exactly, 0 otherwise
+var btnExact = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	switch argv[0].kind {
+	case IVAL, RVAL, DECVAL:
+		return newBoolval(!argv[0].inexact)
+	case DVAL, FVAL, CVAL, IVLVAL, UVAL, DUVAL:
+		return newBoolval(false)
+	}
+	panic(badtype("exact", lineno))
 })
 
-func makeFloatFuncValue(fn func(float64) float64) *value {
-	return makeFuncValue(1, func(argv []*value, lineno int) *value {
+// Makes a builtin function from a real function fn, its complex extension cfn and its big float version bfn. Complex
+// arguments use cfn, real arguments outside of the domain of fn (for example sqrt(-1)) use cfn and return a complex number.
+// Big float arguments, and all real arguments in big float mode, use bfn, which returns nil outside of its domain.
+// In rational mode efn computes the exact result, when there is one, otherwise the result is approximated and marked inexact.
+// Intervals use ifn, the interval extension of fn, uncertainties are propagated with dfn, the derivative of fn.
+// Dual numbers apply the function to their value, the derivative is computed with dfn too.
+func makeFloatFuncValue(fn, dfn func(float64) float64, cfn func(complex128) complex128, bfn func(*big.Float, uint) *big.Float, efn func(*big.Rat) *big.Rat, ifn func(interval, int) interval) *value {
+	var f BuiltinFunc
+	f = func(argv []*value, lineno int) *value {
 		kind := argv[0].kind
-		if kind == IVAL {
+		if kind == DUVAL {
+			return dualApplyFunc(f, fn, dfn, argv[0].dual, lineno)
+		}
+		if kind == CVAL {
+			return newComplexval(cfn(argv[0].cval))
+		}
+		if kind == IVLVAL {
+			return newIntervalval(ifn(argv[0].ivl, lineno))
+		}
+		if kind == UVAL {
+			return newUncertainval(uncApplyFunc(fn, dfn, argv[0].unc, lineno))
+		}
+		if kind == IVAL || (kind == RVAL && CommaMode == bigfloatComma) {
 			switch CommaMode {
 			case undefinedComma:
 				panic("real mode undefined, use @:r to select rational or @:f to select floating point")
@@ -42,36 +100,510 @@ func makeFloatFuncValue(fn func(float64) float64) *value {
 				kind = DVAL
 			case rationalComma:
 				kind = RVAL
+			case bigfloatComma:
+				kind = FVAL
+			}
+		}
+		if kind == FVAL {
+			if y := bfn(argv[0].BigFloat(lineno), floatPrec); y != nil {
+				return newBigFloatval(y)
+			}
+			return newComplexval(cfn(argv[0].Complex(lineno)))
+		}
+		if kind == RVAL || kind == DECVAL {
+			if r := efn(argv[0].Rat(lineno)); r != nil {
+				prec := 0
+				if !r.IsInt() {
+					prec = max(12, argv[0].prec)
+				}
+				v := newRatval(*r, prec)
+				if kind == DECVAL {
+					v = newDecimalvalRat(r, argv[0].prec, decimalRounding)
+				}
+				v.inexact = argv[0].inexact
+				return v
 			}
 		}
+		x := argv[0].Real(lineno)
+		y := fn(x)
+		if math.IsNaN(y) && !math.IsNaN(x) {
+			return newComplexval(cfn(complex(x, 0)))
+		}
+		if math.IsInf(y, 0) && (kind == RVAL || kind == DECVAL) {
+			// rationals can not represent infinities
+			return newFloatval(y, argv[0].flavor)
+		}
 		switch kind {
 		case RVAL:
 			var r big.Rat
-			r.SetFloat64(fn(argv[0].Real(lineno)))
-			return newRatval(r, max(12, argv[0].prec))
+			r.SetFloat64(y)
+			v := newRatval(r, max(12, argv[0].prec))
+			v.inexact = true
+			return v
+		case DECVAL:
+			var r big.Rat
+			r.SetFloat64(y)
+			v := newDecimalvalRat(&r, argv[0].prec, decimalRounding)
+			v.inexact = true
+			return v
 		default:
-			return newFloatval(fn(argv[0].Real(lineno)), argv[0].flavor)
+			return newFloatval(y, argv[0].flavor)
+		}
+	}
+	return makeFuncValue(1, f)
+}
+
+// Returns the derivative dfn of fn in x, x is an error where fn is not differentiable: outside of its domain (ln(-1)),
+// at the end of it (sqrt(0)) and at poles
+func funcDeriv(fn, dfn func(float64) float64, x float64, lineno int) float64 {
+	y, d := fn(x), dfn(x)
+	if math.IsNaN(y) || math.IsInf(y, 0) || math.IsNaN(d) || math.IsInf(d, 0) {
+		panic(fmt.Errorf("Function not differentiable at %g at line %d", x, lineno))
+	}
+	return d
+}
+
+// Makes a builtin function from a real function fn that has no complex extension, like makeFloatFuncValue but
+// arguments outside of the domain of fn are errors and complex arguments are not accepted. dfn is the derivative of
+// fn, used to propagate uncertainties and dual numbers.
+func makeRealFuncValue(name string, fn, dfn func(float64) float64, bfn func(*big.Float, uint) *big.Float, efn func(*big.Rat) *big.Rat, ifn func(interval, int) interval) *value {
+	// complex arguments are rejected, cfn is only used outside of the domain of fn, where the result is an error
+	cfn := func(z complex128) complex128 {
+		return complex(fn(real(z)), 0)
+	}
+	f := makeFloatFuncValue(fn, dfn, cfn, bfn, efn, ifn).bval.fn
+	return makeFuncValue(1, func(argv []*value, lineno int) *value {
+		if argv[0].kind == CVAL {
+			panic(fmt.Errorf("Can not apply %s to complex value at line %d", name, lineno))
 		}
+		v := f(argv, lineno)
+		if v.kind == CVAL || (v.kind == DUVAL && v.dual.x.kind == CVAL) {
+			panic(fmt.Errorf("Undefined value %s(%s) at line %d", name, argv[0], lineno))
+		}
+		return v
 	})
 }
 
-var btnAcos = makeFloatFuncValue(math.Acos)
-var btnAsin = makeFloatFuncValue(math.Asin)
-var btnAtan = makeFloatFuncValue(math.Atan)
-var btnCos = makeFloatFuncValue(math.Cos)
-var btnCosh = makeFloatFuncValue(math.Cosh)
-var btnLn = makeFloatFuncValue(math.Log)
-var btnLog10 = makeFloatFuncValue(math.Log10)
-var btnLog2 = makeFloatFuncValue(math.Log2)
-var btnSin = makeFloatFuncValue(math.Sin)
-var btnSinh = makeFloatFuncValue(math.Sinh)
-var btnSqrt = makeFloatFuncValue(math.Sqrt)
-var btnTan = makeFloatFuncValue(math.Tan)
-var btnTanh = makeFloatFuncValue(math.Tanh)
-
-var btnFloor = makeFuncValue(1, func(argv []*value, lineno int) *value {
+// Makes a builtin function of two real arguments from fn, its partial derivatives dfn and its big float version bfn,
+// which returns nil outside of its domain. Without bfn big float results are computed with fn and have the precision
+// of floating point numbers. In rational mode efn computes the exact result when there is one, like in
+// makeFloatFuncValue. Uncertainties and dual numbers are propagated with dfn, arguments outside of the domain of fn
+// are errors.
+func makeFloatFunc2Value(name string, fn func(x, y float64) float64, dfn func(x, y float64) (float64, float64), bfn func(x, y *big.Float, prec uint) *big.Float, efn func(x, y *big.Rat) *big.Rat) *value {
+	var f BuiltinFunc
+	f = func(argv []*value, lineno int) *value {
+		a, b := argv[0], argv[1]
+		undefined := func() {
+			panic(fmt.Errorf("Undefined value %s(%s, %s) at line %d", name, a, b, lineno))
+		}
+		kind := resultKind(a, b)
+		switch kind {
+		case DUVAL:
+			tag := dualTag(a, b)
+			x, y := a.Dual(tag), b.Dual(tag)
+			dx, dy := dfn(x.x.Real(lineno), y.x.Real(lineno))
+			d := magnitudeOp("+", magnitudeOp("*", x.dx, newFloatval(dx, DECFLV), lineno), magnitudeOp("*", y.dx, newFloatval(dy, DECFLV), lineno), lineno)
+			return newDualval(f([]*value{x.x, y.x}, lineno), d, tag)
+		case UVAL:
+			x, y := a.Uncertain(lineno), b.Uncertain(lineno)
+			z := fn(x.x, y.x)
+			if math.IsNaN(z) {
+				panic(fmt.Errorf("Nominal values %g, %g outside of the domain of %s at line %d", x.x, y.x, name, lineno))
+			}
+			dx, dy := dfn(x.x, y.x)
+			return newUncertainval(uncCombine(z, x, dx, y, dy))
+		case IVAL:
+			switch CommaMode {
+			case undefinedComma:
+				panic(fmt.Errorf("Real mode undefined, use @:r to select rational or @:f to select floating point at line %d", lineno))
+			case floatComma:
+				kind = DVAL
+			case rationalComma:
+				kind = RVAL
+			case bigfloatComma:
+				kind = FVAL
+			}
+		case RVAL:
+			if CommaMode == bigfloatComma {
+				kind = FVAL
+			}
+		}
+		if kind == FVAL {
+			if bfn == nil {
+				z := fn(a.Real(lineno), b.Real(lineno))
+				if math.IsNaN(z) {
+					undefined()
+				}
+				return newBigFloatval(new(big.Float).SetFloat64(z))
+			}
+			if z := bfn(a.BigFloat(lineno), b.BigFloat(lineno), floatPrec); z != nil {
+				return newBigFloatval(z)
+			}
+			undefined()
+		}
+		if (kind == RVAL || kind == DECVAL) && efn != nil {
+			if r := efn(a.Rat(lineno), b.Rat(lineno)); r != nil {
+				prec := 0
+				if !r.IsInt() {
+					prec = max(12, a.prec, b.prec)
+				}
+				v := newRatval(*r, prec)
+				if kind == DECVAL {
+					v = newDecimalvalRat(r, max(a.prec, b.prec), decimalRounding)
+				}
+				v.inexact = a.inexact || b.inexact
+				return v
+			}
+		}
+		x, y := a.Real(lineno), b.Real(lineno)
+		z := fn(x, y)
+		if math.IsNaN(z) && !math.IsNaN(x) && !math.IsNaN(y) {
+			undefined()
+		}
+		if !math.IsInf(z, 0) && !math.IsNaN(z) {
+			var r big.Rat
+			switch kind {
+			case RVAL:
+				r.SetFloat64(z)
+				v := newRatval(r, max(12, a.prec, b.prec))
+				v.inexact = true
+				return v
+			case DECVAL:
+				r.SetFloat64(z)
+				v := newDecimalvalRat(&r, max(a.prec, b.prec), decimalRounding)
+				v.inexact = true
+				return v
+			}
+		}
+		return newFloatval(z, DECFLV)
+	}
+	return makeFuncValue(2, f)
+}
+
+// Returns y, computed with floating point numbers, as a number of the current mode: a floating point number, or an
+// inexact rational in rational mode
+func floatResult(y float64, lineno int) *value {
+	switch CommaMode {
+	case undefinedComma:
+		panic(fmt.Errorf("Real mode undefined, use @:r to select rational or @:f to select floating point at line %d", lineno))
+	case rationalComma:
+		if !math.IsInf(y, 0) && !math.IsNaN(y) {
+			var r big.Rat
+			r.SetFloat64(y)
+			v := newRatval(r, 12)
+			v.inexact = true
+			return v
+		}
+	}
+	return newFloatval(y, DECFLV)
+}
+
+var btnAcos = makeFloatFuncValue(math.Acos, func(x float64) float64 { return -1 / math.Sqrt(1-x*x) }, cmplx.Acos, bigAcos, exactAt(1, 0), ivDecreasing(math.Acos, -1, 1))
+var btnAsin = makeFloatFuncValue(math.Asin, func(x float64) float64 { return 1 / math.Sqrt(1-x*x) }, cmplx.Asin, bigAsin, exactAt(0, 0), ivIncreasing(math.Asin, -1, 1))
+var btnAtan = makeFloatFuncValue(math.Atan, func(x float64) float64 { return 1 / (1 + x*x) }, cmplx.Atan, bigAtan, exactAt(0, 0), ivIncreasing(math.Atan, math.Inf(-1), math.Inf(1)))
+var btnCos = makeFloatFuncValue(math.Cos, func(x float64) float64 { return -math.Sin(x) }, cmplx.Cos, bigCos, exactAt(0, 1), ivCos)
+var btnCosh = makeFloatFuncValue(math.Cosh, math.Sinh, cmplx.Cosh, bigCosh, exactAt(0, 1), ivCosh)
+var btnExp = makeFloatFuncValue(math.Exp, math.Exp, cmplx.Exp, bigExp, exactAt(0, 1), ivExp)
+var btnLn = makeFloatFuncValue(math.Log, func(x float64) float64 { return 1 / x }, cmplx.Log, bigLog, exactAt(1, 0), ivLog)
+var btnLog10 = makeFloatFuncValue(math.Log10, func(x float64) float64 { return 1 / (x * math.Ln10) }, cmplx.Log10, func(x *big.Float, prec uint) *big.Float { return bigLogBase(x, 10, prec) }, exactLog(10), ivIncreasing(math.Log10, 0, math.Inf(1)))
+var btnLog2 = makeFloatFuncValue(math.Log2, func(x float64) float64 { return 1 / (x * math.Ln2) }, func(z complex128) complex128 { return cmplx.Log(z) / math.Ln2 }, func(x *big.Float, prec uint) *big.Float { return bigLogBase(x, 2, prec) }, exactLog(2), ivIncreasing(math.Log2, 0, math.Inf(1)))
+var btnSin = makeFloatFuncValue(math.Sin, math.Cos, cmplx.Sin, bigSin, exactAt(0, 0), ivSin)
+var btnSinh = makeFloatFuncValue(math.Sinh, math.Cosh, cmplx.Sinh, bigSinh, exactAt(0, 0), ivIncreasing(math.Sinh, math.Inf(-1), math.Inf(1)))
+var btnSqrt = quantityFuncValue(2, makeFloatFuncValue(math.Sqrt, func(x float64) float64 { return 0.5 / math.Sqrt(x) }, cmplx.Sqrt, bigSqrt, func(x *big.Rat) *big.Rat { return ratRoot(x, 2) }, ivSqrt))
+var btnTan = makeFloatFuncValue(math.Tan, func(x float64) float64 { return 1 + math.Tan(x)*math.Tan(x) }, cmplx.Tan, bigTan, exactAt(0, 0), ivTan)
+var btnTanh = makeFloatFuncValue(math.Tanh, func(x float64) float64 { return 1 - math.Tanh(x)*math.Tanh(x) }, cmplx.Tanh, bigTanh, exactAt(0, 0), ivIncreasing(math.Tanh, math.Inf(-1), math.Inf(1)))
+var btnAsinh = makeFloatFuncValue(math.Asinh, func(x float64) float64 { return 1 / math.Sqrt(x*x+1) }, cmplx.Asinh, bigAsinh, exactAt(0, 0), ivIncreasing(math.Asinh, math.Inf(-1), math.Inf(1)))
+var btnAcosh = makeFloatFuncValue(math.Acosh, func(x float64) float64 { return 1 / math.Sqrt(x*x-1) }, cmplx.Acosh, bigAcosh, exactAt(1, 0), ivIncreasing(math.Acosh, 1, math.Inf(1)))
+var btnAtanh = makeFloatFuncValue(math.Atanh, func(x float64) float64 { return 1 / (1 - x*x) }, cmplx.Atanh, bigAtanh, exactAt(0, 0), ivIncreasing(math.Atanh, -1, 1))
+var btnExpm1 = makeFloatFuncValue(math.Expm1, math.Exp, func(z complex128) complex128 { return cmplx.Exp(z) - 1 }, bigExpm1, exactAt(0, 0), ivIncreasing(math.Expm1, math.Inf(-1), math.Inf(1)))
+var btnLog1p = makeFloatFuncValue(math.Log1p, func(x float64) float64 { return 1 / (1 + x) }, func(z complex128) complex128 { return cmplx.Log(1 + z) }, bigLog1p, exactAt(0, 0), ivIncreasing(math.Log1p, -1, math.Inf(1)))
+
+// The real cube root, the complex extension is real on the negative axis too
+var btnCbrt = quantityFuncValue(3, makeFloatFuncValue(math.Cbrt, func(x float64) float64 { return 1 / (3 * math.Cbrt(x) * math.Cbrt(x)) }, func(z complex128) complex128 {
+	if real(z) < 0 {
+		return -cmplx.Pow(-z, 1.0/3)
+	}
+	return cmplx.Pow(z, 1.0/3)
+}, bigCbrt, func(x *big.Rat) *big.Rat { return ratRoot(x, 3) }, ivIncreasing(math.Cbrt, math.Inf(-1), math.Inf(1))))
+
+// atan2(y, x) is the angle of the point (x, y), between -pi and pi
+var btnAtan2 = makeFloatFunc2Value("atan2", math.Atan2, func(y, x float64) (float64, float64) {
+	r2 := x*x + y*y
+	return x / r2, -y / r2
+}, bigAtan2, func(y, x *big.Rat) *big.Rat {
+	if y.Sign() != 0 || x.Sign() <= 0 {
+		return nil
+	}
+	return new(big.Rat)
+})
+
+var btnHypot = makeFloatFunc2Value("hypot", math.Hypot, func(x, y float64) (float64, float64) {
+	h := math.Hypot(x, y)
+	return x / h, y / h
+}, func(x, y *big.Float, prec uint) *big.Float {
+	wp := prec + guardBits
+	s := newBigFloat(wp).Mul(x, x)
+	s.Add(s, newBigFloat(wp).Mul(y, y))
+	return newBigFloat(prec).Sqrt(s)
+}, func(x, y *big.Rat) *big.Rat {
+	s := new(big.Rat).Mul(x, x)
+	return ratRoot(s.Add(s, new(big.Rat).Mul(y, y)), 2)
+})
+
+var btnLogBase = makeFloatFunc2Value("log", func(x, b float64) float64 {
+	if b == 1 {
+		return math.NaN()
+	}
+	return math.Log(x) / math.Log(b)
+}, func(x, b float64) (float64, float64) {
+	lb := math.Log(b)
+	return 1 / (x * lb), -math.Log(x) / (b * lb * lb)
+}, func(x, b *big.Float, prec uint) *big.Float {
+	wp := prec + guardBits
+	lx, lb := bigLog(x, wp), bigLog(b, wp)
+	if lx == nil || lb == nil || lb.Sign() == 0 {
+		return nil
+	}
+	return newBigFloat(prec).Quo(lx, lb)
+}, exactLogBase)
+
+// log(x) is the natural logarithm, log(x, b) the logarithm in base b
+var btnLog = makeVariadicFuncValue(1, 2, func(argv []*value, lineno int) *value {
+	if len(argv) == 1 {
+		return btnLn.bval.fn(argv, lineno)
+	}
+	return btnLogBase.bval.fn(argv, lineno)
+})
+
+// Exact version of a function that is only rational at x0, where it is y0
+func exactAt(x0, y0 int64) func(*big.Rat) *big.Rat {
+	return func(x *big.Rat) *big.Rat {
+		if x.Cmp(big.NewRat(x0, 1)) != 0 {
+			return nil
+		}
+		return big.NewRat(y0, 1)
+	}
+}
+
+// Exact version of the logarithm in base b, defined on integer powers of b
+func exactLog(b int64) func(*big.Rat) *big.Rat {
+	return func(x *big.Rat) *big.Rat {
+		if x.Sign() <= 0 {
+			return nil
+		}
+		n, sign := new(big.Int).Set(x.Num()), int64(1)
+		if !x.IsInt() {
+			if n.Cmp(big.NewInt(1)) != 0 {
+				return nil
+			}
+			n.Set(x.Denom())
+			sign = -1
+		}
+		var k int64
+		var m big.Int
+		bb := big.NewInt(b)
+		for n.Cmp(big.NewInt(1)) > 0 {
+			n.QuoRem(n, bb, &m)
+			if m.Sign() != 0 {
+				return nil
+			}
+			k++
+		}
+		return big.NewRat(sign*k, 1)
+	}
+}
+
+// Exact logarithm of x in base b, defined when x**q = b**p for integers p and q, q <= 6
+func exactLogBase(x, b *big.Rat) *big.Rat {
+	if x.Sign() <= 0 || b.Sign() <= 0 || b.Cmp(big.NewRat(1, 1)) == 0 {
+		return nil
+	}
+	if x.Cmp(big.NewRat(1, 1)) == 0 {
+		return new(big.Rat)
+	}
+	log2 := func(r *big.Rat) float64 {
+		m := new(big.Float)
+		e := new(big.Float).SetRat(r).MantExp(m)
+		mf, _ := m.Float64()
+		return float64(e) + math.Log2(mf)
+	}
+	lx, lb := log2(x), log2(b)
+	for q := int64(1); q <= 6; q++ {
+		p := math.Round(float64(q) * lx / lb)
+		// b**p must not be too large to compute
+		if p == 0 || math.Abs(p*lb) > 1e6 {
+			continue
+		}
+		xq := ratPow(x, q)
+		bp := ratPow(b, int64(math.Abs(p)))
+		if p < 0 {
+			bp.Inv(bp)
+		}
+		if xq.Cmp(bp) == 0 {
+			return big.NewRat(int64(p), q)
+		}
+	}
+	return nil
+}
+
+// Returns x**n for n >= 0
+func ratPow(x *big.Rat, n int64) *big.Rat {
+	e := big.NewInt(n)
+	num := new(big.Int).Exp(x.Num(), e, nil)
+	return new(big.Rat).SetFrac(num, new(big.Int).Exp(x.Denom(), e, nil))
+}
+
+// Returns the real part of a number
+var btnRe = makeFuncValue(1, func(argv []*value, lineno int) *value {
 	switch argv[0].kind {
-	case RVAL:
+	case CVAL:
+		return newFloatval(real(argv[0].cval), DECFLV)
+	case IVAL, DVAL, RVAL, FVAL, DECVAL:
+		v := *argv[0]
+		return &v
+	}
+	panic(badtype("re", lineno))
+})
+
+// Returns the imaginary part of a number
+var btnIm = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	switch argv[0].kind {
+	case CVAL:
+		return newFloatval(imag(argv[0].cval), DECFLV)
+	case IVAL, DVAL, RVAL, FVAL, DECVAL:
+		return newZeroVal(IVAL, DECFLV, 0)
+	}
+	panic(badtype("im", lineno))
+})
+
+// Returns the argument (phase) of a number, in radians
+var btnArg = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return newFloatval(cmplx.Phase(argv[0].Complex(lineno)), DECFLV)
+})
+
+var btnConj = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	switch argv[0].kind {
+	case CVAL:
+		return newComplexval(cmplx.Conj(argv[0].cval))
+	case IVAL, DVAL, RVAL, FVAL, DECVAL:
+		v := *argv[0]
+		return &v
+	}
+	panic(badtype("conj", lineno))
+})
+
+// Converts a number to polar form, returns the list [modulus, argument]
+var btnPolar = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	r, theta := cmplx.Polar(argv[0].Complex(lineno))
+	return newListval([]*value{newFloatval(r, DECFLV), newFloatval(theta, DECFLV)})
+})
+
+// Converts polar coordinates (modulus and argument) to a complex number
+var btnRect = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	return newComplexval(cmplx.Rect(argv[0].Real(lineno), argv[1].Real(lineno)))
+})
+
+// Makes an interval: interval(x) is the smallest interval containing x, interval(a, b) the interval from a to b
+var btnInterval = makeVariadicFuncValue(1, 2, func(argv []*value, lineno int) *value {
+	x := argv[0].Interval(lineno)
+	if len(argv) > 1 {
+		x.hi = argv[1].Interval(lineno).hi
+	}
+	if !(x.lo <= x.hi) {
+		panic(fmt.Errorf("Empty interval, %s is greater than %s at line %d", fmtbound(x.lo), fmtbound(x.hi), lineno))
+	}
+	return newIntervalval(x)
+})
+
+// Lower bound of an interval
+var btnLo = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return newFloatval(argv[0].Interval(lineno).lo, DECFLV)
+})
+
+// Upper bound of an interval
+var btnHi = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return newFloatval(argv[0].Interval(lineno).hi, DECFLV)
+})
+
+// Midpoint of an interval
+var btnMid = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	x := argv[0].Interval(lineno)
+	return newFloatval(x.lo/2+x.hi/2, DECFLV)
+})
+
+// Width of an interval, rounded up
+var btnWidth = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	x := argv[0].Interval(lineno)
+	return newFloatval(ivSub(interval{x.hi, x.hi}, interval{x.lo, x.lo}).hi, DECFLV)
+})
+
+// Returns 1 if x is certainly true: x is a number different from 0 or an interval that doesn't contain 0.
+// The result of comparisons between overlapping intervals, the interval [0 .. 1], is not certainly true.
+var btnCertainly = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	if argv[0].kind == IVLVAL {
+		return newBoolval(!argv[0].ivl.contains(0))
+	}
+	return newBoolval(argv[0].Bool(lineno))
+})
+
+// Returns 1 if x is possibly true: x is a number different from 0 or an interval other than [0 .. 0]
+var btnPossibly = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	if argv[0].kind == IVLVAL {
+		return newBoolval(argv[0].ivl != interval{0, 0})
+	}
+	return newBoolval(argv[0].Bool(lineno))
+})
+
+// Nominal value of an uncertain value
+var btnNominal = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return newFloatval(argv[0].Uncertain(lineno).x, DECFLV)
+})
+
+// Standard uncertainty of an uncertain value
+var btnUncertainty = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return newFloatval(argv[0].Uncertain(lineno).sigma(), DECFLV)
+})
+
+// Derivative of the function f (user defined or builtin) at x
+var btnDeriv = makeStackFuncValue(2, 2, func(stack []CallFrame, argv []*value, lineno int) *value {
+	one := newBoolval(true)
+	tag := newDualTag()
+	return dualPart(callFunction(stack, argv[0], []*value{newDualval(argv[1], one, tag)}, "deriv", lineno), tag, lineno)
+})
+
+// Gradient of the function f of n arguments at x1…xn, returns the list of the partial derivatives
+var btnGrad = makeStackFuncValue(2, -1, func(stack []CallFrame, argv []*value, lineno int) *value {
+	xs := argv[1:]
+	r := make([]*value, len(xs))
+	for i := range xs {
+		args := make([]*value, len(xs))
+		copy(args, xs)
+		tag := newDualTag()
+		args[i] = newDualval(xs[i], newBoolval(true), tag)
+		r[i] = dualPart(callFunction(stack, argv[0], args, "grad", lineno), tag, lineno)
+	}
+	return newListval(r)
+})
+
+// Symbolic derivative of the user defined function f with respect to its argument named x
+var btnDiff = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	if argv[0].kind != PVAL {
+		panic(fmt.Errorf("Can not differentiate %s, only user defined functions can be differentiated symbolically at line %d", argv[0], lineno))
+	}
+	return symDiff(argv[0], argv[1].Str(lineno), lineno)
+})
+
+// Source code of a user defined function
+var btnSource = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	if argv[0].kind != PVAL {
+		panic(fmt.Errorf("Can not differentiate %s at line %d: not a user defined function", argv[0], lineno))
+	}
+	return newStrval(fnSource(argv[0].nval))
+})
+
+var btnFloor = quantityFuncValue(1, makeFuncValue(1, func(argv []*value, lineno int) *value {
+	switch argv[0].kind {
+	case RVAL, DECVAL:
 		a := argv[0].Rat(lineno)
 		var z, r big.Int
 		z.QuoRem(a.Num(), a.Denom(), &r)
@@ -81,15 +613,21 @@ var btnFloor = makeFuncValue(1, func(argv []*value, lineno int) *value {
 				z.Sub(&z, big.NewInt(1))
 			}
 		}
-		return newIntval(z, DECFLV)
+		v := newIntval(z, DECFLV)
+		v.inexact = argv[0].inexact
+		return v
+	case FVAL:
+		return newIntval(*bigRound(argv[0].fval, big.ToNegativeInf, lineno), DECFLV)
+	case IVLVAL:
+		return newIntervalval(interval{math.Floor(argv[0].ivl.lo), math.Floor(argv[0].ivl.hi)})
 	default:
 		return newIntval(*big.NewInt(int64(math.Floor(argv[0].Real(lineno)))), DECFLV)
 	}
-})
+}))
 
-var btnCeil = makeFuncValue(1, func(argv []*value, lineno int) *value {
+var btnCeil = quantityFuncValue(1, makeFuncValue(1, func(argv []*value, lineno int) *value {
 	switch argv[0].kind {
-	case RVAL:
+	case RVAL, DECVAL:
 		a := argv[0].Rat(lineno)
 		var z, r big.Int
 		z.QuoRem(a.Num(), a.Denom(), &r)
@@ -99,41 +637,456 @@ var btnCeil = makeFuncValue(1, func(argv []*value, lineno int) *value {
 				z.Add(&z, big.NewInt(1))
 			}
 		}
-		return newIntval(z, DECFLV)
+		v := newIntval(z, DECFLV)
+		v.inexact = argv[0].inexact
+		return v
+	case FVAL:
+		return newIntval(*bigRound(argv[0].fval, big.ToPositiveInf, lineno), DECFLV)
+	case IVLVAL:
+		return newIntervalval(interval{math.Ceil(argv[0].ivl.lo), math.Ceil(argv[0].ivl.hi)})
 	default:
 		return newIntval(*big.NewInt(int64(math.Ceil(argv[0].Real(lineno)))), DECFLV)
 	}
+}))
+
+// Rounds x to n digits after the comma (n defaults to 0, a negative n rounds to tens, hundreds…) with the given rounding
+// mode, by default the one selected with @:round. The result has the same kind as x, decimals get a scale of n digits.
+var btnRound = quantityFuncValue(1, makeVariadicFuncValue(1, 3, func(argv []*value, lineno int) *value {
+	x := argv[0]
+	n := 0
+	if len(argv) > 1 {
+		k := argv[1].Int(lineno)
+		if !k.IsInt64() || k.Int64() > 1000 || k.Int64() < -1000 {
+			panic(fmt.Errorf("Invalid number of digits %s for round at line %d", k, lineno))
+		}
+		n = int(k.Int64())
+	}
+	mode := decimalRounding
+	if len(argv) > 2 {
+		var ok bool
+		mode, ok = parseRoundingMode(argv[2].Str(lineno))
+		if !ok {
+			panic(fmt.Errorf("Unknown rounding mode %q, use half-even, half-up, down, up, ceiling or floor at line %d", argv[2].sval, lineno))
+		}
+	}
+
+	switch x.kind {
+	case IVAL, RVAL, DECVAL, DVAL, FVAL:
+	default:
+		panic(badtype("round", lineno))
+	}
+	if x.kind == DVAL && (math.IsInf(x.dval, 0) || math.IsNaN(x.dval)) {
+		return x
+	}
+	q := roundRat(x.Rat(lineno), n, mode)
+	var r big.Rat
+	if n >= 0 {
+		r.SetFrac(q, pow10(n))
+	} else {
+		r.SetInt(q.Mul(q, pow10(-n)))
+	}
+
+	var v *value
+	switch x.kind {
+	case IVAL:
+		v = newIntval(*r.Num(), x.flavor).setIntType(x.itype, false, "round", lineno)
+	case RVAL:
+		v = newRatval(r, max(n, 0))
+	case DECVAL:
+		v = newDecimalvalRat(&r, max(n, 0), mode)
+	case DVAL:
+		f, _ := r.Float64()
+		return newFloatval(f, x.flavor)
+	case FVAL:
+		return newBigFloatval(newBigFloat(x.fval.Prec()).SetRat(&r))
+	}
+	v.inexact = x.inexact
+	return v
+}))
+
+// Makes a cast to the fixed width integer type t, real numbers are truncated towards zero and the result wraps around
+func makeIntCastFuncValue(t intType) *value {
+	return makeFuncValue(1, func(argv []*value, lineno int) *value {
+		var z big.Int
+		flavor := DECFLV
+		switch argv[0].kind {
+		case IVAL:
+			z.Set(&argv[0].ival)
+			flavor = argv[0].flavor
+		case RVAL, DECVAL:
+			r := argv[0].Rat(lineno)
+			z.Quo(r.Num(), r.Denom())
+		case DVAL:
+			if math.IsInf(argv[0].dval, 0) || math.IsNaN(argv[0].dval) {
+				panic(fmt.Errorf("Can not convert %g to %s at line %d", argv[0].dval, t, lineno))
+			}
+			big.NewFloat(argv[0].dval).Int(&z)
+		case FVAL:
+			if argv[0].fval.IsInf() {
+				panic(fmt.Errorf("Can not convert %s to %s at line %d", argv[0].fval, t, lineno))
+			}
+			argv[0].fval.Int(&z)
+		default:
+			panic(badtype(t.String(), lineno))
+		}
+		v := newIntval(z, flavor)
+		v.inexact = argv[0].inexact
+		return v.setIntType(t, false, t.String(), lineno)
+	})
+}
+
+var btnU8 = makeIntCastFuncValue(intType{8, false})
+var btnU16 = makeIntCastFuncValue(intType{16, false})
+var btnU32 = makeIntCastFuncValue(intType{32, false})
+var btnU64 = makeIntCastFuncValue(intType{64, false})
+var btnI8 = makeIntCastFuncValue(intType{8, true})
+var btnI16 = makeIntCastFuncValue(intType{16, true})
+var btnI32 = makeIntCastFuncValue(intType{32, true})
+var btnI64 = makeIntCastFuncValue(intType{64, true})
+
+// Returns the elements of vv, panics if vv is not a list
+func listArg(name string, vv *value, lineno int) []*value {
+	if vv.kind != LVAL {
+		panic(fmt.Errorf("Can not apply %s to non-list value at line %d", name, lineno))
+	}
+	return *vv.lval
+}
+
+// Combines all elements of a list using fn, returns empty for empty lists
+func foldList(name string, vv *value, empty *value, fn BinOpFunc, lineno int) *value {
+	elems := listArg(name, vv, lineno)
+	if len(elems) == 0 {
+		return empty
+	}
+	acc := elems[0]
+	for _, e := range elems[1:] {
+		acc = fn(acc, e, resultKind(acc, e), lineno)
+	}
+	return acc
+}
+
+var btnLen = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	switch argv[0].kind {
+	case SVAL:
+		return newIntval(*big.NewInt(int64(utf8.RuneCountInString(argv[0].sval))), DECFLV)
+	case MVAL:
+		return newIntval(*big.NewInt(int64(len(argv[0].mval.order))), DECFLV)
+	}
+	return newIntval(*big.NewInt(int64(len(listArg("len", argv[0], lineno)))), DECFLV)
+})
+
+var btnPush = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	listArg("push", argv[0], lineno)
+	elem := *argv[1]
+	*argv[0].lval = append(*argv[0].lval, &elem)
+	r := *argv[0]
+	return &r
+})
+
+var btnPop = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	elems := listArg("pop", argv[0], lineno)
+	if len(elems) == 0 {
+		panic(fmt.Errorf("Can not pop from empty list at line %d", lineno))
+	}
+	*argv[0].lval = elems[:len(elems)-1]
+	r := *elems[len(elems)-1]
+	return &r
+})
+
+var btnRange = makeVariadicFuncValue(1, 3, func(argv []*value, lineno int) *value {
+	start, stop, step := big.NewInt(0), argv[0].Int(lineno), big.NewInt(1)
+	if len(argv) >= 2 {
+		start, stop = argv[0].Int(lineno), argv[1].Int(lineno)
+	}
+	if len(argv) >= 3 {
+		step = argv[2].Int(lineno)
+		if step.Sign() == 0 {
+			panic(fmt.Errorf("Range step can not be zero at line %d", lineno))
+		}
+	}
+	elems := []*value{}
+	for i := new(big.Int).Set(start); i.Cmp(stop)*step.Sign() < 0; i.Add(i, step) {
+		elems = append(elems, newIntval(*new(big.Int).Set(i), DECFLV))
+	}
+	return newListval(elems)
 })
 
+var btnSum = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return foldList("sum", argv[0], newZeroVal(IVAL, DECFLV, 0), ADDOPTOK.BinFn, lineno)
+})
+
+var btnProd = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return foldList("prod", argv[0], newIntval(*big.NewInt(1), DECFLV), MULOPTOK.BinFn, lineno)
+})
+
+var btnSort = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	elems := append([]*value{}, listArg("sort", argv[0], lineno)...)
+	sort.SliceStable(elems, func(i, j int) bool {
+		return LTOPTOK.BinFn(elems[i], elems[j], resultKind(elems[i], elems[j]), lineno).Bool(lineno)
+	})
+	return newListval(elems)
+})
+
+var btnReverse = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	elems := listArg("reverse", argv[0], lineno)
+	r := make([]*value, len(elems))
+	for i, e := range elems {
+		r[len(elems)-1-i] = e
+	}
+	return newListval(r)
+})
+
+// Returns the entries of a map argument, panics if vv is not a map
+func mapArg(name string, vv *value, lineno int) *valueMap {
+	if vv.kind != MVAL {
+		panic(fmt.Errorf("Can not apply %s to non-map value at line %d", name, lineno))
+	}
+	return vv.mval
+}
+
+var btnKeys = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return newListval(mapArg("keys", argv[0], lineno).keyList())
+})
+
+var btnValues = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	m := mapArg("values", argv[0], lineno)
+	vals := make([]*value, len(m.order))
+	for i, mk := range m.order {
+		vals[i] = m.vals[mk]
+	}
+	return newListval(vals)
+})
+
+var btnHas = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	_, ok := mapArg("has", argv[0], lineno).get(argv[1], lineno)
+	return newBoolval(ok)
+})
+
+// delete(m, k) removes the key k from m, returns 1 if the key was present
+var btnDelete = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	return newBoolval(mapArg("delete", argv[0], lineno).remove(argv[1], lineno))
+})
+
+// substr(s, start) or substr(s, start, n), start and n count characters, a negative start counts from the end of the string
+var btnSubstr = makeVariadicFuncValue(2, 3, func(argv []*value, lineno int) *value {
+	s := []rune(argv[0].Str(lineno))
+	start := int(argv[1].Int(lineno).Int64())
+	if start < 0 {
+		start += len(s)
+	}
+	start = min(max(start, 0), len(s))
+	end := len(s)
+	if len(argv) > 2 {
+		end = start + max(int(argv[2].Int(lineno).Int64()), 0)
+	}
+	return newStrval(string(s[start:min(end, len(s))]))
+})
+
+var btnStr = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	if argv[0].kind == SVAL {
+		return argv[0]
+	}
+	return newStrval(argv[0].format(false))
+})
+
+// num(s) converts a string to a number, s is read like a literal so "0x1f", "12'000", "1:30" and "$20160101" are all valid
+var btnNum = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	s := argv[0].Str(lineno)
+	pgm, err := parseString(s)
+	if bn, ok := pgm.(*BodyNode); err == nil && ok && len(bn.statements) == 1 {
+		n := bn.statements[0]
+		if un, ok := n.(*UniOpNode); ok && un.name == "-" {
+			n = un.child
+		}
+		if cn, ok := n.(*ConstNode); ok && cn.v.kind != SVAL {
+			return bn.statements[0].Exec(nil)
+		}
+	}
+	panic(fmt.Errorf("Can not convert %q to a number at line %d", s, lineno))
+})
+
+var btnPrintf = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	fmt.Print(formatValues(argv[0].Str(lineno), argv[1:], lineno))
+	return newZeroVal(IVAL, DECFLV, 0)
+})
+
+var btnSprintf = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	return newStrval(formatValues(argv[0].Str(lineno), argv[1:], lineno))
+})
+
+// Formats args according to format, like C's printf. Supported verbs:
+// %v, %s	the value as cala prints it (respecting hex, octal and time flavors, dates and rational precision), strings are printed without quotes
+// %d		integer, with digit grouping
+// %x %X %o %b	integer in hexadecimal, octal or binary, the '#' flag adds the base prefix
+// %f		fixed point with digit grouping, default precision is the precision of the value, rationals are rounded exactly
+// %e %g		exponential notation
+// %t		integer number of seconds as a time (hh:mm:ss)
+// %%		a literal '%'
+// The flags '-' (left align), '0' (zero padding), '+' (always print the sign) and '#' are accepted as well as width and precision.
+func formatValues(format string, args []*value, lineno int) string {
+	var buf bytes.Buffer
+	nextArg := func(verb rune) *value {
+		if len(args) == 0 {
+			panic(fmt.Errorf("Not enough arguments for %%%c in format string at line %d", verb, lineno))
+		}
+		r := args[0]
+		args = args[1:]
+		return r
+	}
+
+	fmtrunes := []rune(format)
+	for i := 0; i < len(fmtrunes); i++ {
+		c := fmtrunes[i]
+		if c != '%' {
+			buf.WriteRune(c)
+			continue
+		}
+
+		flags := ""
+		for i++; i < len(fmtrunes) && strings.ContainsRune("-0+# ", fmtrunes[i]); i++ {
+			flags += string(fmtrunes[i])
+		}
+		width := 0
+		for ; i < len(fmtrunes) && fmtrunes[i] >= '0' && fmtrunes[i] <= '9'; i++ {
+			width = width*10 + int(fmtrunes[i]-'0')
+		}
+		prec := -1
+		if i < len(fmtrunes) && fmtrunes[i] == '.' {
+			prec = 0
+			for i++; i < len(fmtrunes) && fmtrunes[i] >= '0' && fmtrunes[i] <= '9'; i++ {
+				prec = prec*10 + int(fmtrunes[i]-'0')
+			}
+		}
+		if i >= len(fmtrunes) {
+			panic(fmt.Errorf("Incomplete format verb at the end of the format string at line %d", lineno))
+		}
+
+		verb := fmtrunes[i]
+		if verb == '%' {
+			buf.WriteRune('%')
+			continue
+		}
+
+		s := formatValue(nextArg(verb), verb, flags, prec, lineno)
+		buf.WriteString(pad(s, width, flags, verb != 'v' && verb != 's'))
+	}
+
+	if len(args) > 0 {
+		panic(fmt.Errorf("Too many arguments for format string at line %d", lineno))
+	}
+
+	return buf.String()
+}
+
+// Formats a single value for formatValues
+func formatValue(vv *value, verb rune, flags string, prec int, lineno int) string {
+	sign := func(s string) string {
+		if strings.ContainsRune(flags, '+') && !strings.HasPrefix(s, "-") {
+			return "+" + s
+		}
+		return s
+	}
+
+	switch verb {
+	case 'v', 's':
+		if vv.kind == SVAL {
+			return vv.sval
+		}
+		return vv.format(false)
+	case 'd':
+		return sign(fmtnumstr(vv.Int(lineno).String(), false))
+	case 'x', 'X', 'o', 'b':
+		gofmt := "%"
+		if strings.ContainsRune(flags, '#') {
+			gofmt += "#"
+		}
+		return sign(fmt.Sprintf(gofmt+string(verb), vv.Int(lineno)))
+	case 'f':
+		if vv.kind == FVAL {
+			return sign(fmtnumstr(vv.fval.Text('f', prec), false))
+		}
+		if vv.kind == DVAL {
+			return sign(fmtnumstr(strconv.FormatFloat(vv.dval, 'f', prec, 64), false))
+		}
+		if prec < 0 {
+			prec = vv.prec
+		}
+		return sign(fmtnumstr(vv.Rat(lineno).FloatString(prec), false))
+	case 'e', 'g':
+		if vv.kind == FVAL {
+			if prec < 0 && verb == 'g' {
+				prec = bigFloatDigits(vv.fval)
+			}
+			return sign(vv.fval.Text(byte(verb), prec))
+		}
+		return sign(strconv.FormatFloat(vv.Real(lineno), byte(verb), prec, 64))
+	case 't':
+		return newIntval(*vv.Int(lineno), TIMEFLV).format(false)
+	}
+	panic(fmt.Errorf("Unknown verb %%%c in format string at line %d", verb, lineno))
+}
+
+// Pads s to width characters, with spaces on the left (or the right if flags contains '-') or with zeros after the sign if flags contains '0' and numeric is set
+func pad(s string, width int, flags string, numeric bool) string {
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return s
+	}
+	switch {
+	case strings.ContainsRune(flags, '-'):
+		return s + strings.Repeat(" ", n)
+	case strings.ContainsRune(flags, '0') && numeric:
+		if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+			return s[:1] + strings.Repeat("0", n) + s[1:]
+		}
+		return strings.Repeat("0", n) + s
+	default:
+		return strings.Repeat(" ", n) + s
+	}
+}
+
 func hexsplit(s string) string {
 	r := []string{}
 	for i := 0; i < len(s); i += 4 {
-		r = append(r, s[i:i+4])
+		r = append(r, s[i:min(i+4, len(s))])
 	}
 	return strings.Join(r, " ")
 }
 
-func binaryPrint(x uint64) {
-	bc := 6
-	fmt.Printf("%d %d: ", bc+1, bc)
-	for i := 0; i < 64; i++ {
+// Prints the lowest width bits of x (width must be a multiple of 8), 16 bits per line, each line starts with the indexes of its bytes
+func binaryPrint(x uint64, width int) {
+	x <<= uint(64 - width)
+	for i := 0; i < width; i++ {
+		if i%16 == 0 {
+			if bc := (width-i)/8 - 1; bc > 0 {
+				fmt.Printf("%d %d: ", bc, bc-1)
+			} else {
+				fmt.Printf("%d: ", bc)
+			}
+		}
 		bit := (x & 0x8000000000000000) >> 63
 		x <<= 1
 		fmt.Printf("%d", bit)
 		if (i+1)%4 == 0 {
 			fmt.Printf(" ")
 		}
-		if (i+1)%16 == 0 {
+		if (i+1)%16 == 0 || i+1 == width {
 			fmt.Printf("\n")
-			bc -= 2
-			if bc >= 0 {
-				fmt.Printf("%d %d: ", bc+1, bc)
-			}
 		} else if (i+1)%8 == 0 {
 			fmt.Printf("| ")
 		}
 	}
-	//fmt.Printf("\n")
+}
+
+// Returns the two's complement representation of x on width bits, ok is false if x can not be represented
+func twosComplement(x *big.Int, width int) (u uint64, ok bool) {
+	m := new(big.Int).Lsh(big.NewInt(1), uint(width))
+	lo := new(big.Int).Rsh(m, 1)
+	lo.Neg(lo)
+	if x.Cmp(lo) < 0 || x.Cmp(m) >= 0 {
+		return 0, false
+	}
+	return new(big.Int).Mod(x, m).Uint64(), true
 }
 
 func bitfield(x uint64) string {
@@ -188,16 +1141,25 @@ var btnDpy = makeFuncValue(1, func(argv []*value, lineno int) *value {
 		}
 	}
 
+	if argv[0].inexact {
+		fmt.Printf("inexact ")
+	}
 	switch argv[0].kind {
 	case IVAL:
-		fmt.Printf("integer\n")
+		width := 64
+		if t := argv[0].itype; t.bits > 0 {
+			fmt.Printf("integer (%s)\n", t)
+			width = t.bits
+		} else {
+			fmt.Printf("integer\n")
+		}
 		fmt.Printf("dec = %d\n", &argv[0].ival)
 		fmt.Printf("oct = %o\n", &argv[0].ival)
-		if argv[0].ival.BitLen() <= 64 {
-			fmt.Printf("hex = %s\n", hexsplit(fmt.Sprintf("%016X", &argv[0].ival)))
+		if x, ok := twosComplement(&argv[0].ival, width); ok {
+			fmt.Printf("hex = %s\n", hexsplit(fmt.Sprintf("%0*X", width/4, x)))
 			fmt.Printf("bin =\n")
-			binaryPrint(argv[0].ival.Uint64())
-			fmt.Printf("bitfield = %s\n", bitfield(argv[0].ival.Uint64()))
+			binaryPrint(x, width)
+			fmt.Printf("bitfield = %s\n", bitfield(x))
 		} else {
 			fmt.Printf("hex = %X", &argv[0].ival)
 		}
@@ -220,7 +1182,7 @@ var btnDpy = makeFuncValue(1, func(argv []*value, lineno int) *value {
 		x := math.Float64bits(argv[0].dval)
 		fmt.Printf("hex = %s\n", hexsplit(fmt.Sprintf("%016X", x)))
 		fmt.Printf("bin =\n")
-		binaryPrint(x)
+		binaryPrint(x, 64)
 		prefixprint(
 			func(mulby int, tgt int) bool {
 				x := argv[0].dval * float64(mulby)
@@ -247,9 +1209,63 @@ var btnDpy = makeFuncValue(1, func(argv []*value, lineno int) *value {
 
 			})
 
+	case FVAL:
+		x := argv[0].fval
+		fmt.Printf("big float (%d bits)\n", x.Prec())
+		fmt.Printf("dec = %s\n", x.Text('g', bigFloatDigits(x)))
+		fmt.Printf("dec = %s\n", x.Text('e', bigFloatDigits(x)))
+		fmt.Printf("hex = %s\n", x.Text('p', 0))
+
+	case IVLVAL:
+		x := argv[0].ivl
+		mid := x.lo/2 + x.hi/2
+		fmt.Printf("interval\n")
+		fmt.Printf("%s\n", x)
+		fmt.Printf("mid = %s\n", fmtbound(mid))
+		if mid != 0 {
+			fmt.Printf("mid ± %.4g (relative width ±%.4g%%)\n", (x.hi-x.lo)/2, math.Abs((x.hi-x.lo)/2/mid)*100)
+		} else {
+			fmt.Printf("mid ± %.4g\n", (x.hi-x.lo)/2)
+		}
+
+	case UVAL:
+		u := argv[0].unc
+		fmt.Printf("uncertain value\n")
+		fmt.Printf("%s\n", u)
+		fmt.Printf("nominal = %g\n", u.x)
+		fmt.Printf("uncertainty = %g\n", u.sigma())
+		if u.x != 0 {
+			fmt.Printf("relative uncertainty = %.3g%%\n", math.Abs(u.sigma()/u.x)*100)
+		}
+		fmt.Printf("sources of error = %d\n", len(u.deps))
+
+	case QVAL:
+		q := argv[0].qty
+		si := siUnit(q.u.dim)
+		fmt.Printf("quantity\n")
+		fmt.Printf("%s\n", argv[0])
+		fmt.Printf("SI = %s %s\n", q.in(si, lineno), si)
+
+	case DUVAL:
+		fmt.Printf("dual number\n")
+		fmt.Printf("%s\n", argv[0])
+
+	case DECVAL:
+		fmt.Printf("decimal (scale %d, rounding %s)\n", argv[0].prec, decimalRounding)
+		fmt.Printf("dec = %s\n", fmtdecimal(&argv[0].ival, argv[0].prec))
+		fmt.Printf("dec = %s\n", argv[0].Rat(lineno).RatString())
+
+	case CVAL:
+		z := argv[0].cval
+		fmt.Printf("complex\n")
+		fmt.Printf("rect = %s\n", fmtcomplex(z))
+		fmt.Printf("re = %g\nim = %g\n", real(z), imag(z))
+		r, theta := cmplx.Polar(z)
+		fmt.Printf("polar = %g ∠ %g rad (%g°)\n", r, theta, theta*180/math.Pi)
+
 	case PVAL:
 		fmt.Printf("function\n")
-		fmt.Printf("%s\n", argv[0].nval.String())
+		fmt.Printf("%s\n", fnSource(argv[0].nval))
 
 	case BVAL:
 		fmt.Printf("builtin\n")
@@ -257,6 +1273,23 @@ var btnDpy = makeFuncValue(1, func(argv []*value, lineno int) *value {
 	case DTVAL:
 		fmt.Printf("%s\n", argv[0].String())
 
+	case SVAL:
+		fmt.Printf("string of %d characters\n", utf8.RuneCountInString(argv[0].sval))
+		fmt.Printf("%s\n", argv[0].String())
+
+	case LVAL:
+		fmt.Printf("list of %d elements\n", len(*argv[0].lval))
+		for i, e := range *argv[0].lval {
+			fmt.Printf("[%d] = %s\n", i, e)
+		}
+
+	case MVAL:
+		m := argv[0].mval
+		fmt.Printf("map of %d entries\n", len(m.order))
+		for _, mk := range m.order {
+			fmt.Printf("[%s] = %s\n", m.keys[mk], m.vals[mk])
+		}
+
 	default:
 		fmt.Printf("not a number\n")
 	}
@@ -276,23 +1309,151 @@ var btnHelp = makeFuncValue(0, func(argv []*value, lineno int) *value {
 	fmt.Printf("+ - * /\t\tNormal arithmetic operators\n")
 	fmt.Printf("%%\t\tModulo\n")
 	fmt.Printf("**\t\tPower\n")
-	fmt.Printf("|| && !\t\tLogical operators\n")
-	fmt.Printf("| &\t\tBitwise logical operators\n")
+	fmt.Printf("|| && !\t\tLogical operators (the second operand of || and && is evaluated only when needed)\n")
+	fmt.Printf("c ? a : b\tConditional expression, evaluates a if c is true, b otherwise\n")
+	fmt.Printf("| & ^ &^ ~\tBitwise or, and, xor, and not and complement (~ works on the lowest @:bits bits)\n")
+	fmt.Printf("<< >>\t\tShifts (right shift is arithmetic)\n")
 	fmt.Printf("== != < <= >= >\tComparison operators\n")
 	fmt.Printf("var = expr\tAssigns the result of expr to var\n")
 	fmt.Printf("var op= expr\tShorthand for var = var op expr\n")
 	fmt.Printf("\n")
+	fmt.Printf("OPERATOR PRECEDENCE (same as C, from the tightest to the loosest binding):\n")
+	fmt.Printf("! ~ - ++ --\tUnary operators\n")
+	fmt.Printf("**\t\tRight associative: 2**3**2 is 2**(3**2)\n")
+	fmt.Printf("± +/-\t\tUncertainty: 2 * 3 ± 1 is 2 * (3 ± 1)\n")
+	fmt.Printf("* / %%\n")
+	fmt.Printf("+ -\n")
+	fmt.Printf("<< >>\n")
+	fmt.Printf("< <= > >=\n")
+	fmt.Printf("== !=\n")
+	fmt.Printf("& &^\t\tNote that x & 1 == 0 is x & (1 == 0), like in C\n")
+	fmt.Printf("^\n")
+	fmt.Printf("|\n")
+	fmt.Printf("&&\n")
+	fmt.Printf("||\n")
+	fmt.Printf("?:\t\tRight associative\n")
+	fmt.Printf("->\t\tUnit conversion\n")
+	fmt.Printf("= op=\t\tAssignments\n")
+	fmt.Printf("All other binary operators are left associative\n")
+	fmt.Printf("\n")
 	fmt.Printf("BUILTIN FUNCTIONS:\n")
 	fmt.Printf("abs\tacos\tasin\tatan\n")
 	fmt.Printf("cos\tcosh\tfloor\tceil\n")
 	fmt.Printf("ln\tlog10\tlog2\tsin\n")
 	fmt.Printf("sin\tsinh\tsqrt\ttan\n")
-	fmt.Printf("tanh\tdpy\tprint\n")
+	fmt.Printf("tanh\texp\tdpy\tprint\n")
+	fmt.Printf("exact(x)\tReturns 1 if x is an exactly computed integer or rational, results approximated in floating point are printed with a ~ prefix\n")
+	fmt.Printf("\n")
+	fmt.Printf("COMPLEX NUMBERS:\n")
+	fmt.Printf("2.5i 3j\t\tImaginary literals, complex numbers are written as 1+2i\n")
+	fmt.Printf("re\tim\targ\tconj\n")
+	fmt.Printf("polar(z)\tReturns [abs(z), arg(z)]\n")
+	fmt.Printf("rect(r, theta)\tComplex number with modulus r and argument theta\n")
+	fmt.Printf("\t\tsqrt, ln and the other functions return complex results outside of their real domain (for example sqrt(-1))\n")
+	fmt.Printf("\n")
+	fmt.Printf("DECIMALS:\n")
+	fmt.Printf("12.50d\t\tFixed point decimal literal, + and - are exact, * / and ** round to the scale of the operands\n")
+	fmt.Printf("round(x, n, mode)\tRounds x to n digits (default 0) with mode (default the one set with @:round)\n")
+	fmt.Printf("\n")
+	fmt.Printf("INTERVALS:\n")
+	fmt.Printf("interval(a, b)\tInterval from a to b, arithmetic and builtins give bounds guaranteed to contain the exact result\n")
+	fmt.Printf("lo\thi\tmid\twidth\n")
+	fmt.Printf("< == …\t\tComparisons return 1 (certainly true), 0 (certainly false) or [0 .. 1] (possibly true)\n")
+	fmt.Printf("certainly(c)\tpossibly(c)\n")
+	fmt.Printf("\n")
+	fmt.Printf("UNCERTAINTIES:\n")
+	fmt.Printf("x ± u, x +/- u\tValue x with standard uncertainty u, uncertainties are propagated to first order through operators and builtins\n")
+	fmt.Printf("nominal\tuncertainty\n")
+	fmt.Printf("\n")
+	fmt.Printf("UNITS:\n")
+	fmt.Printf("3.3 V, 5 km/h\tA number followed by a unit is a physical quantity, units accept SI prefixes (mA, kΩ, µs)\n")
+	fmt.Printf("x -> unit\tConverts x to unit, for example 5 km/h -> m/s, the dimensions must be the same\n")
+	fmt.Printf("@:unit name = 201.168 m\tDefines a new unit\n")
+	fmt.Printf("\n")
+	fmt.Printf("DERIVATIVES:\n")
+	fmt.Printf("deriv(f, x)\tDerivative of the function f at x, computed exactly with automatic differentiation\n")
+	fmt.Printf("grad(f, x1…)\tList of the partial derivatives of f at x1…xn\n")
+	fmt.Printf("diff(f, \"x\")\tSymbolic derivative of f with respect to its argument x, returns a new function\n")
+	fmt.Printf("source(f)\tSource code of the function f\n")
+	fmt.Printf("\n")
+	fmt.Printf("NUMERICAL ANALYSIS:\n")
+	fmt.Printf("solve(f, a, b)\tRoot of the function f between a and b, f(a) and f(b) must have opposite signs\n")
+	fmt.Printf("integrate(f, a, b)\tIntegral of the function f from a to b\n")
+	fmt.Printf("minimize(f, a, b)\tPosition of a minimum of the function f between a and b\n")
+	fmt.Printf("limit(f, x, dir)\tLimit of the function f at x, from the right if dir > 0, from the left if dir < 0, both if it is omitted\n")
+	fmt.Printf("\n")
+	fmt.Printf("NUMBER THEORY:\n")
+	fmt.Printf("gcd(a, b…)\tGreatest common divisor\tlcm(a, b…)\tLeast common multiple\n")
+	fmt.Printf("modpow(b, e, m)\tb**e mod m\tmodinv(a, m)\tInverse of a modulo m\n")
+	fmt.Printf("isprime(n)\tTrue if n is prime\tnextprime(n)\tSmallest prime larger than n\n")
+	fmt.Printf("factor(n)\tList of the prime factors of n\tphi(n)\tEuler's totient function\n")
+	fmt.Printf("factorial(n)\tn!\t\tbinomial(n, k)\tBinomial coefficient\n")
+	fmt.Printf("isqrt(n)\tInteger square root\tiroot(n, k)\tInteger k-th root\n")
+	fmt.Printf("jacobi(a, n)\tJacobi symbol\tcrt(rs, ms)\tSolution of x = rs[i] mod ms[i] (Chinese remainder theorem)\n")
+	fmt.Printf("\n")
+	fmt.Printf("STATISTICS:\n")
+	fmt.Printf("mean\tmedian\tmode\tvariance\tstddev\tOf a list or of their arguments (variance and stddev of a sample)\n")
+	fmt.Printf("percentile(xs, p)\tp-th percentile of the list xs, interpolating between its values\n")
+	fmt.Printf("covariance(xs, ys)\tcorrelation(xs, ys)\tSample covariance and correlation of two lists\n")
+	fmt.Printf("normpdf(x, mu, sigma)\tnormcdf\tnormquantile\tNormal distribution (mu and sigma can be omitted)\n")
+	fmt.Printf("tpdf(x, nu)\ttcdf\ttquantile\tStudent's t distribution\n")
+	fmt.Printf("chi2pdf(x, k)\tchi2cdf\tchi2quantile\tChi-squared distribution\n")
+	fmt.Printf("binompdf(k, n, p)\tbinomcdf\tbinomquantile\tBinomial distribution\n")
+	fmt.Printf("poissonpdf(k, lambda)\tpoissoncdf\tpoissonquantile\tPoisson distribution\n")
+	fmt.Printf("exppdf(x, lambda)\texpcdf\texpquantile\tExponential distribution\n")
+	fmt.Printf("\n")
+	fmt.Printf("RANDOM NUMBERS:\n")
+	fmt.Printf("rand()\t\tUniform random number between 0 and 1\trandint(a, b)\tRandom integer between a and b (included)\n")
+	fmt.Printf("randn(mu, sigma)\tNormally distributed random number (mu and sigma can be omitted)\n")
+	fmt.Printf("shuffle(xs)\tNew list with the elements of xs in random order\tchoice(xs)\tRandom element of xs\n")
+	fmt.Printf("seed(n)\t\tSeeds the random number generator, cala --seed n seeds it from the command line\n")
+	fmt.Printf("randbits(n)\tCryptographically secure random integer of n bits\n")
+	fmt.Printf("\n")
+	fmt.Printf("SPECIAL FUNCTIONS:\n")
+	fmt.Printf("asinh\tacosh\tatanh\tcbrt\n")
+	fmt.Printf("expm1(x)\texp(x)-1\tlog1p(x)\tln(1+x)\tAccurate for x close to 0\n")
+	fmt.Printf("log(x, b)\tLogarithm of x in base b (the natural logarithm if b is omitted)\n")
+	fmt.Printf("atan2(y, x)\tAngle of the point (x, y)\thypot(x, y)\tsqrt(x**2 + y**2)\n")
+	fmt.Printf("gamma(x)\tlgamma(x)\tGamma function and logarithm of its absolute value\tbeta(a, b)\tBeta function\n")
+	fmt.Printf("erf(x)\terfc(x)\terfinv(x)\tError function, 1-erf(x) and inverse error function\n")
+	fmt.Printf("besselj(n, x)\tbessely(n, x)\tBessel functions of the first and second kind of integer order n\n")
+	fmt.Printf("\n")
+	fmt.Printf("FIXED WIDTH INTEGERS:\n")
+	fmt.Printf("u8(x) … i64(x)\tConverts x to a fixed width integer (u8 u16 u32 u64 i8 i16 i32 i64), reals are truncated\n")
+	fmt.Printf("\t\tArithmetic wraps around (two's complement), mixing types follows C: the widest type wins, unsigned wins with equal widths, division truncates\n")
+	fmt.Printf("\n")
+	fmt.Printf("STRINGS:\n")
+	fmt.Printf("\"text\"\t\tString literal (with the same escape sequences as C), strings can be concatenated with + and compared\n")
+	fmt.Printf("len\tsubstr\tstr\tnum\n")
+	fmt.Printf("printf(fmt, args…)\tPrints its arguments, %%v formats a value the way cala does, %%d %%x %%o %%b %%f %%e %%g %%t are also supported\n")
+	fmt.Printf("sprintf(fmt, args…)\tLike printf but returns a string\n")
+	fmt.Printf("\n")
+	fmt.Printf("LISTS:\n")
+	fmt.Printf("[a, b, c]\tList literal, lists can be concatenated with +\n")
+	fmt.Printf("xs[i]\t\tElement i of xs (counting from 0, negative indexes count from the end), can be assigned\n")
+	fmt.Printf("xs[i:j]\t\tNew list with the elements of xs from i to j (excluded), either bound can be omitted\n")
+	fmt.Printf("len\tpush\tpop\trange\n")
+	fmt.Printf("sum\tprod\tsort\treverse\n")
+	fmt.Printf("\n")
+	fmt.Printf("MAPS:\n")
+	fmt.Printf("{k1: v1, k2: v2}\tMap literal, keys can be numbers, strings or dates\n")
+	fmt.Printf("m[k]\t\tValue associated with k, can be assigned\n")
+	fmt.Printf("len\tkeys\tvalues\thas\tdelete\n")
+	fmt.Printf("for (x in expr) { … }\tIterates over the elements of a list, the keys of a map or the characters of a string\n")
 	fmt.Printf("\n")
 	fmt.Printf("@ expr\t\tDetailed variable view, alias for dpy(expr)\n")
 	fmt.Printf("@:p\t\tToggles programmer mode (in programmer mode results are shown in decimal and hexadecimal\n")
 	fmt.Printf("@:f\t\tToggles float mode (numbers with a comma are interpreted as floating point, division produces a floating point number)\n")
 	fmt.Printf("@:r\t\tToggles rational mode (numbers with a comma and division produce exact results)\n")
+	fmt.Printf("@:scale n\tDecimal literals have at least n digits after the comma (default 2)\n")
+	fmt.Printf("@:round mode\tRounding mode of decimals: half-even (default), half-up, down, up, ceiling or floor\n")
+	fmt.Printf("@:b n\t\tBig float mode, numbers with a comma, division and all builtins use n bits of precision (default 200)\n")
+	fmt.Printf("@:bits n\tSets the number of bits complemented by ~ (default 64, 0 means ~x is -x-1)\n")
+	fmt.Printf("@:u32 @:i8 …\tInteger results wrap around like fixed width integers (u8 u16 u32 u64 i8 i16 i32 i64), @:int goes back to unbounded integers\n")
+	fmt.Printf("@:overflow\tOverflows of fixed width integers are errors instead of wrapping around (@:wrap to go back)\n")
+	fmt.Printf("@:mod n\t\tInteger +, -, *, ** and ++/-- are reduced modulo n, / multiplies by the inverse modulo n (@:mod 0 to disable)\n")
+	fmt.Printf("@:strict\tInside functions assignments to undeclared variables are errors (@:nostrict to disable)\n")
+	fmt.Printf("local a = 1, b\tDeclares local variables, global a, b declares global variables (only inside functions)\n")
 	fmt.Printf("\n")
 	fmt.Printf("DATES AND TIMES:\n")
 	fmt.Printf("Date literals are declared with $yyyymmdd for example $20160101 is 2016-01-01, integers can be added to and subtracted from dates.\n")
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Fixed point decimal numbers (12.50d). A decimal is stored as an unscaled integer in ival and a scale (the number of
+// digits after the comma) in prec, its value is ival / 10**prec. Arithmetic between decimals is exact for + and -,
+// products and quotients are rounded to the scale of the operands with the rounding mode selected by @:round.
+
+type roundingMode uint8
+
+const (
+	roundHalfEven roundingMode = iota // to the nearest, ties to the even neighbour (banker's rounding)
+	roundHalfUp                       // to the nearest, ties away from zero
+	roundDown                         // towards zero
+	roundUp                           // away from zero
+	roundCeiling                      // towards positive infinity
+	roundFloor                        // towards negative infinity
+)
+
+var roundingModeNames = []string{"half-even", "half-up", "down", "up", "ceiling", "floor"}
+
+func (m roundingMode) String() string {
+	return roundingModeNames[m]
+}
+
+// Returns the rounding mode called name (the hyphen is optional, halfeven is half-even)
+func parseRoundingMode(name string) (roundingMode, bool) {
+	for i, n := range roundingModeNames {
+		if strings.ReplaceAll(n, "-", "") == strings.ReplaceAll(strings.ToLower(name), "-", "") {
+			return roundingMode(i), true
+		}
+	}
+	return roundHalfEven, false
+}
+
+func newDecimalval(unscaled *big.Int, scale int) *value {
+	v := &value{kind: DECVAL, prec: scale}
+	v.ival.Set(unscaled)
+	return v
+}
+
+// Returns x as a decimal with the given scale, rounded with mode
+func newDecimalvalRat(x *big.Rat, scale int, mode roundingMode) *value {
+	return newDecimalval(roundRat(x, scale, mode), scale)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Returns x*10**scale rounded to an integer with mode, scale can be negative
+func roundRat(x *big.Rat, scale int, mode roundingMode) *big.Int {
+	num := new(big.Int).Set(x.Num())
+	den := new(big.Int).Set(x.Denom())
+	if scale >= 0 {
+		num.Mul(num, pow10(scale))
+	} else {
+		den.Mul(den, pow10(-scale))
+	}
+
+	var r big.Int
+	q, _ := new(big.Int).QuoRem(num, den, &r)
+	if r.Sign() == 0 {
+		return q
+	}
+
+	sign := int64(num.Sign())
+	// compares the remainder with half of the denominator
+	half := new(big.Int).Abs(&r)
+	half.Lsh(half, 1)
+	cmp := half.Cmp(den)
+
+	away := false
+	switch mode {
+	case roundHalfEven:
+		away = cmp > 0 || (cmp == 0 && q.Bit(0) == 1)
+	case roundHalfUp:
+		away = cmp >= 0
+	case roundDown:
+		away = false
+	case roundUp:
+		away = true
+	case roundCeiling:
+		away = sign > 0
+	case roundFloor:
+		away = sign < 0
+	}
+	if away {
+		q.Add(q, big.NewInt(sign))
+	}
+	return q
+}
+
+// Returns the scale used when a value of a different kind becomes a decimal
+func decimalScaleOf(v *value) int {
+	if v.kind == DECVAL {
+		return v.prec
+	}
+	return decimalScale
+}
+
+// Returns the scale of the result of an operation between a1 and a2
+func decimalResultScale(a1, a2 *value) int {
+	return max(decimalScaleOf(a1), decimalScaleOf(a2))
+}
+
+// Returns the result of a binary operation on decimals, computed exactly by fn and rounded to the scale of the operands
+func decimalOp(a1, a2 *value, lineno int, fn func(z, x, y *big.Rat) *big.Rat) *value {
+	var r big.Rat
+	fn(&r, a1.Rat(lineno), a2.Rat(lineno))
+	return newDecimalvalRat(&r, decimalResultScale(a1, a2), decimalRounding)
+}
+
+// Power of a decimal, the exponent must be an integer and the result is rounded to the scale of the base
+func decimalPow(a1, a2 *value, lineno int) *value {
+	e := a2.Rat(lineno)
+	if !e.IsInt() {
+		panic(fmt.Errorf("Can not raise a decimal to a non integer power at line %d", lineno))
+	}
+	if e.Num().Sign() < 0 && a1.Rat(lineno).Sign() == 0 {
+		panic(fmt.Errorf("Division by zero at line %d", lineno))
+	}
+	x := a1.Rat(lineno)
+	n := new(big.Int).Abs(e.Num())
+	var r big.Rat
+	r.SetFrac(new(big.Int).Exp(x.Num(), n, nil), new(big.Int).Exp(x.Denom(), n, nil))
+	if e.Num().Sign() < 0 {
+		r.Inv(&r)
+	}
+	return newDecimalvalRat(&r, decimalScaleOf(a1), decimalRounding)
+}
+
+// Formats a decimal with exactly scale digits after the comma
+func fmtdecimal(unscaled *big.Int, scale int) string {
+	return fmtnumstr(decimalString(unscaled, scale), false)
+}
+
+// Returns the digits of a decimal with exactly scale digits after the comma, without separators
+func decimalString(unscaled *big.Int, scale int) string {
+	s := new(big.Int).Abs(unscaled).String()
+	if scale > 0 {
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if unscaled.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
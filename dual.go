@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Dual numbers x + dx ε, with ε² = 0, used by deriv and grad for forward mode automatic differentiation: evaluating a
+// function on x + ε computes its value in x and its derivative in dx. Both parts can be numbers of any kind, so that
+// the derivative of a polynomial at a rational point is exact in rational mode. Builtin functions use their analytic
+// derivative.
+// Each call of deriv or grad tags its dual numbers with a new ε, so that nested derivatives don't mix: the dual numbers
+// of an outer derivative are constants for an inner one and end up inside the parts of its dual numbers.
+
+type dual struct {
+	x, dx *value // never modified after creation
+	tag   int    // ε of the deriv or grad call that created the dual number
+}
+
+// Tag of the last dual numbers created by deriv or grad
+var lastDualTag int
+
+func newDualTag() int {
+	lastDualTag++
+	return lastDualTag
+}
+
+func newDualval(x, dx *value, tag int) *value {
+	return &value{kind: DUVAL, dual: dual{x, dx, tag}}
+}
+
+// Returns the value as a dual number with the given tag, numbers and dual numbers with other tags are constants with a
+// zero derivative
+func (vv *value) Dual(tag int) dual {
+	if vv.kind == DUVAL && vv.dual.tag == tag {
+		return vv.dual
+	}
+	return dual{vv, newZeroVal(IVAL, DECFLV, 0), tag}
+}
+
+// Returns the tag of the innermost derivative among the dual numbers in args, tags grow with nesting
+func dualTag(args ...*value) int {
+	tag := 0
+	for _, a := range args {
+		if a.kind == DUVAL && a.dual.tag > tag {
+			tag = a.dual.tag
+		}
+	}
+	return tag
+}
+
+func (d dual) String() string {
+	return d.x.String() + " + " + d.dx.String() + "ε"
+}
+
+// Sum or difference of dual numbers
+func dualAdd(name string, a1, a2 *value, lineno int) *value {
+	tag := dualTag(a1, a2)
+	x, y := a1.Dual(tag), a2.Dual(tag)
+	return newDualval(magnitudeOp(name, x.x, y.x, lineno), magnitudeOp(name, x.dx, y.dx, lineno), tag)
+}
+
+func dualMul(a1, a2 *value, lineno int) *value {
+	tag := dualTag(a1, a2)
+	x, y := a1.Dual(tag), a2.Dual(tag)
+	dx := magnitudeOp("+", magnitudeOp("*", x.dx, y.x, lineno), magnitudeOp("*", x.x, y.dx, lineno), lineno)
+	return newDualval(magnitudeOp("*", x.x, y.x, lineno), dx, tag)
+}
+
+func dualQuo(a1, a2 *value, lineno int) *value {
+	tag := dualTag(a1, a2)
+	x, y := a1.Dual(tag), a2.Dual(tag)
+	num := magnitudeOp("-", magnitudeOp("*", x.dx, y.x, lineno), magnitudeOp("*", x.x, y.dx, lineno), lineno)
+	dx := magnitudeOp("/", num, magnitudeOp("*", y.x, y.x, lineno), lineno)
+	return newDualval(magnitudeOp("/", x.x, y.x, lineno), dx, tag)
+}
+
+// x**y, the derivative is y*x**(y-1)*dx + ln(x)*x**y*dy, the second term is omitted when y is not a dual number
+// so that negative bases work with integer exponents
+func dualPow(a1, a2 *value, lineno int) *value {
+	tag := dualTag(a1, a2)
+	x, y := a1.Dual(tag), a2.Dual(tag)
+	one := newBoolval(true)
+	r := magnitudeOp("**", x.x, y.x, lineno)
+	dx := magnitudeOp("*", magnitudeOp("*", y.x, magnitudeOp("**", x.x, magnitudeOp("-", y.x, one, lineno), lineno), lineno), x.dx, lineno)
+	if a2.kind == DUVAL && a2.dual.tag == tag {
+		ln := newFloatval(math.Log(x.x.Real(lineno)), DECFLV)
+		dx = magnitudeOp("+", dx, magnitudeOp("*", magnitudeOp("*", ln, r, lineno), y.dx, lineno), lineno)
+	}
+	return newDualval(r, dx, tag)
+}
+
+func dualNeg(a1 *value, lineno int) *value {
+	neg := TokenTypes["-"].UniFn
+	return newDualval(neg(a1.dual.x, lineno), neg(a1.dual.dx, lineno), a1.dual.tag)
+}
+
+// Comparisons of dual numbers compare their values
+func dualCompare(name string, a1, a2 *value, lineno int) *value {
+	tag := dualTag(a1, a2)
+	return magnitudeOp(name, a1.Dual(tag).x, a2.Dual(tag).x, lineno)
+}
+
+// Applies the builtin f, the extension of the real function fn with derivative dfn, to a dual number
+func dualApplyFunc(f BuiltinFunc, fn, dfn func(float64) float64, d dual, lineno int) *value {
+	df := funcDeriv(fn, dfn, d.x.Real(lineno), lineno)
+	return newDualval(f([]*value{d.x}, lineno), magnitudeOp("*", d.dx, newFloatval(df, DECFLV), lineno), d.tag)
+}
+
+// Returns the derivative part of the result of a function evaluated on dual numbers with the given tag
+func dualPart(vv *value, tag int, lineno int) *value {
+	switch vv.kind {
+	case DUVAL:
+		if vv.dual.tag == tag {
+			return vv.dual.dx
+		}
+		// a dual number of an outer derivative
+		return newZeroVal(IVAL, DECFLV, 0)
+	case QVAL:
+		return newQuantityval(dualPart(vv.qty.x, tag, lineno), vv.qty.u, lineno)
+	case IVAL, RVAL, DVAL, FVAL, DECVAL, CVAL:
+		// the result does not depend on the argument
+		return newZeroVal(IVAL, DECFLV, 0)
+	}
+	panic(fmt.Errorf("Can not differentiate a function returning %s at line %d", vv, lineno))
+}
@@ -3,14 +3,30 @@ package main
 import (
 	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"math/rand"
 	"strconv"
 )
 
 type CallFrame struct {
-	vars map[string]*value
+	vars    map[string]*value
+	globals map[string]bool // variables declared global by the function
+	outer   *CallFrame      // lexically enclosing environment, nil for functions defined at toplevel
+	flow    controlFlow     // pending non-local control flow, set by return, break and continue
+	retv    *value          // value of the last executed return statement
+	rng     *rand.Rand      // random number generator of the session, only in the global frame
 }
 
+type controlFlow uint8
+
+const (
+	normalFlow controlFlow = iota
+	breakFlow
+	continueFlow
+	returnFlow
+)
+
 type ExecError struct {
 	msg        string
 	stackTrace []string
@@ -51,68 +67,234 @@ func exec(program AstNode) (v *value, err error) {
 }
 
 func NewCallStack() []CallFrame {
-	return []CallFrame{
+	stack := []CallFrame{
 		{
 			vars: map[string]*value{
-				"abs":         btnAbs,
-				"acos":        btnAcos,
-				"asin":        btnAsin,
-				"atan":        btnAtan,
-				"cos":         btnCos,
-				"cosh":        btnCosh,
-				"floor":       btnFloor,
-				"ceil":        btnCeil,
-				"ln":          btnLn,
-				"log10":       btnLog10,
-				"log2":        btnLog2,
-				"sin":         btnSin,
-				"sinh":        btnSinh,
-				"sqrt":        btnSqrt,
-				"tan":         btnTan,
-				"tanh":        btnTanh,
-				"dpy":         btnDpy,
-				"print":       btnPrint,
-				"help":        btnHelp,
-				"_autonumber": &value{kind: IVAL, ival: big.Int{}},
+				"abs":             btnAbs,
+				"acos":            btnAcos,
+				"asin":            btnAsin,
+				"atan":            btnAtan,
+				"cos":             btnCos,
+				"cosh":            btnCosh,
+				"exact":           btnExact,
+				"exp":             btnExp,
+				"floor":           btnFloor,
+				"ceil":            btnCeil,
+				"ln":              btnLn,
+				"log10":           btnLog10,
+				"log2":            btnLog2,
+				"sin":             btnSin,
+				"sinh":            btnSinh,
+				"sqrt":            btnSqrt,
+				"tan":             btnTan,
+				"tanh":            btnTanh,
+				"asinh":           btnAsinh,
+				"acosh":           btnAcosh,
+				"atanh":           btnAtanh,
+				"atan2":           btnAtan2,
+				"hypot":           btnHypot,
+				"expm1":           btnExpm1,
+				"log1p":           btnLog1p,
+				"log":             btnLog,
+				"cbrt":            btnCbrt,
+				"dpy":             btnDpy,
+				"print":           btnPrint,
+				"help":            btnHelp,
+				"len":             btnLen,
+				"keys":            btnKeys,
+				"values":          btnValues,
+				"has":             btnHas,
+				"delete":          btnDelete,
+				"substr":          btnSubstr,
+				"str":             btnStr,
+				"num":             btnNum,
+				"printf":          btnPrintf,
+				"sprintf":         btnSprintf,
+				"push":            btnPush,
+				"pop":             btnPop,
+				"range":           btnRange,
+				"sum":             btnSum,
+				"prod":            btnProd,
+				"sort":            btnSort,
+				"reverse":         btnReverse,
+				"round":           btnRound,
+				"re":              btnRe,
+				"im":              btnIm,
+				"arg":             btnArg,
+				"conj":            btnConj,
+				"polar":           btnPolar,
+				"rect":            btnRect,
+				"interval":        btnInterval,
+				"lo":              btnLo,
+				"hi":              btnHi,
+				"mid":             btnMid,
+				"width":           btnWidth,
+				"certainly":       btnCertainly,
+				"possibly":        btnPossibly,
+				"nominal":         btnNominal,
+				"uncertainty":     btnUncertainty,
+				"deriv":           btnDeriv,
+				"grad":            btnGrad,
+				"diff":            btnDiff,
+				"source":          btnSource,
+				"solve":           btnSolve,
+				"integrate":       btnIntegrate,
+				"minimize":        btnMinimize,
+				"limit":           btnLimit,
+				"gcd":             btnGcd,
+				"lcm":             btnLcm,
+				"modpow":          btnModpow,
+				"modinv":          btnModinv,
+				"isprime":         btnIsprime,
+				"nextprime":       btnNextprime,
+				"factor":          btnFactor,
+				"phi":             btnPhi,
+				"factorial":       btnFactorial,
+				"binomial":        btnBinomial,
+				"isqrt":           btnIsqrt,
+				"iroot":           btnIroot,
+				"jacobi":          btnJacobi,
+				"crt":             btnCrt,
+				"mean":            btnMean,
+				"median":          btnMedian,
+				"mode":            btnMode,
+				"variance":        btnVariance,
+				"stddev":          btnStddev,
+				"percentile":      btnPercentile,
+				"covariance":      btnCovariance,
+				"correlation":     btnCorrelation,
+				"normpdf":         btnNormpdf,
+				"normcdf":         btnNormcdf,
+				"normquantile":    btnNormquantile,
+				"tpdf":            btnTpdf,
+				"tcdf":            btnTcdf,
+				"tquantile":       btnTquantile,
+				"chi2pdf":         btnChi2pdf,
+				"chi2cdf":         btnChi2cdf,
+				"chi2quantile":    btnChi2quantile,
+				"binompdf":        btnBinompdf,
+				"binomcdf":        btnBinomcdf,
+				"binomquantile":   btnBinomquantile,
+				"poissonpdf":      btnPoissonpdf,
+				"poissoncdf":      btnPoissoncdf,
+				"poissonquantile": btnPoissonquantile,
+				"exppdf":          btnExppdf,
+				"expcdf":          btnExpcdf,
+				"expquantile":     btnExpquantile,
+				"rand":            btnRand,
+				"randint":         btnRandint,
+				"randn":           btnRandn,
+				"shuffle":         btnShuffle,
+				"choice":          btnChoice,
+				"seed":            btnSeed,
+				"randbits":        btnRandbits,
+				"gamma":           btnGamma,
+				"lgamma":          btnLgamma,
+				"beta":            btnBeta,
+				"erf":             btnErf,
+				"erfc":            btnErfc,
+				"erfinv":          btnErfinv,
+				"besselj":         btnBesselj,
+				"bessely":         btnBessely,
+				"u8":              btnU8,
+				"u16":             btnU16,
+				"u32":             btnU32,
+				"u64":             btnU64,
+				"i8":              btnI8,
+				"i16":             btnI16,
+				"i32":             btnI32,
+				"i64":             btnI64,
+				"_autonumber":     &value{kind: IVAL, ival: big.Int{}},
 			},
 		},
 	}
+	stack[0].rng = newRandomSource()
+	// assignments change variables in place, each call stack gets its own copy of the builtins so that assigning to
+	// their names does not change them for other programs
+	for name, vv := range stack[0].vars {
+		c := *vv
+		stack[0].vars[name] = &c
+	}
+	return stack
 }
 
-// looks up the value of a variable, note that we implement *static* scoping:
-// A variable must have been defined in the local scope of a function or in the local scope
-// there is no lexical or dynamic scoping
-// If alsoDefine is specified and the variable is not found a new one with that name is created
+// looks up the value of a variable, note that we implement *lexical* scoping:
+// A variable is searched in the local scope of the function, then in the scopes
+// of the functions that lexically enclose it and finally in the global scope.
+// Variables declared global (see GlobalNode) are searched directly in the global scope.
+// If alsoDefine is specified and the variable is not found a new one with that name is created in the local scope
+// (or in the global scope if it was declared global)
 // If alsoDefine is false and the variable is not found lookup panics
 func lookup(stack []CallFrame, name string, alsoDefine bool, lineno int) *value {
-	frame := stack[len(stack)-1]
-	vv, ok := frame.vars[name]
-	if !ok {
-		// lookup global call frame instead
-		vv, ok = stack[0].vars[name]
-		if !ok {
-			if alsoDefine {
-				vv = &value{}
-				frame.vars[name] = vv
-				return vv
-			} else {
-				panic(fmt.Errorf("Unknown variable %s at line %d", name, lineno))
-			}
-		} else {
+	frame := &stack[len(stack)-1]
+	declaredGlobal := false
+	for env := frame; env != nil; env = env.outer {
+		if env.globals[name] {
+			declaredGlobal = true
+			break
+		}
+		if vv, ok := env.vars[name]; ok {
 			return vv
 		}
 	}
+
+	// lookup global call frame instead
+	if vv, ok := stack[0].vars[name]; ok {
+		return vv
+	}
+
+	if !alsoDefine {
+		panic(fmt.Errorf("Unknown variable %s at line %d", name, lineno))
+	}
+
+	vv := &value{}
+	if declaredGlobal {
+		stack[0].vars[name] = vv
+	} else {
+		frame.vars[name] = vv
+	}
 	return vv
 }
 
+// In strict mode assigning to a variable inside a function is an error unless the
+// variable is a parameter, was declared local or global or belongs to an enclosing function
+func checkAssign(stack []CallFrame, name string, lineno int) {
+	if !strictMode || len(stack) <= 1 {
+		return
+	}
+	for env := &stack[len(stack)-1]; env != nil; env = env.outer {
+		if _, ok := env.vars[name]; ok || env.globals[name] {
+			return
+		}
+	}
+	panic(fmt.Errorf("Assignment to undeclared variable %s at line %d (declare it with local or global)", name, lineno))
+}
+
+// Returns the environment a function defined in the current frame should capture
+func captureEnv(stack []CallFrame) *CallFrame {
+	if len(stack) <= 1 {
+		// functions defined at toplevel only see the global scope
+		return nil
+	}
+	frame := stack[len(stack)-1]
+	return &CallFrame{vars: frame.vars, globals: frame.globals, outer: frame.outer}
+}
+
 func (n *BodyNode) Exec(stack []CallFrame) (vv *value) {
+	frame := &stack[len(stack)-1]
 	for _, stmt := range n.statements {
 		vv = stmt.Exec(stack)
+		if frame.flow != normalFlow {
+			break
+		}
 	}
 	return
 }
 
 func (n *UniOpNode) Exec(stack []CallFrame) *value {
+	if vn, ok := n.child.(*VarNode); ok && (n.name == "++" || n.name == "--") {
+		checkAssign(stack, vn.name, n.lineno)
+	}
 	a := n.child.Exec(stack)
 	return n.fn(a, n.lineno)
 }
@@ -123,7 +305,7 @@ func (n *VarNode) Exec(stack []CallFrame) *value {
 
 func (n *FnCallNode) Exec(stack []CallFrame) *value {
 	// retrieves function definition
-	vv := lookup(stack, n.name, false, n.lineno)
+	vv := n.callee.Exec(stack)
 
 	// evaluates arguments
 	argv := make([]*value, len(n.args))
@@ -131,42 +313,59 @@ func (n *FnCallNode) Exec(stack []CallFrame) *value {
 		argv[i] = arg.Exec(stack)
 	}
 
-	switch vv.kind {
+	return callFunction(stack, vv, argv, n.fnName(), n.lineno)
+}
+
+// Calls the function value fnv, user defined or builtin, with the arguments argv. name is the name of the function
+// used in error messages
+func callFunction(stack []CallFrame, fnv *value, argv []*value, name string, lineno int) *value {
+	switch fnv.kind {
 	case PVAL:
-		return functionCall(n, vv.nval, argv, stack)
+		return functionCall(fnv, argv, stack, name, lineno)
 
 	case BVAL:
-		if vv.bval == nil {
-			panic(fmt.Errorf("Can not call '%s' (internal error) at line %d", n.name, n.lineno))
+		if fnv.bval == nil {
+			panic(fmt.Errorf("Can not call '%s' (internal error) at line %d", name, lineno))
+		}
+		if len(argv) < fnv.bval.nargs || (fnv.bval.maxargs >= 0 && len(argv) > fnv.bval.maxargs) {
+			panic(fmt.Errorf("Can not call '%s' at line %d: wrong number of arguments", name, lineno))
 		}
-		if vv.bval.nargs != len(argv) {
-			panic(fmt.Errorf("Can not call '%s' at line %d: wrong number of arguments", n.name, n.lineno))
+		if fnv.bval.stackFn != nil {
+			return fnv.bval.stackFn(stack, argv, lineno)
 		}
-		return vv.bval.fn(argv, n.lineno)
+		return fnv.bval.fn(argv, lineno)
 	}
-	panic(fmt.Errorf("Can not call '%s' at line %d: not a function", n.name, n.lineno))
+	panic(fmt.Errorf("Can not call '%s' at line %d: not a function", name, lineno))
 }
 
-// Calls a user defined function: n is the call node, fn is the function definition node, argv are values to pass as arguments
-func functionCall(n *FnCallNode, fn *FnDefNode, argv []*value, stack []CallFrame) *value {
+// Calls a user defined function: fnv is the function value, argv are values to pass as arguments
+func functionCall(fnv *value, argv []*value, stack []CallFrame, name string, lineno int) *value {
+	fn := fnv.nval
 	if fn == nil {
-		panic(fmt.Errorf("Can not call '%s' (internal error) at line %d", n.name, n.lineno))
+		panic(fmt.Errorf("Can not call '%s' (internal error) at line %d", name, lineno))
 	}
 	if len(fn.args) != len(argv) {
-		panic(fmt.Errorf("Can not call '%s' at line %d: wrong number of arguments (given %d expected %d)", n.name, n.lineno, len(argv), len(fn.args)))
+		panic(fmt.Errorf("Can not call '%s' at line %d: wrong number of arguments (given %d expected %d)", name, lineno, len(argv), len(fn.args)))
 	}
 
 	stack = append(stack, CallFrame{
-		vars: map[string]*value{},
+		vars:    map[string]*value{},
+		globals: map[string]bool{},
+		outer:   fnv.env,
 	})
 
 	newFrame := &stack[len(stack)-1]
 
 	for i, arg := range fn.args {
-		newFrame.vars[arg] = argv[i]
+		// arguments are copied, assigning to a parameter never changes the caller's variables
+		argval := *argv[i]
+		newFrame.vars[arg] = &argval
 	}
 
 	retv := fn.body.Exec(stack)
+	if newFrame.flow == returnFlow {
+		retv = newFrame.retv
+	}
 
 	stack = stack[:len(stack)-1]
 
@@ -177,6 +376,10 @@ func (n *ConstNode) Exec(stack []CallFrame) *value {
 	if (n.v.kind == BVAL) || (n.v.kind == PVAL) {
 		panic(fmt.Errorf("Internal error, a literal function appeared at line %d", n.lineno))
 	}
+	if n.v.kind == DECVAL && n.v.prec < decimalScale {
+		// decimal constants have at least the scale selected with @:scale
+		return newDecimalval(new(big.Int).Mul(&n.v.ival, pow10(decimalScale-n.v.prec)), decimalScale)
+	}
 	vv := n.v
 	return &vv
 }
@@ -188,8 +391,44 @@ func (n *BinOpNode) Exec(stack []CallFrame) *value {
 	return n.fn(a1, a2, kind, n.lineno)
 }
 
+func (n *ShortCircuitNode) Exec(stack []CallFrame) *value {
+	a1 := n.op1.Exec(stack)
+	switch n.name {
+	case "&&":
+		if !a1.Bool(n.lineno) {
+			return newBoolval(false)
+		}
+	case "||":
+		if a1.Bool(n.lineno) {
+			return newBoolval(true)
+		}
+	}
+	a2 := n.op2.Exec(stack)
+	return n.fn(a1, a2, resultKind(a1, a2), n.lineno)
+}
+
+func (n *CondNode) Exec(stack []CallFrame) *value {
+	if n.cond.Exec(stack).Bool(n.cond.Line()) {
+		return n.ifTrue.Exec(stack)
+	}
+	return n.ifFalse.Exec(stack)
+}
+
+func (n *ConvNode) Exec(stack []CallFrame) *value {
+	q := n.expr.Exec(stack).Quantity(n.lineno)
+	x := q.in(n.u, n.lineno)
+	if len(n.u.terms) == 0 {
+		return x
+	}
+	return &value{kind: QVAL, qty: &quantity{x, n.u}}
+}
+
 func (n *SetOpNode) Exec(stack []CallFrame) *value {
+	if n.target != nil {
+		return n.execIndexed(stack)
+	}
 	alsoDefine := (n.name == "=")
+	checkAssign(stack, n.varName, n.lineno)
 	a1 := lookup(stack, n.varName, alsoDefine, n.lineno)
 	a2 := n.op1.Exec(stack)
 	kind := resultKind(a1, a2)
@@ -202,6 +441,133 @@ func (n *SetOpNode) Exec(stack []CallFrame) *value {
 	return &vvv
 }
 
+// Executes an assignment to an element of a list or a map
+func (n *SetOpNode) execIndexed(stack []CallFrame) *value {
+	if n.target.slice {
+		panic(fmt.Errorf("Can not assign to a slice at line %d", n.lineno))
+	}
+	container := n.target.expr.Exec(stack)
+	idx := n.target.index.Exec(stack)
+
+	var a1 *value
+	i := 0
+	if container.kind == MVAL {
+		var ok bool
+		a1, ok = container.mval.get(idx, n.lineno)
+		if !ok && n.fnOp != nil {
+			panic(fmt.Errorf("Key %s not found at line %d", idx, n.lineno))
+		}
+	} else {
+		i = listIndex(container, idx, n.lineno)
+		a1 = (*container.lval)[i]
+	}
+
+	a2 := n.op1.Exec(stack)
+	vv := a2
+	if n.fnOp != nil {
+		vv = n.fnOp(a1, a2, resultKind(a1, a2), n.lineno)
+	}
+	elem := *vv
+	if container.kind == MVAL {
+		container.mval.set(idx, &elem, n.lineno)
+	} else {
+		(*container.lval)[i] = &elem
+	}
+	vvv := elem
+	return &vvv
+}
+
+func (n *MapNode) Exec(stack []CallFrame) *value {
+	m := newMapval()
+	for i := range n.keys {
+		k := n.keys[i].Exec(stack)
+		vv := *n.vals[i].Exec(stack)
+		m.mval.set(k, &vv, n.lineno)
+	}
+	return m
+}
+
+func (n *ListNode) Exec(stack []CallFrame) *value {
+	elems := make([]*value, len(n.elems))
+	for i, e := range n.elems {
+		vv := *e.Exec(stack)
+		elems[i] = &vv
+	}
+	return newListval(elems)
+}
+
+func (n *IndexNode) Exec(stack []CallFrame) *value {
+	vv := n.expr.Exec(stack)
+	if n.slice {
+		var lo, hi *value
+		if n.index != nil {
+			lo = n.index.Exec(stack)
+		}
+		if n.hi != nil {
+			hi = n.hi.Exec(stack)
+		}
+		return listSlice(vv, lo, hi, n.lineno)
+	}
+	idx := n.index.Exec(stack)
+	if vv.kind == MVAL {
+		elem, ok := vv.mval.get(idx, n.lineno)
+		if !ok {
+			panic(fmt.Errorf("Key %s not found at line %d", idx, n.lineno))
+		}
+		r := *elem
+		return &r
+	}
+	elem := *(*vv.lval)[listIndex(vv, idx, n.lineno)]
+	return &elem
+}
+
+// Converts idx to a position in the list vv, negative indexes count from the end of the list
+func listIndex(vv, idx *value, lineno int) int {
+	if vv.kind != LVAL {
+		panic(fmt.Errorf("Can not index non-list value at line %d", lineno))
+	}
+	n := len(*vv.lval)
+	i := idx.Int(lineno)
+	if i.IsInt64() {
+		r := i.Int64()
+		if r < 0 {
+			r += int64(n)
+		}
+		if r >= 0 && r < int64(n) {
+			return int(r)
+		}
+	}
+	panic(fmt.Errorf("Index %s out of range (list has %d elements) at line %d", i, n, lineno))
+}
+
+// Returns the elements of vv from lo (included) to hi (excluded) as a new list, nil bounds select the start and end of the list
+func listSlice(vv, lo, hi *value, lineno int) *value {
+	if vv.kind != LVAL {
+		panic(fmt.Errorf("Can not slice non-list value at line %d", lineno))
+	}
+	n := len(*vv.lval)
+	bound := func(b *value, dflt int) int {
+		if b == nil {
+			return dflt
+		}
+		i := b.Int(lineno)
+		if !i.IsInt64() {
+			panic(fmt.Errorf("Slice bound %s out of range at line %d", i, lineno))
+		}
+		r := int(i.Int64())
+		if r < 0 {
+			r += n
+		}
+		return min(max(r, 0), n)
+	}
+	i, j := bound(lo, 0), bound(hi, n)
+	elems := []*value{}
+	if i < j {
+		elems = append(elems, (*vv.lval)[i:j]...)
+	}
+	return newListval(elems)
+}
+
 func (n *WhileNode) Exec(stack []CallFrame) (vv *value) {
 	vv = newZeroVal(IVAL, DECFLV, 0)
 	for {
@@ -210,10 +576,29 @@ func (n *WhileNode) Exec(stack []CallFrame) (vv *value) {
 			break
 		}
 		vv = n.body.Exec(stack)
+		if loopFlow(stack) {
+			break
+		}
 	}
 	return
 }
 
+// Consumes the break or continue pending on the current frame, returns true if the loop must be exited
+func loopFlow(stack []CallFrame) bool {
+	frame := &stack[len(stack)-1]
+	switch frame.flow {
+	case breakFlow:
+		frame.flow = normalFlow
+		return true
+	case continueFlow:
+		frame.flow = normalFlow
+		return false
+	case returnFlow:
+		return true
+	}
+	return false
+}
+
 func (n *ForNode) Exec(stack []CallFrame) (vv *value) {
 	vv = newZeroVal(IVAL, DECFLV, 0)
 
@@ -225,12 +610,45 @@ func (n *ForNode) Exec(stack []CallFrame) (vv *value) {
 			break
 		}
 		vv = n.body.Exec(stack)
+		if loopFlow(stack) {
+			break
+		}
 		n.incrExpr.Exec(stack)
 	}
 
 	return
 }
 
+func (n *ForInNode) Exec(stack []CallFrame) (vv *value) {
+	vv = newZeroVal(IVAL, DECFLV, 0)
+
+	var items []*value
+	coll := n.expr.Exec(stack)
+	switch coll.kind {
+	case LVAL:
+		items = append(items, *coll.lval...)
+	case MVAL:
+		items = coll.mval.keyList()
+	case SVAL:
+		for _, c := range coll.sval {
+			items = append(items, newStrval(string(c)))
+		}
+	default:
+		panic(fmt.Errorf("Can not iterate over value %s at line %d", coll, n.lineno))
+	}
+
+	checkAssign(stack, n.varName, n.lineno)
+	for _, item := range items {
+		*lookup(stack, n.varName, true, n.lineno) = *item
+		vv = n.body.Exec(stack)
+		if loopFlow(stack) {
+			break
+		}
+	}
+
+	return
+}
+
 func (n *IfNode) Exec(stack []CallFrame) *value {
 	ev := n.guard.Exec(stack)
 
@@ -245,10 +663,10 @@ func (n *IfNode) Exec(stack []CallFrame) *value {
 }
 
 func (n *FnDefNode) Exec(stack []CallFrame) *value {
-	frame := stack[len(stack)-1]
-	vv := newZeroVal(PVAL, DECFLV, 0)
-	vv.nval = n
-	frame.vars[n.name] = vv
+	vv := newFnval(n, captureEnv(stack))
+	if n.name != "" {
+		stack[len(stack)-1].vars[n.name] = vv
+	}
 	return vv
 }
 
@@ -264,6 +682,41 @@ func (n *DpyNode) Exec(callStack []CallFrame) *value {
 
 	case n.changeComma:
 		CommaMode = n.commaMode
+		if n.commaMode == bigfloatComma {
+			floatPrec = n.floatPrec
+		}
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.changeStrict:
+		strictMode = n.strict
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.changeBits:
+		bitWidth = n.bits
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.changeIntMode:
+		intMode = n.intMode
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.changeOverflow:
+		overflowError = n.overflow
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.changeScale:
+		decimalScale = n.scale
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.changeRounding:
+		decimalRounding = n.rounding
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.changeModulus:
+		modulus = n.modulus
+		return newZeroVal(IVAL, DECFLV, 0)
+
+	case n.defineUnit:
+		unitTable[n.unitName] = n.unit
 		return newZeroVal(IVAL, DECFLV, 0)
 
 	default:
@@ -277,12 +730,82 @@ func (n *ExitNode) Exec(callStack []CallFrame) *value {
 	return newZeroVal(IVAL, DECFLV, 0)
 }
 
+func (n *LocalNode) Exec(stack []CallFrame) *value {
+	frame := &stack[len(stack)-1]
+	vv := newZeroVal(IVAL, DECFLV, 0)
+	for i, name := range n.names {
+		vv = newZeroVal(IVAL, DECFLV, 0)
+		if n.inits[i] != nil {
+			init := *n.inits[i].Exec(stack)
+			vv = &init
+		}
+		delete(frame.globals, name)
+		frame.vars[name] = vv
+	}
+	vvv := *vv
+	return &vvv
+}
+
+func (n *GlobalNode) Exec(stack []CallFrame) *value {
+	frame := &stack[len(stack)-1]
+	for _, name := range n.names {
+		if _, ok := frame.vars[name]; ok {
+			panic(fmt.Errorf("Can not declare %s global at line %d: it is already a local variable", name, n.lineno))
+		}
+		frame.globals[name] = true
+	}
+	return newZeroVal(IVAL, DECFLV, 0)
+}
+
+func (n *ReturnNode) Exec(stack []CallFrame) *value {
+	vv := newZeroVal(IVAL, DECFLV, 0)
+	if n.expr != nil {
+		vv = n.expr.Exec(stack)
+	}
+	frame := &stack[len(stack)-1]
+	frame.flow = returnFlow
+	frame.retv = vv
+	return vv
+}
+
+func (n *BreakNode) Exec(stack []CallFrame) *value {
+	stack[len(stack)-1].flow = breakFlow
+	return newZeroVal(IVAL, DECFLV, 0)
+}
+
+func (n *ContinueNode) Exec(stack []CallFrame) *value {
+	stack[len(stack)-1].flow = continueFlow
+	return newZeroVal(IVAL, DECFLV, 0)
+}
+
 func (vv *value) Bool(lineno int) bool {
 	switch vv.kind {
 	case IVAL:
 		return vv.ival.Cmp(big.NewInt(0)) != 0
-	case DVAL:
+	case DVAL, FVAL, DECVAL:
 		panic(fmt.Errorf("Real value can not be used as boolean at line %d", lineno))
+	case LVAL:
+		panic(fmt.Errorf("List value can not be used as boolean at line %d", lineno))
+	case SVAL:
+		panic(fmt.Errorf("String value can not be used as boolean at line %d", lineno))
+	case MVAL:
+		panic(fmt.Errorf("Map value can not be used as boolean at line %d", lineno))
+	case CVAL:
+		panic(fmt.Errorf("Complex value can not be used as boolean at line %d", lineno))
+	case IVLVAL:
+		switch {
+		case !vv.ivl.contains(0):
+			return true
+		case vv.ivl.lo == vv.ivl.hi:
+			return false
+		}
+		panic(fmt.Errorf("Interval %s can not be used as boolean at line %d, use certainly or possibly", vv.ivl, lineno))
+	case UVAL:
+		panic(fmt.Errorf("Uncertain value can not be used as boolean at line %d", lineno))
+	case QVAL:
+		panic(fmt.Errorf("Quantity can not be used as boolean at line %d", lineno))
+	case DUVAL:
+		return vv.dual.x.Bool(lineno)
 	default:
 		panic(fmt.Errorf("Function value can not be used as boolean at line %d\n", lineno))
 	}
@@ -296,6 +819,13 @@ func (vv *value) Int(lineno int) *big.Int {
 	return &vv.ival
 }
 
+func (vv *value) Str(lineno int) string {
+	if vv.kind != SVAL {
+		panic(fmt.Errorf("Can not use non-string value as string at line %d", lineno))
+	}
+	return vv.sval
+}
+
 func (vv *value) Real(lineno int) float64 {
 	switch vv.kind {
 	case IVAL:
@@ -306,10 +836,59 @@ func (vv *value) Real(lineno int) float64 {
 	case RVAL:
 		f, _ := vv.rval.Float64()
 		return f
+	case DECVAL:
+		f, _ := vv.Rat(lineno).Float64()
+		return f
+	case FVAL:
+		f, _ := vv.fval.Float64()
+		return f
+	case CVAL:
+		if imag(vv.cval) == 0 {
+			return real(vv.cval)
+		}
+		panic(fmt.Errorf("Can not use complex value as real at line %d", lineno))
+	case IVLVAL:
+		panic(fmt.Errorf("Can not use interval as real at line %d, use lo, hi or mid", lineno))
+	case UVAL:
+		panic(fmt.Errorf("Can not use uncertain value as real at line %d, use nominal or uncertainty", lineno))
+	case QVAL:
+		panic(fmt.Errorf("Can not use quantity %s as a number at line %d, divide it by its unit", vv, lineno))
+	case DUVAL:
+		panic(fmt.Errorf("Can not use dual number %s as real at line %d", vv, lineno))
 	}
 	panic(fmt.Errorf("Can not use non-number value as real at line %d", lineno))
 }
 
+// Returns the value as a big float with the precision of big float mode (see @:b)
+func (vv *value) BigFloat(lineno int) *big.Float {
+	r := newBigFloat(floatPrec)
+	switch vv.kind {
+	case IVAL:
+		return r.SetInt(&vv.ival)
+	case RVAL:
+		return r.SetRat(&vv.rval)
+	case DECVAL:
+		return r.SetRat(vv.Rat(lineno))
+	case FVAL:
+		return vv.fval
+	case DVAL:
+		if math.IsNaN(vv.dval) {
+			panic(fmt.Errorf("Can not use NaN as a big float at line %d", lineno))
+		}
+		return r.SetFloat64(vv.dval)
+	case CVAL:
+		return r.SetFloat64(vv.Real(lineno))
+	}
+	panic(fmt.Errorf("Can not use non-number value as real at line %d", lineno))
+}
+
+func (vv *value) Complex(lineno int) complex128 {
+	if vv.kind == CVAL {
+		return vv.cval
+	}
+	return complex(vv.Real(lineno), 0)
+}
+
 func (vv *value) Rat(lineno int) *big.Rat {
 	switch vv.kind {
 	case IVAL:
@@ -318,10 +897,18 @@ func (vv *value) Rat(lineno int) *big.Rat {
 		return &r
 	case RVAL:
 		return &vv.rval
+	case DECVAL:
+		return new(big.Rat).SetFrac(&vv.ival, pow10(vv.prec))
 	case DVAL:
 		var r big.Rat
 		r.SetFloat64(vv.dval)
 		return &r
+	case FVAL:
+		r, _ := vv.fval.Rat(nil)
+		if r == nil {
+			panic(fmt.Errorf("Can not use infinity as rational at line %d", lineno))
+		}
+		return r
 	}
 	panic(fmt.Errorf("Can not use non-number value as real at line %d", lineno))
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"math"
+	"strings"
 	"testing"
 )
 
@@ -127,8 +128,8 @@ func TestExecOps(t *testing.T) {
 	testExecRat(t, "2.1**4", "19.4481")
 	testExecRat(t, "2**-2", "0.25")
 	testExecRat(t, "2.2**-2", "0.206611570248")
-	testExecRat(t, "2**0.5", "1.414213562373")
-	testExecRat(t, "2**(1/2)", "1.414213562373")
+	testExecRat(t, "2**0.5", "~1.414213562373")
+	testExecRat(t, "2**(1/2)", "~1.414213562373")
 	testExecInt(t, "10.3 == 11.3", 0)
 	testExecInt(t, "11.3 == 11.3", 1)
 	testExecInt(t, "10.3 != 11.3", 1)
@@ -141,6 +142,259 @@ func TestExecOps(t *testing.T) {
 	testExecInt(t, "11.5 < 10.9", 0)
 	testExecInt(t, "10.2 <= 11.1", 1)
 	testExecInt(t, "11.5 <= 10.9", 0)
+	testExecInt(t, "2**3**2", 512)
+	testExecInt(t, "7 - 2 - 1", 4)
+	testExecInt(t, "12 % 5 * 3", 6)
+	testExecInt(t, "6 & 3 == 3", 0)
+	testExecInt(t, "(6 & 3) == 2", 1)
+}
+
+func TestBitOps(t *testing.T) {
+	testExecInt(t, "1 << 10", 1024)
+	testExecInt(t, "1024 >> 3", 128)
+	testExecInt(t, "-5 >> 1", -3)
+	testExecInt(t, "6 ^ 3", 5)
+	testExecInt(t, "0xff &^ 0x0f", 0xf0)
+	testExecInt(t, "1 + 1 << 2", 8)
+	testExecInt(t, "x = 1; x <<= 4; x >>= 1; x ^= 3; x &^= 8; x |= 16; x &= 0x13; x", 0x13)
+	testExecInt(t, "x = 3; x **= 3; x", 27)
+	testExecPrint(t, "0x10 << 4", "0x100")
+	testExecPrint(t, "0x10 ^ 1", "0x11")
+
+	defer func() { bitWidth = 64 }()
+	testExecPrint(t, "~0", "18'446'744'073'709'551'615")
+	testExecInt(t, "@:bits 8; ~1", 254)
+	testExecInt(t, "@:bits 8; ~0x1ff", 0)
+	testExecInt(t, "@:bits 0; ~5", -6)
+	testExecError(t, "1 << -1", "Invalid shift count")
+	testExecError(t, "1.5 ^ 1", "non-integer")
+}
+
+func TestFixedWidth(t *testing.T) {
+	testExecInt(t, "u8(300)", 44)
+	testExecInt(t, "i8(200)", -56)
+	testExecInt(t, "i8(127) + 1", -128)
+	testExecInt(t, "u8(0) - 1", 255)
+	testExecInt(t, "i16(-1) + u16(0)", 65535)
+	testExecInt(t, "u8(-3.9)", 253)
+	testExecInt(t, "i32(7/2)", 3)
+	testExecInt(t, "x = u8(254); x++; x++; x", 0)
+	testExecInt(t, "~u8(1)", 254)
+	testExecInt(t, "~i8(1)", -2)
+	testExecInt(t, "i8(-128) >> 1", -64)
+	testExecInt(t, "u8(0x80) >> 1", 64)
+	testExecInt(t, "u8(1) << 9", 0)
+	testExecInt(t, "i8(-1) < u8(1)", 0)
+	testExecInt(t, "u16(3) ** 40", 59425)
+	testExecInt(t, "-i8(-128)", -128)
+	testExecInt(t, "u8(7) / u8(2)", 3)
+	testExecInt(t, "i8(-7) / 2", -3)
+	testExecInt(t, "i8(-7) % 2 == -1", 1)
+	testExecInt(t, "a = i8(-7); b = i8(2); (a / b) * b + a % b == a", 1)
+	testExecInt(t, "i8(-128) / i8(-1)", -128)
+	testExecError(t, "u8(1) / 0", "Division by zero at line 1")
+	testExecInt(t, "u64(1) << 64", 0)
+	testExecPrint(t, "u32(0xFFFFFFFF) + 1", "0x0")
+
+	defer func() {
+		intMode = intType{}
+		overflowError = false
+	}()
+	testExecInt(t, "@:u32; 0xFFFFFFFF + 1", 0)
+	testExecInt(t, "@:u32; -1", 4294967295)
+	testExecInt(t, "@:i16; 32767 + 1", -32768)
+	testExecInt(t, "@:i32; -7 % 2 == -1", 1)
+	testExecInt(t, "@:i16; u8(255) + 1", 256)
+	testExecInt(t, "@:int; 0xFFFFFFFF + 1", 0x100000000)
+	testExecError(t, "@:overflow; u8(255) + 1", "Integer overflow in +, 256 does not fit u8")
+	testExecError(t, "@:overflow; i8(-128) * -1", "Integer overflow")
+	testExecInt(t, "@:overflow; u8(255) & 0xf", 15)
+	testExecError(t, "@:overflow; i8(-128) / i8(-1)", "Integer overflow in /")
+	testExecError(t, "@:overflow; x = u8(255); x++", "Integer overflow in ++")
+	// a variable that would overflow is not changed
+	x := execString(t, "@:overflow; u8(255)")
+	func() {
+		defer func() { recover() }()
+		INCOPTOK.UniFn(x, 1)
+	}()
+	if x.String() != "255" {
+		t.Fatalf("Value changed by overflowing ++: %s\n", x)
+	}
+	testExecInt(t, "@:wrap; u8(255) + 1", 0)
+}
+
+func TestComplex(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecPrint(t, "@:f; sqrt(-1)", "1i")
+	testExecPrint(t, "(1+2i)*(3-1j)", "5+5i")
+	testExecPrint(t, "1/1i", "-1i")
+	testExecPrint(t, "(2i)**2", "-4+0i")
+	testExecPrint(t, "1i**-1", "-1i")
+	testExecPrint(t, "-2.5j", "-2.5i")
+	testExecPrint(t, "conj(1+1i)", "1-1i")
+	testExecPrint(t, "cos(1i)", "1.5430806348152437+0i")
+	testExecPrint(t, "-(2+0i)", "-2+0i")
+	testExecPrint(t, "rect(2, 0)", "2+0i")
+	testExecPrint(t, "polar(2i)", "[2, 1.5707963267948966]")
+	testExecPrint(t, "@:f; ln(-1)", "3.141592653589793i")
+	testExecReal(t, "abs(3+4i)", 5)
+	testExecReal(t, "re(3+4i)", 3)
+	testExecReal(t, "im(3+4i)", 4)
+	testExecReal(t, "arg(-1)", math.Pi)
+	testExecInt(t, "1+2i == 1+2i", 1)
+	testExecInt(t, "1+2i != 1-2i", 1)
+	testExecPrint(t, "x = 1i; x++; x", "1+1i")
+	testExecError(t, "1i < 2i", "Can not compare complex values")
+	testExecError(t, "if (1i) { 1; }", "Complex value can not be used as boolean")
+	testExecPrint(t, "@:r; sqrt(-2)", "~1.414213562373i")
+	testExecPrint(t, "x = sqrt(-2); x*x", "~-2.0+0.0i")
+	testExecInt(t, "exact(sqrt(-2))", 0)
+}
+
+func TestBigFloat(t *testing.T) {
+	defer func() { CommaMode = floatComma; floatPrec = 200 }()
+	testExecPrint(t, "@:b 200; sqrt(2)", "1.41421356237309504880168872420969807856967187537694807317668")
+	testExecPrint(t, "4*atan(1)", "3.14159265358979323846264338327950288419716939937510582097494")
+	testExecPrint(t, "exp(1)", "2.71828182845904523536028747135266249775724709369995957496697")
+	testExecPrint(t, "ln(10)", "2.30258509299404568401799145468436420760110148862877297603333")
+	testExecPrint(t, "sin(1)", "0.841470984807896506652502321630298999622563060798371065672752")
+	testExecPrint(t, "tanh(0.5)", "0.462117157260009758502318483643672548730289280330113038552732")
+	testExecPrint(t, "1/3", "0.333333333333333333333333333333333333333333333333333333333333")
+	testExecPrint(t, "2**0.5 == sqrt(2)", "1")
+	testExecPrint(t, "2**-2", "0.25")
+	testExecPrint(t, "log10(1000)", "3")
+	testExecPrint(t, "x = 1.5; x++; x", "2.5")
+	testExecPrint(t, "sqrt(-4)", "2i")
+	testExecInt(t, "floor(-1.5)", -2)
+	testExecInt(t, "ceil(1.2)", 2)
+	testExecInt(t, "1.1 < 1.2", 1)
+	testExecPrint(t, "@:b 64; 1/3", "0.3333333333333333333")
+}
+
+func TestExactness(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecPrint(t, "@:r; sqrt(9/4)", "1.5")
+	testExecPrint(t, "sqrt(2)", "~1.414213562373")
+	testExecPrint(t, "sqrt(2)**2 == 2", "~0")
+	testExecPrint(t, "-sqrt(2)", "~-1.414213562373")
+	testExecPrint(t, "floor(sqrt(2))", "~1")
+	testExecPrint(t, "sum([sqrt(2), 1])", "~2.414213562373")
+	testExecRat(t, "4**(1/2)", "2")
+	testExecRat(t, "8**(2/3)", "4")
+	testExecRat(t, "(-8)**(1/3)", "-2")
+	testExecRat(t, "(4/9)**(-1/2)", "1.5")
+	testExecRat(t, "2**-2", "0.25")
+	testExecRat(t, "log10(1/1000)", "-3")
+	testExecRat(t, "log2(8)", "3")
+	testExecRat(t, "cos(0)", "1")
+	testExecInt(t, "exact(sqrt(4))", 1)
+	testExecInt(t, "exact(1/3)", 1)
+	testExecInt(t, "exact(sqrt(2))", 0)
+	testExecInt(t, "exact(2**(1/2))", 0)
+	testExecInt(t, "exact(sqrt(sqrt(2)**2))", 0)
+	testExecInt(t, "@:f; exact(1.5)", 0)
+	testExecInt(t, "exact(3)", 1)
+}
+
+func TestDecimal(t *testing.T) {
+	defer func() { CommaMode = floatComma; decimalScale = 2; decimalRounding = roundHalfEven }()
+	testExecPrint(t, "12.5d", "12.50")
+	testExecPrint(t, "1.005d", "1.005")
+	testExecPrint(t, "1.5e-3d", "0.0015")
+	testExecPrint(t, "0.1d + 0.2d", "0.30")
+	testExecPrint(t, "0.1d + 0.2d == 0.3", "1")
+	testExecPrint(t, "12.5d + 1", "13.50")
+	testExecPrint(t, "10.00d / 3", "3.33")
+	testExecPrint(t, "@:r; 12.50d * (1/3)", "4.17")
+	testExecPrint(t, "1.25d / 2", "0.62")
+	testExecPrint(t, "1.35d / 2", "0.68")
+	testExecPrint(t, "-1.25d / 2", "-0.62")
+	testExecPrint(t, "1.05d ** 2", "1.10")
+	testExecPrint(t, "-12.5d", "-12.50")
+	testExecPrint(t, "x = 1.99d; x++; x", "2.99")
+	testExecPrint(t, "1234567.5d", "1'234'567.50")
+	testExecPrint(t, "@:f; 0.5d + 0.5", "1")
+	testExecPrint(t, "@:round halfup; 1.25d / 2", "0.63")
+	testExecPrint(t, "@:round half-even; 1.25d / 2", "0.62")
+	testExecPrint(t, "@:round half-up; 1.25d / 2", "0.63")
+	testExecPrint(t, "-1.25d / 2", "-0.63")
+	testExecPrint(t, "@:round down; 2.999d * 1", "2.999")
+	testExecPrint(t, "2.99d / 3", "0.99")
+	testExecPrint(t, "@:round up; 2.01d / 3", "0.67")
+	testExecPrint(t, "@:round ceiling; -2.01d / 3", "-0.67")
+	testExecPrint(t, "@:round floor; -2.01d / 3", "-0.67")
+	testExecPrint(t, "2.01d / 3", "0.67")
+	testExecPrint(t, "@:round halfeven; @:scale 4; 1d", "1.0000")
+	testExecPrint(t, "@:scale 2; round(2.5d)", "2")
+	testExecError(t, "1.5d / 0", "Division by zero at line 1")
+	testExecError(t, "x = 0d; 1.5d / x", "Division by zero at line 1")
+	// @:scale takes effect when it is executed, not when it is parsed
+	if _, err := parseString("@:scale 6"); err != nil || decimalScale != 2 {
+		t.Fatalf("Parsing @:scale changed the scale to %d\n", decimalScale)
+	}
+	testExecPrint(t, "func f() { @:scale 4; } x = 1d; f(); [x, 1d]", "[1.00, 1.0000]")
+	testExecInt(t, "@:scale 2", 0)
+	testExecPrint(t, "round(3.5d)", "4")
+	testExecPrint(t, "round(-2.5d, 0, \"halfup\")", "-3")
+	testExecPrint(t, "round(2.5d, 0, \"half-even\")", "2")
+	testExecPrint(t, "round(2.5d, 0, \"half-up\")", "3")
+	testExecPrint(t, "round(2.341d, 2, \"up\")", "2.35")
+	testExecPrint(t, "round(2.349d, 2, \"down\")", "2.34")
+	testExecPrint(t, "round(-2.341d, 2, \"floor\")", "-2.35")
+	testExecPrint(t, "round(-2.349d, 2, \"ceiling\")", "-2.34")
+	testExecPrint(t, "round(1250, -2)", "1'200")
+	testExecPrint(t, "round(2.675, 2)", "2.67")
+	testExecPrint(t, "sqrt(2.00d)", "~1.41")
+	testExecPrint(t, "sqrt(2.25d)", "1.50")
+	testExecInt(t, "1.5d < 2", 1)
+	testExecInt(t, "1.5d == 1.50d", 1)
+	testExecError(t, "round(1, 0, \"sideways\")", "Unknown rounding mode")
+	testExecError(t, "1.5d ** 0.5d", "non integer power")
+}
+
+func TestInterval(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecPrint(t, "@:f; r = interval(4.7, 5.3); r", "[4.7 .. 5.3]")
+	testExecPrint(t, "interval(2, 3) + 1", "[3 .. 4]")
+	testExecPrint(t, "interval(2, 3) - interval(2, 3)", "[-1 .. 1]")
+	testExecPrint(t, "interval(-1, 2) * interval(3, 4)", "[-4 .. 8]")
+	testExecPrint(t, "interval(1, 2) / interval(4, 8)", "[0.125 .. 0.5]")
+	testExecPrint(t, "interval(-1, 2) ** 2", "[0 .. 4]")
+	testExecPrint(t, "interval(-1, 2) ** 3", "[-1 .. 8]")
+	testExecPrint(t, "interval(2, 4) ** -1", "[0.25 .. 0.5]")
+	testExecPrint(t, "-interval(1, 2)", "[-2 .. -1]")
+	testExecPrint(t, "sqrt(interval(4, 9))", "[2 .. 3]")
+	testExecPrint(t, "sqrt(interval(2))", "[1.414213562373095 .. 1.4142135623730951]")
+	testExecPrint(t, "interval(0.1) + interval(0.2)", "[0.3 .. 0.30000000000000004]")
+	testExecPrint(t, "@:r; interval(1/3)", "[0.3333333333333333 .. 0.33333333333333337]")
+	testExecPrint(t, "@:f; cos(interval(-1, 1))", "[0.5403023058681395 .. 1]")
+	testExecPrint(t, "sin(interval(1, 2))", "[0.8414709848078963 .. 1]")
+	testExecPrint(t, "abs(interval(-3, 1))", "[0 .. 3]")
+	testExecPrint(t, "floor(interval(4.7, 5.3))", "[4 .. 5]")
+	testExecPrint(t, "x = interval(1, 2); x++; x", "[2 .. 3]")
+	testExecPrint(t, "interval(4.7, 5.3) < 6", "1")
+	testExecPrint(t, "interval(4.7, 5.3) > 6", "0")
+	testExecPrint(t, "interval(4.7, 5.3) < 5", "[0 .. 1]")
+	testExecPrint(t, "interval(4.7, 5.3) == 5", "[0 .. 1]")
+	testExecPrint(t, "interval(4.7, 5.3) != 6", "1")
+	testExecPrint(t, "interval(2) == 2", "1")
+	testExecInt(t, "certainly(interval(4.7, 5.3) < 5)", 0)
+	testExecInt(t, "possibly(interval(4.7, 5.3) < 5)", 1)
+	testExecInt(t, "certainly(interval(4.7, 5.3) < 6)", 1)
+	testExecInt(t, "possibly(interval(4.7, 5.3) > 6)", 0)
+	testExecReal(t, "lo(interval(4.7, 5.3))", 4.7)
+	testExecReal(t, "hi(interval(4.7, 5.3))", 5.3)
+	testExecReal(t, "mid(interval(4, 6))", 5)
+	testExecReal(t, "width(interval(4, 6))", 2)
+	testExecError(t, "if (interval(4.7, 5.3) < 5) { 1; }", "can not be used as boolean")
+	testExecError(t, "1 / interval(-1, 1)", "containing zero")
+	testExecError(t, "ln(interval(-1, 1))", "domain")
+	testExecError(t, "tan(interval(1, 2))", "pole")
+	testExecError(t, "interval(2, 1)", "Empty interval, 2 is greater than 1")
+	testExecError(t, "interval(1, 0/0)", "Empty interval")
+	testExecError(t, "interval(0/0)", "Empty interval")
+	testExecPrint(t, "interval(0, 1)**0.5", "[0 .. 1]")
+	testExecReal(t, "lo(interval(0, 1)**1.5)", 0)
 }
 
 func TestExecVars(t *testing.T) {
@@ -258,12 +512,15 @@ func TestBuiltin(t *testing.T) {
 	testExecInt(t, "@:f", 0)
 	testExecReal(t, "cos(3)", math.Cos(3))
 	testExecReal(t, "ln(4.3)", math.Log(4.3))
+	// assigning to the name of a builtin does not change it for other programs
+	testExecInt(t, "cos = 2; cos", 2)
+	testExecReal(t, "cos(0)", 1)
 
 	testExecInt(t, "@:r", 0)
 	testExecInt(t, "floor(3.1)", 3)
 	testExecInt(t, "ceil(3.1)", 4)
-	testExecRat(t, "cos(3)", "-0.9899924966")
-	testExecRat(t, "ln(4.3)", "1.4586150227")
+	testExecRat(t, "cos(3)", "~-0.9899924966")
+	testExecRat(t, "ln(4.3)", "~1.4586150227")
 }
 
 func TestAckermann(t *testing.T) {
@@ -305,6 +562,306 @@ func TestAckermann(t *testing.T) {
 	testExecInt(t, ackermann+"af(3, 4)", 125)
 }
 
+func TestAckermannReturn(t *testing.T) {
+	ackermann := `
+		func af(m, n) {
+			if (m == 0) {
+				return n+1;
+			}
+			if (n == 0) {
+				return af(m-1, 1);
+			}
+			return af(m-1, af(m, n-1));
+		}
+	`
+
+	testExecInt(t, ackermann+"af(0, 0)", 1)
+	testExecInt(t, ackermann+"af(1, 2)", 4)
+	testExecInt(t, ackermann+"af(2, 3)", 9)
+	testExecInt(t, ackermann+"af(3, 3)", 61)
+}
+
+func TestControlFlow(t *testing.T) {
+	testExecInt(t, `
+		func first(n) {
+			for (i = 0; i < 100; i++) {
+				if (i * i >= n) {
+					return i;
+				}
+			}
+			-1;
+		}
+		first(50)`, 8)
+	testExecInt(t, `
+		func f() {
+			return;
+			3;
+		}
+		f()`, 0)
+	testExecInt(t, `
+		func fact(n) {
+			r = 1;
+			while (1) {
+				if (n <= 1) {
+					return r;
+				}
+				r *= n;
+				n--;
+			}
+		}
+		fact(10)`, 3628800)
+	testExecInt(t, `
+		b = 0;
+		for (i = 0; i < 10; i++) {
+			if (i % 2 == 0) {
+				continue;
+			}
+			if (i > 7) {
+				break;
+			}
+			b += i;
+		}
+		b`, 16)
+	testExecInt(t, `
+		b = 0;
+		for (i = 0; i < 5; i++) {
+			j = 0;
+			while (1) {
+				j++;
+				if (j > i) {
+					break;
+				}
+				if (j == 2) {
+					continue;
+				}
+				b++;
+			}
+		}
+		b`, 7)
+	testExecInt(t, `
+		func find(n) {
+			for (i = 1; i < n; i++) {
+				for (j = 1; j < n; j++) {
+					if (i * j == n) {
+						return i * 100 + j;
+					}
+				}
+			}
+			return 0;
+		}
+		find(21)`, 307)
+}
+
+func TestClosures(t *testing.T) {
+	adder := `
+		func make_adder(n) {
+			return func(x) { return x + n; };
+		}
+	`
+	testExecInt(t, adder+"make_adder(3)(4)", 7)
+	testExecInt(t, adder+"add5 = make_adder(5); add5(10)", 15)
+	testExecInt(t, adder+"a1 = make_adder(1); a2 = make_adder(2); a1(10) * a2(10)", 132)
+	testExecInt(t, `
+		func counter() {
+			c = 0;
+			return func() { c++; return c; };
+		}
+		k1 = counter();
+		k2 = counter();
+		k1(); k1(); k2();
+		k1() * 10 + k2()`, 32)
+	testExecInt(t, `
+		func apply(f, x) { return f(x); }
+		apply(func(y) { y * y; }, 7)`, 49)
+	testExecInt(t, `
+		func outer(n) {
+			func fact(m) {
+				if (m <= 1) {
+					return 1;
+				}
+				return m * fact(m-1);
+			}
+			return fact(n);
+		}
+		outer(5)`, 120)
+	testExecInt(t, "(func(a, b) { a - b; })(10, 3)", 7)
+	testExecInt(t, "sq = func(x) { x * x; }; f = sq; f(9)", 81)
+}
+
+func testExecError(t *testing.T, s string, tgt string) {
+	t.Helper()
+	pgm, err := parseString(s)
+	if err != nil {
+		t.Fatalf("Error parsing %q: %v\n", s, err)
+	}
+	_, err = execWithCallStack(pgm, NewCallStack())
+	if err == nil || !strings.Contains(err.Error(), tgt) {
+		t.Fatalf("Wrong or no error executing %q: %v (expected: %s)\n", s, err, tgt)
+	}
+}
+
+func TestScoping(t *testing.T) {
+	testExecInt(t, "x = 1; func f() { local x = 2; x++; return x; } f() * 10 + x", 31)
+	testExecInt(t, "x = 1; func f() { x = 2; } f(); x", 2)
+	testExecInt(t, "func f() { global y; y = 7; } f(); y", 7)
+	testExecInt(t, "b = 1; func f(a) { a = 5; } f(b); b", 1)
+	testExecInt(t, `
+		func fib(n) {
+			local a = 0, b = 1, t;
+			while (n > 0) {
+				t = a + b; a = b; b = t;
+				n--;
+			}
+			return a;
+		}
+		fib(10)`, 55)
+	testExecInt(t, `
+		func fact(n) {
+			local r = 1;
+			if (n > 1) {
+				r = n * fact(n-1);
+			}
+			return r;
+		}
+		fact(6)`, 720)
+
+	defer func() { strictMode = false }()
+	testExecInt(t, "@:strict; x = 1; func f(n) { local y = n; global x; x += y; n = 0; } f(3); x", 4)
+	testExecInt(t, "@:strict; func c() { local k = 0; return func() { k++; return k; }; } g = c(); g(); g()", 2)
+	testExecError(t, "@:strict; func f() { z = 2; } f()", "Assignment to undeclared variable z")
+	testExecError(t, "@:strict; x = 1; func f() { x = 2; } f()", "Assignment to undeclared variable x")
+	testExecError(t, "@:strict; x = 1; func f() { x++; } f()", "Assignment to undeclared variable x")
+	testExecError(t, "@:strict; func f() { for (i in [1, 2]) { } } f()", "Assignment to undeclared variable i")
+	testExecInt(t, "@:nostrict; func f() { z = 2; } f()", 2)
+}
+
+func TestConditional(t *testing.T) {
+	testExecInt(t, "n = 0; n != 0 && 10/n > 1", 0)
+	testExecInt(t, "n = 0; n == 0 || 10/n > 1", 1)
+	testExecInt(t, "n = 5; n != 0 && 10/n > 1", 1)
+	testExecInt(t, "c = 0; 0 && c++; 1 || c++; c", 0)
+	testExecInt(t, "1 < 2 ? 10 : 20", 10)
+	testExecInt(t, "0 ? 1 : 0 ? 2 : 3", 3)
+	testExecInt(t, "0 ? 1:2", 2)
+	testExecInt(t, "a = 0; b = 0; 1 ? a++ : b++; 0 ? a++ : b++; a * 10 + b", 11)
+	testExecInt(t, "func fact(n) { n <= 1 ? 1 : n * fact(n-1); } fact(6)", 720)
+	testExecInt(t, "x = 1 ? 2 : 3; x", 2)
+}
+
+func TestLists(t *testing.T) {
+	testExecPrint(t, "[1, 2, 3]", "[1, 2, 3]")
+	testExecPrint(t, "[]", "[]")
+	testExecPrint(t, "[1, [2, 0x10]]", "[1, [2, 0x10]]")
+	testExecInt(t, "xs = [3, 1, 2]; xs[0]", 3)
+	testExecInt(t, "xs = [3, 1, 2]; xs[-1]", 2)
+	testExecInt(t, "[3, 1, 2][1] + 1", 2)
+	testExecPrint(t, "xs = [3, 1, 2]; xs[1:3]", "[1, 2]")
+	testExecPrint(t, "xs = [3, 1, 2]; xs[:2]", "[3, 1]")
+	testExecPrint(t, "xs = [3, 1, 2]; xs[1:]", "[1, 2]")
+	testExecPrint(t, "xs = [3, 1, 2]; xs[-2:]", "[1, 2]")
+	testExecPrint(t, "xs = [3, 1, 2]; xs[2:1]", "[]")
+	testExecPrint(t, "[1:30, 2:00]", "[01:30, 02:00]")
+	testExecPrint(t, "xs = [1:30, 2:00, 3:15]; xs[1:3]", "[02:00, 03:15]")
+	testExecError(t, "xs = [1, 2]; xs[2]", "Index 2 out of range (list has 2 elements) at line 1")
+	testExecError(t, "xs = [1, 2]; xs[1:2**70]", "Slice bound")
+	testExecPrint(t, "[1, 2] + [3]", "[1, 2, 3]")
+	testExecPrint(t, "xs = [3, 1, 2]; xs[0] = 5; xs[1] += 10; xs", "[5, 11, 2]")
+	testExecPrint(t, "m = [[1, 2], [3, 4]]; m[1][0] = 7; m", "[[1, 2], [7, 4]]")
+	testExecPrint(t, "xs = [1]; ys = xs; push(ys, 2); xs", "[1, 2]")
+	testExecPrint(t, "xs = [1, 2]; ys = xs[:]; push(ys, 3); xs", "[1, 2]")
+	testExecInt(t, "xs = [1, 2, 3]; pop(xs) * 10 + len(xs)", 32)
+	testExecPrint(t, "range(4)", "[0, 1, 2, 3]")
+	testExecPrint(t, "range(2, 5)", "[2, 3, 4]")
+	testExecPrint(t, "range(10, 0, -4)", "[10, 6, 2]")
+	testExecInt(t, "sum(range(101))", 5050)
+	testExecInt(t, "sum([])", 0)
+	testExecInt(t, "prod([1, 2, 3, 4])", 24)
+	testExecPrint(t, "sort([3, 1, 2, -5])", "[-5, 1, 2, 3]")
+	testExecPrint(t, "xs = [3, 1, 2]; sort(xs); reverse(xs)", "[2, 1, 3]")
+	testExecInt(t, "@:f", 0)
+	testExecReal(t, "sum([1, 2.5, 3])", 6.5)
+	testExecPrint(t, `
+		func squares(n) {
+			r = [];
+			for (i = 0; i < n; i++) {
+				push(r, i * i);
+			}
+			return r;
+		}
+		func total(l) {
+			return sum(l);
+		}
+		[squares(4), total(squares(4))]`, "[[0, 1, 4, 9], 14]")
+}
+
+func TestStrings(t *testing.T) {
+	testExecPrint(t, `"hello"`, `"hello"`)
+	testExecPrint(t, `"a\tb\n\"c\"\x41"`, `"a\tb\n\"c\"A"`)
+	testExecPrint(t, `s = "foo"; s += "bar"; s + "!"`, `"foobar!"`)
+	testExecInt(t, `"abc" < "abd"`, 1)
+	testExecInt(t, `"abc" == "abc"`, 1)
+	testExecInt(t, `"abc" != "abc"`, 0)
+	testExecInt(t, `"b" >= "abc"`, 1)
+	testExecInt(t, `len("héllo")`, 5)
+	testExecPrint(t, `substr("hello", 1, 3)`, `"ell"`)
+	testExecPrint(t, `substr("hello", -3)`, `"llo"`)
+	testExecPrint(t, `substr("hello", 3, 10)`, `"lo"`)
+	testExecPrint(t, `str(12345) + " " + str(0x1f) + " " + str(1:30)`, `"12'345 0x1f 01:30"`)
+	testExecInt(t, `num("12'000") + num("-0x10") + num("010")`, 11992)
+	testExecPrint(t, `num("1:30")`, "01:30")
+	testExecPrint(t, `sort(["b", "c", "a"])`, `["a", "b", "c"]`)
+	testExecPrint(t, `sprintf("%s: %d items, %5.2f%%", "total", 12345, 12.5)`, `"total: 12'345 items, 12.50%"`)
+	testExecPrint(t, `sprintf("%#x %X %o %b %t", 255, 255, 8, 5, 3723)`, `"0xff FF 10 101 01:02:03"`)
+	testExecPrint(t, `sprintf("%v %v %v", 0x10, $20160101, [1, "a"])`, `"0x10 $20160101 [1, \"a\"]"`)
+	testExecPrint(t, `sprintf("|%-4s|%4s|%04d|%+d|", "ab", "cd", -7, 7)`, `"|ab  |  cd|-007|+7|"`)
+	testExecInt(t, "@:r", 0)
+	testExecPrint(t, `sprintf("%v %f %.3f", 1234.50, 1234.50, 10/3)`, `"1'234.5 1'234.50 3.333"`)
+	testExecInt(t, "@:f", 0)
+	testExecPrint(t, `sprintf("%.1f %e", 1234.56, 1234.56)`, `"1'234.6 1.23456e+03"`)
+}
+
+func TestMaps(t *testing.T) {
+	testExecPrint(t, `{"k": 1, 2: "w"}`, `{"k": 1, 2: "w"}`)
+	testExecPrint(t, `{}`, `{}`)
+	testExecInt(t, `m = {"a": 1, 2: 20, $20200101: 3}; m["a"] + m[2] + m[$20200101]`, 24)
+	testExecInt(t, `m = {1: 30}; m[1]`, 30)
+	testExecPrint(t, `{1: 1:30, 2: [0:45]}`, `{1: 01:30, 2: [00:45]}`)
+	testExecPrint(t, `c = 1; {1: c ? 1:2, 2: 1:30}`, `{1: 1, 2: 01:30}`)
+	testExecPrint(t, `m = {}; m["x"] = 1; m["y"] = 2; m["x"] += 10; m`, `{"x": 11, "y": 2}`)
+	testExecInt(t, "@:f", 0)
+	testExecPrint(t, `m = {2: "a"}; m[2.0] = "b"; m[4/2]`, `"b"`)
+	testExecPrint(t, `m = {"b": 1, "a": 2}; [keys(m), values(m)]`, `[["b", "a"], [1, 2]]`)
+	testExecInt(t, `m = {"b": 1}; has(m, "b") * 10 + has(m, "c")`, 10)
+	testExecPrint(t, `m = {"b": 1, "a": 2}; delete(m, "b"); delete(m, "c"); [m, len(m)]`, `[{"a": 2}, 1]`)
+	testExecPrint(t, `m = {}; n = m; n[1] = 2; m`, `{1: 2}`)
+	testExecPrint(t, `
+		func brackets() {
+			return {10000: 10, 50000: 25, 100000: 40};
+		}
+		func tax(income) {
+			for (limit in brackets()) {
+				if (income <= limit) {
+					return brackets()[limit];
+				}
+			}
+			return 50;
+		}
+		[tax(5000), tax(60000), tax(1000000)]`, "[10, 40, 50]")
+	testExecInt(t, `
+		s = 0;
+		for (x in [1, 2, 3, 4, 5]) {
+			if (x == 2) {
+				continue;
+			}
+			if (x == 5) {
+				break;
+			}
+			s += x;
+		}
+		s`, 8)
+	testExecPrint(t, `r = ""; for (c in "abc") { r = c + r; } r`, `"cba"`)
+}
+
 func TestFmtFloatStr(t *testing.T) {
 	c := func(in, tgt string) {
 		if out := fmtfloatstr(in); out != tgt {
@@ -342,3 +899,307 @@ func TestExecTime(t *testing.T) {
 	testExecTime(t, "1:0:0 + 1:30", "01:01:30")
 	testExecTime(t, "1:0:0 - 1:00", "59:00")
 }
+
+func TestUncertain(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecPrint(t, "@:f; 9.81 ± 0.02", "9.810 ± 0.020")
+	testExecPrint(t, "(9.81 +/- 0.02) * 2", "19.620 ± 0.040")
+	testExecPrint(t, "2 * 3 ± 1", "6.0 ± 2.0")
+	testExecPrint(t, "(1 ± 0.3) + (2 ± 0.4)", "3.00 ± 0.50")
+	testExecPrint(t, "x = 1 ± 0.1; x - x", "0 ± 0")
+	testExecPrint(t, "x = 1 ± 0.1; x * x", "1.00 ± 0.20")
+	testExecPrint(t, "x = 1 ± 0.1; x / x", "1 ± 0")
+	testExecPrint(t, "sqrt(4 ± 0.4)", "2.00 ± 0.10")
+	testExecPrint(t, "ln(2 ± 0.02)", "0.693 ± 0.010")
+	testExecPrint(t, "abs(-3 ± 0.1)", "3.00 ± 0.10")
+	testExecPrint(t, "12345 ± 678", "12'350 ± 680")
+	testExecPrint(t, "1e20 ± 3e18", "(1.000 ± 0.030)e+20")
+	testExecPrint(t, "x = 1 ± 0.1; x++; x", "2.00 ± 0.10")
+	testExecPrint(t, "(1 ± 0.1) < 2", "1")
+	testExecReal(t, "nominal(9.81 ± 0.02)", 9.81)
+	testExecReal(t, "uncertainty((3 ± 0.3) + 1)", 0.3)
+	testExecInt(t, "exact(1 ± 0.1)", 0)
+	testExecError(t, "1 ± -1", "Negative uncertainty")
+	testExecError(t, "1 ± (1 ± 1)", "can not be uncertain")
+	testExecError(t, "if (1 ± 1) { 1; }", "can not be used as boolean")
+	testExecError(t, "sqrt(-1 ± 0.1)", "domain")
+	testExecError(t, "sqrt(0 ± 0.1)", "not differentiable at 0")
+	testExecPrint(t, "asin(0.5 ± 0.01)", "0.524 ± 0.012")
+}
+
+func TestUnits(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecPrint(t, "@:r; 3.3 V", "3.3 V")
+	testExecPrint(t, "10 mA * 330 Ω", "3.3 V")
+	testExecPrint(t, "1 kWh -> J", "3'600'000 J")
+	testExecPrint(t, "1 km + 500 m", "1.5 km")
+	testExecPrint(t, "@:f; 5 km/h -> m/s", "1.3888888888888888 m/s")
+	testExecPrint(t, "60 km/h * 30 min", "30 km")
+	testExecPrint(t, "9.81 m/s**2 * 2 s", "19.62 m/s")
+	testExecPrint(t, "3 kg * 2 m/s**2", "6 N")
+	testExecPrint(t, "2 N * 3 m", "6 J")
+	testExecPrint(t, "(3 m)**2", "9 m**2")
+	testExecPrint(t, "sqrt(16 m**2)", "4 m")
+	testExecPrint(t, "sqrt(4 m**2/s**2)", "2 m/s")
+	testExecPrint(t, "-(3 m)", "-3 m")
+	testExecPrint(t, "abs(-3 m)", "3 m")
+	testExecPrint(t, "floor(2.5 m)", "2 m")
+	testExecPrint(t, "10 m / 2", "5 m")
+	testExecPrint(t, "1 / (2 s)", "0.5 s**-1")
+	testExecPrint(t, "x = 1'500 mAh; x -> C", "5'400 C")
+	testExecPrint(t, "@:unit furlong = 201.168 m; 1 furlong -> ft", "660 ft")
+	testExecPrint(t, "@:unit dozen = 12; 3 dozen", "36")
+	testExecReal(t, "1 km / 1 m", 1000)
+	testExecInt(t, "3 m < 1 km", 1)
+	testExecInt(t, "100 cm == 1 m", 1)
+	testExecInt(t, "t = 2; 10 m / t == 5 m", 1)
+	testExecError(t, "3 m + 2 s", "Dimension error, can not apply + to m and s")
+	testExecError(t, "3 m > 2", "Dimension error, can not apply > to m and a number")
+	testExecError(t, "1 m -> s", "Dimension error, can not convert m to s")
+	testExecError(t, "sqrt(2 m)", "Dimension error")
+	testExecError(t, "2 ** (1 m)", "not a number")
+	testExecError(t, "sin(1 m)", "Can not use quantity 1 m as a number")
+}
+
+func TestDeriv(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecInt(t, "@:r; func f(x) { return x**3 + 2*x; }\nderiv(f, 2)", 14)
+	testExecRat(t, "func f(x) { return x**3 + 2*x; }\nderiv(f, 1/2)", "2.75")
+	testExecInt(t, "func f(x) { return 1/x; }\nderiv(f, 3) == -1/9", 1)
+	testExecReal(t, "@:f; deriv(sin, 0)", 1)
+	testExecReal(t, "deriv(exp, 1)", math.E)
+	testExecReal(t, "func f(x) { return sqrt(x)/x; }\nderiv(f, 4)", -0.0625)
+	testExecReal(t, "func f(x) { return 2**x; }\nderiv(f, 1)", 2*math.Ln2)
+	testExecReal(t, "func f(x) { return x**x; }\nderiv(f, 2)", 4+4*math.Ln2)
+	testExecInt(t, "func f(x) { if (x > 0) { return x; } return -x; }\nderiv(f, -3)", -1)
+	testExecInt(t, "deriv(func(x) { return 5; }, 1)", 0)
+	testExecInt(t, "deriv(abs, -2)", -1)
+	testExecReal(t, "deriv(asin, 0.5)", 2/math.Sqrt(3))
+	testExecReal(t, "deriv(ln, 2)", 0.5)
+	testExecError(t, "deriv(ln, -1)", "not differentiable at -1")
+	testExecError(t, "deriv(sqrt, 0)", "not differentiable at 0")
+	testExecInt(t, "deriv(func(x) { return x*deriv(func(y) { return x + y; }, 1); }, 1)", 1)
+	testExecInt(t, "deriv(func(x) { return deriv(func(y) { return x*y*y; }, x); }, 3)", 12)
+	testExecPrint(t, "grad(func(x, y) { return x*deriv(func(z) { return z*y; }, 1); }, 2, 3)", "[3, 2]")
+	testExecPrint(t, "func g(x, y) { return x*y + y**2; }\ngrad(g, 2, 3)", "[3, 8]")
+	testExecPrint(t, "func v(t) { return t * 3 m/s; }\nderiv(v, 1)", "3 m/s")
+	testExecError(t, "deriv(1, 2)", "not a function")
+	testExecError(t, "deriv(sin)", "wrong number of arguments")
+	testExecError(t, "deriv(func(x) { return \"a\"; }, 1)", "Can not differentiate")
+}
+
+func TestDiff(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	src := func(f string) string {
+		return "source(diff(func(x, y) { return " + f + "; }, \"x\"))"
+	}
+	testExecPrint(t, "@:r; func f(x) { return x**3 + 2*x; }\ng = diff(f, \"x\"); g(2)", "14")
+	testExecPrint(t, src("x**3 + 2*x"), "\"func(x, y) {\\n\\treturn 3*x**2 + 2;\\n}\"")
+	testExecPrint(t, src("x*y + x*x/y - 3*x + x"), "\"func(x, y) {\\n\\treturn y + 2*x/y - 2;\\n}\"")
+	testExecPrint(t, src("1/x"), "\"func(x, y) {\\n\\treturn -1/x**2;\\n}\"")
+	testExecPrint(t, src("sin(x**2)*y"), "\"func(x, y) {\\n\\treturn 2*x*y*cos(x**2);\\n}\"")
+	testExecPrint(t, src("-cos(x)/2 + y**2"), "\"func(x, y) {\\n\\treturn sin(x)/2;\\n}\"")
+	testExecPrint(t, src("x > 0 ? x**2 : -x"), "\"func(x, y) {\\n\\treturn x > 0 ? 2*x : -1;\\n}\"")
+	testExecPrint(t, src("y"), "\"func(x, y) {\\n\\treturn 0;\\n}\"")
+	testExecInt(t, "func f(x) { return x**4; }\ndiff(diff(f, \"x\"), \"x\")(2) == 48", 1)
+	testExecReal(t, "@:f; func f(x) { return exp(x)*ln(x); }\ndiff(f, \"x\")(1)", math.E)
+	testExecError(t, "diff(sin, \"x\")", "only user defined functions")
+	testExecError(t, "func f(x) { return x; }\ndiff(f, \"y\")", "y is not an argument")
+	testExecError(t, "func f(x) { local y = x; return y; }\ndiff(f, \"x\")", "single expression")
+	testExecError(t, "func f(x) { return x % 2; }\ndiff(f, \"x\")", "Can not differentiate x%2")
+}
+
+func TestNumeric(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecReal(t, "@:f; solve(cos, 0, 2)", math.Pi/2)
+	testExecReal(t, "solve(func(x) { return x**3 - 2; }, 0, 2)", math.Cbrt(2))
+	testExecReal(t, "integrate(func(x) { return x**2; }, 0, 3)", 9)
+	testExecReal(t, "integrate(sin, 0, 4*atan(1))", 2)
+	testExecReal(t, "integrate(exp, 1, 0)", 1-math.E)
+	testExecReal(t, "integrate(func(x) { return 1/sqrt(x); }, 0, 1)", 2)
+	testExecReal(t, "integrate(ln, 0, 1)", -1)
+	testExecError(t, "integrate(func(x) { return 1/x; }, -1, 1)", "did not converge")
+	testExecReal(t, "minimize(func(x) { return (x - 2)**2 + 1; }, 0, 5)", 2)
+	testExecReal(t, "limit(func(x) { return sin(x)/x; }, 0)", 1)
+	testExecReal(t, "limit(func(x) { return (1 + x)**(1/x); }, 0, 1)", math.E)
+	testExecInt(t, "@:r; solve(func(x) { return x**2 - 4; }, 0, 5)", 2)
+	testExecRat(t, "integrate(func(x) { return x**2; }, 0, 1)", "~0.333333333333")
+	testExecInt(t, "abs(integrate(func(x) { return 1/(1 + x**2); }, 0, 1) - atan(1)) < 1e-15", 1)
+	testExecPrint(t, "@:b 100; solve(func(x) { return x**2 - 2; }, 1, 2)", "1.41421356237309504880168872421")
+	testExecError(t, "@:f; solve(cos, 0, 1)", "opposite signs")
+	testExecError(t, "integrate(func(x) { return 1/x; }, 0, 1)", "did not converge")
+	testExecError(t, "limit(func(x) { return x > 0 ? 1 : -1; }, 0)", "are different")
+	testExecError(t, "limit(func(x) { return 1/x; }, 0, 1)", "grows without bound")
+	testExecError(t, "limit(func(x) { return ln(x); }, 0, 1)", "grows without bound")
+	testExecError(t, "limit(func(x) { return sin(1/x); }, 0, 1)", "did not converge")
+	testExecError(t, "solve(1, 0, 1)", "must be a function")
+	testExecError(t, "func f(x) {\nreturn x + \"a\";\n}\n\nintegrate(f, 0, 1)", "at line 5")
+	testExecError(t, "integrate(func(x) { return \"a\"; }, 0, 1)", "must return real numbers")
+}
+
+func TestNumberTheory(t *testing.T) {
+	testExecInt(t, "gcd(12, 18, -30)", 6)
+	testExecInt(t, "lcm(4, 6, 10)", 60)
+	testExecInt(t, "gcd(2**200 * 3**5, 6**100) == 2**100 * 3**5", 1)
+	testExecPrint(t, "factor(360)", "[2, 2, 2, 3, 3, 5]")
+	testExecPrint(t, "factor(2**64 + 1)", "[274'177, 67'280'421'310'721]")
+	testExecPrint(t, "p = nextprime(10**10); q = nextprime(p); r = nextprime(10**30); str(factor(p*q*q*r)) == str([p, q, q, r])", "1")
+	testExecInt(t, "len(factor(1))", 0)
+	testExecPrint(t, "phi(10**18)", "400'000'000'000'000'000")
+	testExecInt(t, "phi(2**127 - 1) == 2**127 - 2", 1)
+	testExecPrint(t, "nextprime(10**20)", "100'000'000'000'000'000'039")
+	testExecInt(t, "isprime(2**127 - 1)", 1)
+	testExecInt(t, "isprime(2**128 + 1)", 0)
+	testExecPrint(t, "modpow(3, 2**100, 2**127 - 1)", "124'802'184'166'564'914'390'618'967'154'253'893'500")
+	testExecInt(t, "m = 2**127 - 1; modinv(12345, m) * 12345 % m", 1)
+	testExecInt(t, "modpow(3, -1, 7)", 5)
+	testExecPrint(t, "binomial(100, 50)", "100'891'344'545'564'193'334'812'497'256")
+	testExecInt(t, "binomial(5, 7)", 0)
+	testExecInt(t, "factorial(100) == prod(range(1, 101))", 1)
+	testExecPrint(t, "isqrt(10**40 + 1)", "100'000'000'000'000'000'000")
+	testExecInt(t, "iroot(2**300 - 1, 3) == 2**100 - 1", 1)
+	testExecInt(t, "iroot(-27, 3)", -3)
+	testExecInt(t, "jacobi(1001, 9907)", -1)
+	testExecInt(t, "crt([2, 3, 2], [3, 5, 7])", 23)
+	testExecInt(t, "crt([1, 3], [4, 6])", 9)
+	testExecInt(t, "m = [2**61 - 1, 2**89 - 1]; crt([5, 7], m) % m[1]", 7)
+	testExecError(t, "crt([1, 2], [4, 6])", "No solution")
+	testExecError(t, "modinv(2, 4)", "no inverse")
+	testExecError(t, "gcd(1.5)", "Can not apply gcd to non-integer value")
+	testExecError(t, "isprime(\"7\")", "Can not apply isprime to non-integer value")
+	testExecError(t, "factor(0)", "positive integer")
+	testExecError(t, "jacobi(3, 8)", "odd positive")
+}
+
+func TestModular(t *testing.T) {
+	defer func() { modulus = nil }()
+	testExecInt(t, "@:mod 7; 3 + 5", 1)
+	testExecInt(t, "3 - 5", 5)
+	testExecInt(t, "x = 3; -x", 4)
+	testExecInt(t, "-3", 4)
+	testExecInt(t, "x = 3; -3 == -x", 1)
+	testExecInt(t, "10 == 3", 1)
+	testExecInt(t, "10 != 3", 0)
+	testExecInt(t, "y = -1; 2**y", 4)
+	testExecInt(t, "2**(3 - 5)", 2)
+	testExecInt(t, "2**100", 2)
+	testExecInt(t, "1/3", 5)
+	testExecInt(t, "2**-1", 4)
+	testExecInt(t, "x = 6; x++; x", 0)
+	testExecInt(t, "u8(200) + 100", 44)
+	testExecReal(t, "@:f; 1.5 + 7", 8.5)
+	testExecInt(t, "@:mod 1000000007; 10**18", 49)
+	testExecInt(t, "2**-1", 500000004)
+	testExecInt(t, "x = 123456789; x * (1/x)", 1)
+	testExecPrint(t, "@:mod 340282366920938463463374607431768211507; 2**1000000000000000000000", "154'578'096'150'589'941'581'059'625'001'362'868'614")
+	testExecError(t, "@:mod 12; 1/4", "4 has no inverse modulo 12")
+	testExecInt(t, "@:mod 0; 3 + 5", 8)
+}
+
+func TestStatistics(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecInt(t, "@:r; mean([1, 2, 6]) == 3", 1)
+	testExecInt(t, "mean(1/3, 1/6) == 1/4", 1)
+	testExecInt(t, "exact(variance(1, 2, 4)) && variance(1, 2, 4) == 7/3", 1)
+	testExecRat(t, "median(5, 1, 3, 2)", "2.5")
+	testExecInt(t, "mode(3, 1, 2, 2, 3)", 2)
+	testExecInt(t, "percentile([4, 1, 3, 2], 25) == 7/4", 1)
+	testExecRat(t, "covariance([1, 2, 3], [2, 4, 7])", "2.5")
+	testExecInt(t, "correlation([1, 2, 3], [2, 4, 6]) == 1", 1)
+	testExecError(t, "correlation([1, 1, 1], [1, 2, 3])", "Correlation is undefined for a constant list at line 1")
+	testExecError(t, "@:f; correlation([1, 2, 3], [2, 2, 2])", "Correlation is undefined for a constant list at line 1")
+	testExecInt(t, "@:r", 0)
+	testExecPrint(t, "mean(1 m, 50 cm)", "0.75 m")
+	testExecInt(t, "binompdf(2, 4, 1/2) == 3/8", 1)
+	testExecInt(t, "binomcdf(2, 4, 1/2) == 11/16", 1)
+	testExecInt(t, "exact(normcdf(0))", 0)
+	testExecReal(t, "@:f; mean(1, 2)", 1.5)
+	testExecReal(t, "stddev([2, 4, 4, 4, 5, 5, 7, 9])", math.Sqrt(32.0/7))
+	testExecReal(t, "normcdf(1.96)", 0.9750021)
+	testExecReal(t, "normquantile(0.975, 10, 2)", 10+2*1.959964)
+	testExecReal(t, "normpdf(0)", 1/math.Sqrt(2*math.Pi))
+	testExecReal(t, "tcdf(2, 10)", 0.9633060)
+	testExecReal(t, "tquantile(0.975, 10)", 2.228139)
+	testExecReal(t, "tpdf(0, 1)", 1/math.Pi)
+	testExecReal(t, "chi2cdf(3.84, 1)", 0.9499565)
+	testExecReal(t, "chi2quantile(0.95, 4)", 9.487729)
+	testExecReal(t, "chi2pdf(1, 3)", 0.2419707)
+	testExecReal(t, "binompdf(2, 4, 0.5)", 0.375)
+	testExecInt(t, "binomquantile(0.5, 10, 0.3)", 3)
+	testExecReal(t, "binompdf(1000, 2000, 0.5)", 0.0178390111)
+	testExecReal(t, "binomcdf(1000, 2000, 0.5)", 0.5089195056)
+	testExecInt(t, "binomquantile(0.5, 2000, 0.5)", 1000)
+	testExecReal(t, "tquantile(0.5, 3)", 0)
+	testExecReal(t, "(tcdf(1e-9, 3) - 0.5) * 1e9", 0.3675526)
+	testExecReal(t, "tpdf(0, 1e300)", 1/math.Sqrt(2*math.Pi))
+	testExecReal(t, "tcdf(1, 1e300)", 0.8413447461)
+	testExecReal(t, "poissonpdf(3, 2)", 0.1804470)
+	testExecReal(t, "poissoncdf(3, 2)", 0.8571235)
+	testExecInt(t, "poissonquantile(0.99, 100)", 124)
+	testExecReal(t, "expcdf(1, 2)", 1-math.Exp(-2))
+	testExecReal(t, "expquantile(0.5, 2)", math.Ln2/2)
+	testExecError(t, "mean([])", "empty list")
+	testExecError(t, "variance(1)", "at least two values")
+	testExecError(t, "covariance([1, 2], [1, 2, 3])", "same length")
+	testExecError(t, "normquantile(1.5)", "between 0 and 1")
+	testExecError(t, "tcdf(1, 0)", "degrees of freedom")
+	testExecError(t, "binompdf(1.5, 4, 0.5)", "non-integer")
+}
+
+func TestRandom(t *testing.T) {
+	defer func() { CommaMode = floatComma }()
+	testExecInt(t, "@:f; seed(42); a = [rand(), randint(1, 6), randn()]; seed(42); str(a) == str([rand(), randint(1, 6), randn()])", 1)
+	testExecInt(t, "seed(1); a = rand(); seed(2); a != rand()", 1)
+	testExecInt(t, "func f(n) { seed(n); } f(5); a = rand(); seed(5); b = rand(); a == b", 1)
+	testExecInt(t, "ok = 1; for (i in range(1000)) { x = rand(); ok = ok && x >= 0 && x < 1; } ok", 1)
+	testExecInt(t, "ok = 1; for (i in range(1000)) { x = randint(-2, 2); ok = ok && x >= -2 && x <= 2; } ok", 1)
+	testExecInt(t, "ok = 1; for (i in range(100)) { x = randint(10**30, 10**30 + 5); ok = ok && x >= 10**30 && x <= 10**30 + 5; } ok", 1)
+	testExecInt(t, "seed(7); xs = []; for (i in range(2000)) { push(xs, randn(10, 2)); } abs(mean(xs) - 10) < 0.2 && abs(stddev(xs) - 2) < 0.2", 1)
+	testExecPrint(t, "sort(shuffle(range(10)))", "[0, 1, 2, 3, 4, 5, 6, 7, 8, 9]")
+	testExecInt(t, "has({1: 0, 2: 0, 3: 0}, choice([1, 2, 3]))", 1)
+	testExecInt(t, "@:r; exact(rand())", 1)
+	testExecInt(t, "x = randbits(256); x >= 0 && x < 2**256", 1)
+	testExecError(t, "randint(2, 1)", "a <= b")
+	testExecError(t, "choice([])", "empty list")
+	testExecError(t, "randint(1, 2.5)", "non-integer")
+}
+
+func TestSpecial(t *testing.T) {
+	defer func() { CommaMode = floatComma; floatPrec = 200 }()
+	testExecReal(t, "@:f; gamma(0.5)**2", math.Pi)
+	testExecReal(t, "gamma(-0.5)", -2*math.Sqrt(math.Pi))
+	testExecReal(t, "lgamma(100)", 359.1342053695754)
+	testExecReal(t, "beta(0.5, 0.5)", math.Pi)
+	testExecReal(t, "erf(1) + erfc(1)", 1)
+	testExecReal(t, "erf(erfinv(0.3))", 0.3)
+	testExecReal(t, "besselj(0, 1)", 0.7651976865579666)
+	testExecReal(t, "besselj(-3, -2)", 0.12894324947440205)
+	testExecReal(t, "bessely(1, 2)", -0.10703243154093756)
+	testExecReal(t, "atan2(1, -1)", 3*math.Pi/4)
+	testExecReal(t, "hypot(1e300, 1e300)", math.Sqrt2*1e300)
+	testExecReal(t, "asinh(sinh(0.7)) + acosh(cosh(0.7)) + atanh(tanh(0.7))", 2.1)
+	testExecReal(t, "expm1(1e-10)/1e-10", 1)
+	testExecReal(t, "log1p(1e-20)/1e-20", 1)
+	testExecReal(t, "cbrt(-27)", -3)
+	testExecReal(t, "log(100, 10) + log(exp(2))", 4)
+	testExecReal(t, "deriv(gamma, 1)", -0.5772156649015329)
+	testExecPrint(t, "grad(hypot, 3, 4)", "[0.6, 0.8]")
+	testExecPrint(t, "erf(0.5 ± 0.01)", "0.5205 ± 0.0088")
+	testExecPrint(t, "acosh(0.5)", "1.0471975511965976i")
+	testExecReal(t, "diff(func(x) { return asinh(x) + cbrt(x) + log1p(x); }, \"x\")(8)", 1/math.Sqrt(65)+1.0/12+1.0/9)
+	testExecInt(t, "@:r; gamma(5) == 24 && exact(gamma(5))", 1)
+	// rationals can not represent infinities, the result is a floating point number
+	testExecPrint(t, "[ln(0), log10(0), log2(0), ln(0.00d)]", "[-Inf, -Inf, -Inf, -Inf]")
+	testExecInt(t, "beta(2, 3) == 1/12 && log(27, 9) == 3/2 && cbrt(8/27) == 2/3 && exact(atan2(0, 2))", 1)
+	testExecPrint(t, "hypot(5, 12)", "13")
+	testExecInt(t, "exact(erf(1)) || exact(log(10, 2))", 0)
+	testExecInt(t, "@:b; abs(gamma(0.5)**2 - 4*atan(1)) < 2**-190 && abs(erfinv(erf(0.3)) - 0.3) < 2**-190 && abs(lgamma(20) - ln(factorial(19))) < 2**-180", 1)
+	testExecInt(t, "abs(erfc(10)/2.0884875837625447570007862949577886115608181193211e-45 - 1) < 1e-45", 1)
+	testExecInt(t, "abs(besselj(1, 10) - 0.043472746168861436669748768025859288306272) < 1e-40", 1)
+	testExecError(t, "@:f; gamma(-1)", "Undefined value")
+	testExecError(t, "erfinv(2)", "Undefined value")
+	testExecError(t, "erf(1+2i)", "complex")
+	testExecError(t, "atan2(1i, 1)", "complex")
+	testExecError(t, "besselj(0.5, 1)", "non-integer")
+	testExecError(t, "log(2, 1)", "Undefined value")
+}
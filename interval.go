@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Interval arithmetic. An interval is a pair of float64 bounds that is guaranteed to contain the exact result: every
+// operation rounds its lower bound down and its upper bound up (outward rounding). The basic operations compute the
+// rounding error exactly (with FMA and two-sum) so that exact results stay exact, functions of the math package, that
+// are not correctly rounded, are widened by a couple of ulps.
+
+type interval struct {
+	lo, hi float64
+}
+
+func newIntervalval(x interval) *value {
+	return &value{kind: IVLVAL, ivl: x}
+}
+
+func (x interval) String() string {
+	return "[" + fmtbound(x.lo) + " .. " + fmtbound(x.hi) + "]"
+}
+
+func fmtbound(x float64) string {
+	return fmtfloatstr(strconv.FormatFloat(x, 'g', -1, 64))
+}
+
+func (x interval) contains(y float64) bool {
+	return x.lo <= y && y <= x.hi
+}
+
+func down(x float64) float64 {
+	return math.Nextafter(x, math.Inf(-1))
+}
+
+func up(x float64) float64 {
+	return math.Nextafter(x, math.Inf(1))
+}
+
+// Returns the floats around r, the rounded result of an operation, given the sign of the rounding error err
+// (exact result minus r)
+func bracket(r, err float64) (float64, float64) {
+	switch {
+	case math.IsInf(r, 1):
+		return math.MaxFloat64, r
+	case math.IsInf(r, -1):
+		return r, -math.MaxFloat64
+	case err > 0:
+		return r, up(r)
+	case err < 0:
+		return down(r), r
+	}
+	return r, r
+}
+
+// Bounds of the exact x+y
+func addBounds(x, y float64) (float64, float64) {
+	s := x + y
+	if math.IsInf(x, 0) || math.IsInf(y, 0) {
+		return s, s
+	}
+	// two-sum, err is the exact rounding error of s
+	yy := s - x
+	err := (x - (s - yy)) + (y - yy)
+	return bracket(s, err)
+}
+
+// Bounds of the exact x*y
+func mulBounds(x, y float64) (float64, float64) {
+	p := x * y
+	if math.IsInf(x, 0) || math.IsInf(y, 0) {
+		return p, p
+	}
+	return bracket(p, math.FMA(x, y, -p))
+}
+
+// Bounds of the exact x/y
+func quoBounds(x, y float64) (float64, float64) {
+	q := x / y
+	if math.IsInf(x, 0) || math.IsInf(y, 0) {
+		return q, q
+	}
+	// x = q*y + r exactly, the exact quotient is q + r/y
+	r := math.FMA(-q, y, x)
+	if y < 0 {
+		r = -r
+	}
+	return bracket(q, r)
+}
+
+// Returns the interval [lo, hi] widened by two ulps on each side, for functions that are not correctly rounded
+func widen(lo, hi float64) interval {
+	return interval{down(down(lo)), up(up(hi))}
+}
+
+// Returns the smallest interval containing x
+func ratInterval(x *big.Rat) interval {
+	f, exact := x.Float64()
+	if exact {
+		return interval{f, f}
+	}
+	if new(big.Rat).SetFloat64(f).Cmp(x) > 0 {
+		return interval{down(f), f}
+	}
+	return interval{f, up(f)}
+}
+
+// Returns the value as an interval, numbers that can not be represented exactly as floats become the smallest
+// interval containing them
+func (vv *value) Interval(lineno int) interval {
+	switch vv.kind {
+	case IVLVAL:
+		return vv.ivl
+	case DVAL:
+		return interval{vv.dval, vv.dval}
+	case IVAL, RVAL, DECVAL:
+		return ratInterval(vv.Rat(lineno))
+	case FVAL:
+		f, acc := vv.fval.Float64()
+		switch acc {
+		case big.Below:
+			return interval{f, up(f)}
+		case big.Above:
+			return interval{down(f), f}
+		}
+		return interval{f, f}
+	case CVAL:
+		x := vv.Real(lineno)
+		return interval{x, x}
+	case UVAL:
+		panic(fmt.Errorf("Can not use uncertain value as interval at line %d", lineno))
+	}
+	panic(fmt.Errorf("Can not use non-number value as interval at line %d", lineno))
+}
+
+func ivAdd(x, y interval) interval {
+	lo, _ := addBounds(x.lo, y.lo)
+	_, hi := addBounds(x.hi, y.hi)
+	return interval{lo, hi}
+}
+
+func ivNeg(x interval) interval {
+	return interval{-x.hi, -x.lo}
+}
+
+func ivSub(x, y interval) interval {
+	return ivAdd(x, ivNeg(y))
+}
+
+// Returns the smallest interval containing the results of bounds applied to all the combinations of bounds of x and y
+func ivCombine(x, y interval, bounds func(float64, float64) (float64, float64)) interval {
+	r := interval{math.Inf(1), math.Inf(-1)}
+	for _, a := range []float64{x.lo, x.hi} {
+		for _, b := range []float64{y.lo, y.hi} {
+			lo, hi := bounds(a, b)
+			r.lo = math.Min(r.lo, lo)
+			r.hi = math.Max(r.hi, hi)
+		}
+	}
+	return r
+}
+
+func ivMul(x, y interval) interval {
+	return ivCombine(x, y, mulBounds)
+}
+
+func ivQuo(x, y interval, lineno int) interval {
+	if y.contains(0) {
+		panic(fmt.Errorf("Division by interval %s containing zero at line %d", y, lineno))
+	}
+	return ivCombine(x, y, quoBounds)
+}
+
+func ivAbs(x interval) interval {
+	switch {
+	case x.lo >= 0:
+		return x
+	case x.hi <= 0:
+		return ivNeg(x)
+	}
+	return interval{0, math.Max(-x.lo, x.hi)}
+}
+
+// x**n for an integer n, computed on the bounds since the power is monotonic on non-negative numbers and for odd n
+func ivPowInt(x interval, n *big.Int, lineno int) interval {
+	if n.Sign() == 0 {
+		return interval{1, 1}
+	}
+	if !n.IsInt64() || n.Int64() > math.MaxInt32 || n.Int64() < -math.MaxInt32 {
+		panic(fmt.Errorf("Exponent %s too large for an interval at line %d", n, lineno))
+	}
+	k := n.Int64()
+	if k < 0 {
+		return ivQuo(interval{1, 1}, ivPowInt(x, new(big.Int).Neg(n), lineno), lineno)
+	}
+	pow := func(b float64) interval {
+		r, z := interval{1, 1}, interval{b, b}
+		for e := k; e > 0; e >>= 1 {
+			if e&1 != 0 {
+				r = ivMul(r, z)
+			}
+			z = ivMul(z, z)
+		}
+		return r
+	}
+	if k%2 == 0 {
+		x = ivAbs(x)
+	}
+	return interval{pow(x.lo).lo, pow(x.hi).hi}
+}
+
+// x**y, integer exponents use ivPowInt and 0.5 ivSqrt, otherwise x must be positive and the result is exp(y*ln(x))
+func ivPow(a1, a2 *value, lineno int) interval {
+	x := a1.Interval(lineno)
+	y := a2.Interval(lineno)
+	if y.lo == y.hi && y.lo == math.Trunc(y.lo) && !math.IsInf(y.lo, 0) {
+		n, _ := new(big.Float).SetFloat64(y.lo).Int(nil)
+		return ivPowInt(x, n, lineno)
+	}
+	if y.lo == 0.5 && y.hi == 0.5 {
+		return ivSqrt(x, lineno)
+	}
+	r := ivExp(ivMul(y, ivLog(x, lineno)), lineno)
+	// the rounding of exp can make the lower bound negative
+	r.lo = math.Max(r.lo, 0)
+	return r
+}
+
+// Makes the interval extension of an increasing function fn defined on [dlo, dhi]
+func ivIncreasing(fn func(float64) float64, dlo, dhi float64) func(interval, int) interval {
+	return func(x interval, lineno int) interval {
+		if x.lo < dlo || x.hi > dhi {
+			panic(fmt.Errorf("Interval %s is not contained in the domain of the function [%g, %g] at line %d", x, dlo, dhi, lineno))
+		}
+		return widen(fn(x.lo), fn(x.hi))
+	}
+}
+
+// Makes the interval extension of a decreasing function fn defined on [dlo, dhi]
+func ivDecreasing(fn func(float64) float64, dlo, dhi float64) func(interval, int) interval {
+	inc := ivIncreasing(func(x float64) float64 { return -fn(x) }, dlo, dhi)
+	return func(x interval, lineno int) interval {
+		return ivNeg(inc(x, lineno))
+	}
+}
+
+var ivExp = ivIncreasing(math.Exp, math.Inf(-1), math.Inf(1))
+var ivLog = ivIncreasing(math.Log, 0, math.Inf(1))
+
+// sqrt is correctly rounded, like the basic operations
+func ivSqrt(x interval, lineno int) interval {
+	if x.lo < 0 {
+		panic(fmt.Errorf("Interval %s is not contained in the domain of sqrt at line %d", x, lineno))
+	}
+	sqrtBounds := func(x float64) (float64, float64) {
+		s := math.Sqrt(x)
+		if math.IsInf(s, 0) {
+			return s, s
+		}
+		return bracket(s, math.FMA(-s, s, x))
+	}
+	lo, _ := sqrtBounds(x.lo)
+	_, hi := sqrtBounds(x.hi)
+	return interval{lo, hi}
+}
+
+// Returns true if x (possibly) contains a point offset + k*period for an integer k. Since the points are computed
+// in floating point the test is done on a slightly larger interval, erring on the side of a wider result.
+func ivContainsPeriodic(x interval, offset, period float64) bool {
+	eps := 1e-12 * math.Max(1, math.Max(math.Abs(x.lo), math.Abs(x.hi)))
+	k := math.Ceil((x.lo - eps - offset) / period)
+	return offset+k*period <= x.hi+eps
+}
+
+// Interval extension of a periodic function fn with maxima at maxAt + 2k*pi and minima at minAt + 2k*pi
+func ivPeriodic(fn func(float64) float64, maxAt, minAt float64) func(interval, int) interval {
+	return func(x interval, lineno int) interval {
+		if math.IsInf(x.lo, 0) || math.IsInf(x.hi, 0) || x.hi-x.lo >= 2*math.Pi {
+			return interval{-1, 1}
+		}
+		a, b := fn(x.lo), fn(x.hi)
+		r := widen(math.Min(a, b), math.Max(a, b))
+		if ivContainsPeriodic(x, maxAt, 2*math.Pi) {
+			r.hi = 1
+		}
+		if ivContainsPeriodic(x, minAt, 2*math.Pi) {
+			r.lo = -1
+		}
+		return interval{math.Max(r.lo, -1), math.Min(r.hi, 1)}
+	}
+}
+
+var ivSin = ivPeriodic(math.Sin, math.Pi/2, -math.Pi/2)
+var ivCos = ivPeriodic(math.Cos, 0, math.Pi)
+
+func ivTan(x interval, lineno int) interval {
+	if math.IsInf(x.lo, 0) || math.IsInf(x.hi, 0) || ivContainsPeriodic(x, math.Pi/2, math.Pi) {
+		panic(fmt.Errorf("Interval %s contains a pole of tan at line %d", x, lineno))
+	}
+	return widen(math.Tan(x.lo), math.Tan(x.hi))
+}
+
+func ivCosh(x interval, lineno int) interval {
+	a := ivAbs(x)
+	r := widen(math.Cosh(a.lo), math.Cosh(a.hi))
+	return interval{math.Max(r.lo, 1), r.hi}
+}
+
+// Result of a comparison between intervals: 1 if it is certainly true, 0 if it is certainly false, the interval
+// [0 .. 1] if it is possibly true
+func ivBool(certainlyTrue, certainlyFalse bool) *value {
+	switch {
+	case certainlyTrue:
+		return newBoolval(true)
+	case certainlyFalse:
+		return newBoolval(false)
+	}
+	return newIntervalval(interval{0, 1})
+}
+
+func ivLess(x, y interval) *value {
+	return ivBool(x.hi < y.lo, x.lo >= y.hi)
+}
+
+func ivLessEq(x, y interval) *value {
+	return ivBool(x.hi <= y.lo, x.lo > y.hi)
+}
+
+func ivEqual(x, y interval) *value {
+	return ivBool(x.lo == x.hi && x == y, x.hi < y.lo || y.hi < x.lo)
+}
+
+func ivNotEqual(x, y interval) *value {
+	return ivBool(x.hi < y.lo || y.hi < x.lo, x.lo == x.hi && x == y)
+}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -24,16 +26,66 @@ type lexer struct {
 	input        *bufio.Reader
 	acc          []rune
 	acceptNonsyn bool
+	nesting      []rune // currently open parenthesis, brackets and braces ('[' for indexes, 'l' for list literals, '{' and 'v' for the keys and values of map literals, 'b' for blocks)
+	prev         token  // last emitted token
 }
 
 type lexerStateFn func(lx *lexer) lexerStateFn
 
 func (lx *lexer) emit(ttype tokenType, val string) {
-	lx.tokStream <- token{
-		ttype,
-		val,
-		lx.lineno,
+	switch ttype {
+	case PAROPTOK:
+		lx.nesting = append(lx.nesting, '(')
+	case BRKOPTOK:
+		// brackets following an operand index or slice it, anywhere else they open a list literal
+		switch lx.prev.ttype {
+		case SYMTOK, STRTOK, PARCLTOK, BRKCLTOK:
+			lx.nesting = append(lx.nesting, '[')
+		default:
+			lx.nesting = append(lx.nesting, 'l')
+		}
+	case CRLOPTOK:
+		// braces following ')' or 'else' open a block, anywhere else they open a map literal
+		if lx.prev.ttype == PARCLTOK || (lx.prev.ttype == KWDTOK && lx.prev.val == "else") {
+			lx.nesting = append(lx.nesting, 'b')
+		} else {
+			lx.nesting = append(lx.nesting, '{')
+		}
+	case QMARKTOK:
+		lx.nesting = append(lx.nesting, '?')
+	case COLONTOK:
+		if len(lx.nesting) > 0 {
+			switch lx.nesting[len(lx.nesting)-1] {
+			case '?':
+				lx.nesting = lx.nesting[:len(lx.nesting)-1]
+			case '{':
+				// the key of a map literal is followed by its value
+				lx.nesting[len(lx.nesting)-1] = 'v'
+			}
+		}
+	case COMMATOK:
+		if len(lx.nesting) > 0 && lx.nesting[len(lx.nesting)-1] == 'v' {
+			lx.nesting[len(lx.nesting)-1] = '{'
+		}
+	case PARCLTOK, BRKCLTOK, CRLCLTOK:
+		if len(lx.nesting) > 0 {
+			lx.nesting = lx.nesting[:len(lx.nesting)-1]
+		}
 	}
+	lx.prev = token{ttype, val, lx.lineno}
+	lx.tokStream <- lx.prev
+}
+
+// Returns true if a ':' following a number starts a time constant.
+// Directly inside an index, the keys of map literals and after the '?' of a conditional expression ':' is a separator
+// instead, so that xs[1:3] is a slice, {1: 2} is a map and c ? 1:2 is a conditional expression. List literals and the
+// values of map literals can contain time constants: [1:30, 2:00] and {1: 1:30}.
+func (lx *lexer) timeAllowed() bool {
+	if len(lx.nesting) == 0 {
+		return true
+	}
+	top := lx.nesting[len(lx.nesting)-1]
+	return top != '[' && top != '{' && top != '?'
 }
 
 // If err is not nil emits the appropriate error/eof tokens on tokStream and return true
@@ -58,31 +110,36 @@ func (lx *lexer) syntaxError(c rune) {
 	lx.emit(ERRTOK, fmt.Sprintf("Syntax error: unexpected character '%c' in line %d", c, lx.lineno))
 }
 
-// Reads an operator, remember that operators are at most two characters long. The first character of the operator must have already been read and stored in the accumulator
+// Reads an operator, the longest operator matching the input is read. The first character of the operator must have already been read and stored in the accumulator
 func lxFollow(lx *lexer) lexerStateFn {
 	c, _, err := lx.input.ReadRune()
 	if lx.lerror(err) {
 		return nil
 	}
 
-	ttype, ok := TokenTypes[string(lx.acc)]
+	first, ok := TokenTypes[string(lx.acc[0])]
 	if !ok {
 		panic(fmt.Errorf("Internal error: got inside lxFollow with an invalid accumulator: %v", lx.acc))
 	}
 
-	n := string(lx.acc[0]) + string(c)
+	n := string(lx.acc) + string(c)
 
-	//println("Lexed", string(lx.acc[0]), "looking if", n, "is in its continuations")
+	//println("Lexed", string(lx.acc), "looking if", n, "is a prefix of its continuations")
 
-	for _, cont := range ttype.LexFollow {
+	for _, cont := range first.LexFollow {
 		//println("\tpossible continuation:", cont.XName)
-		if cont.XName == n {
+		if strings.HasPrefix(cont.XName, n) {
 			lx.acc = append(lx.acc, c)
-			lx.emit(cont, string(lx.acc))
-			return lxBase
+			return lxFollow
 		}
 	}
 
+	ttype, ok := TokenTypes[string(lx.acc)]
+	if !ok {
+		lx.emit(ERRTOK, fmt.Sprintf("Syntax error: unknown operator '%s' in line %d", string(lx.acc), lx.lineno))
+		return nil
+	}
+
 	lx.emit(ttype, string(lx.acc))
 	return toBase1(lx, c, true)
 }
@@ -140,15 +197,14 @@ func lxReal(lx *lexer) lexerStateFn {
 		} else if c == '.' {
 			lx.acc = append(lx.acc, '.')
 			return lxRealFrac
-		} else if c == ':' {
+		} else if c == ':' && lx.timeAllowed() {
 			lx.acc = append(lx.acc, ':')
 			return lxTime1
 		} else if (c == 'e') || (c == 'E') {
 			lx.acc = append(lx.acc, c)
 			return lxRealExp
 		} else {
-			lx.emit(INTTOK, string(lx.acc))
-			return toBase1(lx, c, false)
+			return lxEndNumber(lx, INTTOK, c)
 		}
 	}
 	panic(fmt.Errorf("Unreachable"))
@@ -168,8 +224,7 @@ func lxRealFrac(lx *lexer) lexerStateFn {
 			lx.acc = append(lx.acc, c)
 			return lxRealExp
 		} else {
-			lx.emit(REALTOK, string(lx.acc))
-			return toBase1(lx, c, false)
+			return lxEndNumber(lx, REALTOK, c)
 		}
 	}
 	panic(fmt.Errorf("Unreachable"))
@@ -190,8 +245,7 @@ func lxRealExp(lx *lexer) lexerStateFn {
 		} else if first && ((c == '+') || (c == '-')) {
 			lx.acc = append(lx.acc, c)
 		} else {
-			lx.emit(REALTOK, string(lx.acc))
-			return toBase1(lx, c, false)
+			return lxEndNumber(lx, REALTOK, c)
 		}
 
 		first = false
@@ -199,6 +253,26 @@ func lxRealExp(lx *lexer) lexerStateFn {
 	panic(fmt.Errorf("Unreachable"))
 }
 
+// Emits the decimal number in the accumulator, c is the character following it.
+// If c is an imaginary suffix ('i' or 'j') the number is an imaginary constant, if it is 'd' a fixed point decimal.
+func lxEndNumber(lx *lexer, ttype tokenType, c rune) lexerStateFn {
+	switch c {
+	case 'i', 'j':
+		ttype = IMAGTOK
+	case 'd':
+		ttype = DECTOK
+	default:
+		lx.emit(ttype, string(lx.acc))
+		return toBase1(lx, c, false)
+	}
+	lx.emit(ttype, string(lx.acc))
+	c, _, err := lx.input.ReadRune()
+	if lx.lerror(err) {
+		return nil
+	}
+	return toBase1(lx, c, false)
+}
+
 // Reads a number, could be an octal number, an hexadecimal number or a fractional number
 // We assume that a 0 has already been read and is in lx.acc
 func lxNumber(lx *lexer) lexerStateFn {
@@ -221,17 +295,56 @@ func lxNumber(lx *lexer) lexerStateFn {
 		return lxRealFrac
 
 	case ':':
+		if !lx.timeAllowed() {
+			lx.emit(INTTOK, string(lx.acc))
+			return toBase1(lx, c, false)
+		}
 		lx.acc = append(lx.acc, c)
 		return lxTime1
 
 	default: // it was just a zero
-		lx.emit(INTTOK, string(lx.acc))
-		return toBase1(lx, c, false)
+		return lxEndNumber(lx, INTTOK, c)
 	}
 
 	panic(fmt.Errorf("Unreachable"))
 }
 
+// Reads a string literal, the opening '"' has already been read.
+// Escape sequences are the same as Go's interpreted string literals (\n, \t, \", \\, \xff, \u00e8…)
+func lxString(lx *lexer) lexerStateFn {
+	escaped := false
+	for {
+		c, _, err := lx.input.ReadRune()
+		if lx.lerror(err) {
+			return nil
+		}
+
+		switch {
+		case c == 0 || c == '\n':
+			lx.emit(ERRTOK, fmt.Sprintf("Syntax error: unterminated string in line %d", lx.lineno))
+			return nil
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			s, err := strconv.Unquote("\"" + string(lx.acc) + "\"")
+			if err != nil {
+				lx.emit(ERRTOK, fmt.Sprintf("Syntax error: wrong escape sequence in string in line %d", lx.lineno))
+				return nil
+			}
+			lx.emit(STRTOK, s)
+			c, _, err := lx.input.ReadRune()
+			if lx.lerror(err) {
+				return nil
+			}
+			return toBase1(lx, c, false)
+		}
+		lx.acc = append(lx.acc, c)
+	}
+	panic(fmt.Errorf("Unreachable"))
+}
+
 // Reads a date (it's just a sequence of numbers
 func lxDate(lx *lexer) lexerStateFn {
 	c, _, err := lx.input.ReadRune()
@@ -283,6 +396,10 @@ func lxOct(lx *lexer) lexerStateFn {
 		case '0', '1', '2', '3', '4', '5', '6', '7':
 			lx.acc = append(lx.acc, c)
 		case ':':
+			if !lx.timeAllowed() {
+				lx.emit(OCTTOK, string(lx.acc))
+				return toBase1(lx, c, false)
+			}
 			lx.acc = append(lx.acc, c)
 			return lxTime1
 		default:
@@ -325,8 +442,7 @@ func lxTimeIntl(lx *lexer, canReadMore bool) lexerStateFn {
 
 // Helper function, saves c into the accumulator then goes to the specified state
 func toState(lx *lexer, c rune, next lexerStateFn) lexerStateFn {
-	lx.acc[0] = c
-	lx.acc = lx.acc[:1]
+	lx.acc = append(lx.acc[:0], c)
 	return next
 }
 
@@ -384,6 +500,15 @@ func lxBase1(lx *lexer) lexerStateFn {
 			return nil
 		}
 
+	case '"':
+		if lx.acceptNonsyn {
+			lx.acc = []rune{}
+			return lxString
+		} else {
+			lx.syntaxError(c)
+			return nil
+		}
+
 	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		if lx.acceptNonsyn {
 			lx.acc = []rune{c}
@@ -184,3 +184,108 @@ func TestTimeToks(t *testing.T) {
 	f("00:01", token{TIMETOK, "00:01", 1})
 	f("1:1:1", token{TIMETOK, "1:1:1", 1})
 }
+
+func TestSliceToks(t *testing.T) {
+	s := "xs[1:2] (1:2)"
+	expected := []token{
+		{SYMTOK, "xs", 1},
+		{BRKOPTOK, "[", 1},
+		{INTTOK, "1", 1},
+		{COLONTOK, ":", 1},
+		{INTTOK, "2", 1},
+		{BRKCLTOK, "]", 1},
+		{PAROPTOK, "(", 1},
+		{TIMETOK, "1:2", 1},
+		{PARCLTOK, ")", 1},
+		{EOFTOK, "", 1},
+	}
+
+	tokens := lexAll(strings.NewReader(s))
+	tokEqual(t, tokens, expected)
+}
+
+func TestStringToks(t *testing.T) {
+	tokEqual(t, lexAll(strings.NewReader(`printf("a\"b\n", x)`)), []token{
+		{SYMTOK, "printf", 1},
+		{PAROPTOK, "(", 1},
+		{STRTOK, "a\"b\n", 1},
+		{COMMATOK, ",", 1},
+		{SYMTOK, "x", 1},
+		{PARCLTOK, ")", 1},
+		{EOFTOK, "", 1},
+	})
+	tokEqual(t, lexAll(strings.NewReader(`"abc`)), []token{
+		{ERRTOK, "Syntax error: unterminated string in line 1", 1},
+	})
+}
+
+func TestLexerLongestOp(t *testing.T) {
+	tokEqual(t, lexAll(strings.NewReader("a<<=b&^=c**=~d<e>>f&^g")), []token{
+		{SYMTOK, "a", 1},
+		{SHLEQTOK, "<<=", 1},
+		{SYMTOK, "b", 1},
+		{BWANDNOTEQTOK, "&^=", 1},
+		{SYMTOK, "c", 1},
+		{POWEQTOK, "**=", 1},
+		{BWNOTOPTOK, "~", 1},
+		{SYMTOK, "d", 1},
+		{LTOPTOK, "<", 1},
+		{SYMTOK, "e", 1},
+		{SHROPTOK, ">>", 1},
+		{SYMTOK, "f", 1},
+		{BWANDNOTOPTOK, "&^", 1},
+		{SYMTOK, "g", 1},
+		{EOFTOK, "", 1},
+	})
+}
+
+func TestImagToks(t *testing.T) {
+	tokEqual(t, lexAll(strings.NewReader("3i+2.5j-0i*1e3i")), []token{
+		{IMAGTOK, "3", 1},
+		{ADDOPTOK, "+", 1},
+		{IMAGTOK, "2.5", 1},
+		{SUBOPTOK, "-", 1},
+		{IMAGTOK, "0", 1},
+		{MULOPTOK, "*", 1},
+		{IMAGTOK, "1e3", 1},
+		{EOFTOK, "", 1},
+	})
+}
+
+func TestDecimalToks(t *testing.T) {
+	tokEqual(t, lexAll(strings.NewReader("12.50d*0d+1'000.5d")), []token{
+		{DECTOK, "12.50", 1},
+		{MULOPTOK, "*", 1},
+		{DECTOK, "0", 1},
+		{ADDOPTOK, "+", 1},
+		{DECTOK, "1000.5", 1},
+		{EOFTOK, "", 1},
+	})
+}
+
+func TestUncertainToks(t *testing.T) {
+	tokEqual(t, lexAll(strings.NewReader("1±2+/-3+-4")), []token{
+		{INTTOK, "1", 1},
+		{PMOPTOK, "±", 1},
+		{INTTOK, "2", 1},
+		{PMASCIIOPTOK, "+/-", 1},
+		{INTTOK, "3", 1},
+		{ADDOPTOK, "+", 1},
+		{SUBOPTOK, "-", 1},
+		{INTTOK, "4", 1},
+		{EOFTOK, "", 1},
+	})
+}
+
+func TestConvToks(t *testing.T) {
+	tokEqual(t, lexAll(strings.NewReader("x->m/s--1")), []token{
+		{SYMTOK, "x", 1},
+		{CONVTOK, "->", 1},
+		{SYMTOK, "m", 1},
+		{DIVOPTOK, "/", 1},
+		{SYMTOK, "s", 1},
+		{DECOPTOK, "--", 1},
+		{INTTOK, "1", 1},
+		{EOFTOK, "", 1},
+	})
+}
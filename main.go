@@ -5,14 +5,24 @@ import (
 	"io"
 	"math/big"
 	"os"
+	"strings"
 
 	"github.com/peterh/liner"
 )
 
 var programmerMode = false
 var exitRequested = false
+var bitWidth = 64         // width used by the ~ operator, 0 means unbounded
+var intMode intType       // type of integer results without a fixed width, selected with @:u32, @:i8, etc.
+var overflowError = false // integer overflows of fixed width integers are errors instead of wrapping around
+var strictMode = false    // assignments to undeclared variables inside functions are errors
+var modulus *big.Int      // integer arithmetic is done modulo modulus (@:mod), nil when it is disabled
 
 var CommaMode commaMode = rationalComma
+var floatPrec uint = 200 // precision, in bits, of numbers in big float mode (@:b)
+
+var decimalScale = 2                // minimum number of digits after the comma of decimals (@:scale)
+var decimalRounding = roundHalfEven // rounding mode of decimal arithmetic (@:round)
 
 type commaMode uint8
 
@@ -20,6 +30,7 @@ const (
 	undefinedComma = iota
 	floatComma
 	rationalComma
+	bigfloatComma
 )
 
 func main() {
@@ -32,11 +43,28 @@ func main() {
 		executeFile(callStack, initfile)
 	}
 
-	for _, arg := range os.Args[1:] {
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
 		if arg == "-i" {
 			interactive = 2
 			continue
 		}
+		if arg == "--seed" || strings.HasPrefix(arg, "--seed=") {
+			// seeds the random number generator for the following files and the interactive session
+			s, ok := strings.CutPrefix(arg, "--seed=")
+			if !ok && i+1 < len(args) {
+				i++
+				s = args[i]
+			}
+			n, ok := new(big.Int).SetString(s, 0)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Invalid seed %q\n", s)
+				os.Exit(1)
+			}
+			seedRandom(callStack, n)
+			continue
+		}
 		// disable interactive mode if not forced
 		if interactive < 2 {
 			interactive = 0
@@ -55,27 +83,30 @@ func main() {
 	varct := 0
 
 	for {
-		var prompt [4]byte
-
-		prompt[0] = 'p'
+		prompt := "p"
 
 		if programmerMode {
-			prompt[0] = 'P'
+			prompt = "P"
 		}
 
 		switch CommaMode {
 		case undefinedComma:
-			prompt[1] = '?'
+			prompt += "?"
 		case floatComma:
-			prompt[1] = 'f'
+			prompt += "f"
 		case rationalComma:
-			prompt[1] = 'r'
+			prompt += "r"
+		case bigfloatComma:
+			prompt += fmt.Sprintf("b%d", floatPrec)
+		}
+
+		if modulus != nil {
+			prompt += " mod " + modulus.String()
 		}
 
-		prompt[2] = '>'
-		prompt[3] = ' '
+		prompt += "> "
 
-		line, err := ls.Prompt(string(prompt[:]))
+		line, err := ls.Prompt(prompt)
 		if err != nil {
 			if err != io.EOF {
 				fmt.Fprintf(os.Stderr, "%v\n\n", err)
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Numeric root finding, integration, minimization and limits of user functions. The algorithms work on big floats
+// with the precision of the current mode: 53 bits in floating point and rational modes (where the builtin functions
+// are computed with floating point numbers) and the precision selected with @:b in big float mode. The function is called with, and the result is, a number of the current mode:
+// in rational mode results are inexact rationals, except for roots where the function is exactly zero.
+
+type numericSolver struct {
+	stack  []CallFrame
+	f      *value // function being evaluated
+	name   string // name of the builtin, for error messages
+	lineno int
+	prec   uint
+	exact  bool // the last evaluation of f returned an exact zero
+}
+
+func newNumericSolver(stack []CallFrame, f *value, name string, lineno int) *numericSolver {
+	if f.kind != PVAL && f.kind != BVAL {
+		panic(fmt.Errorf("The first argument of %s must be a function at line %d", name, lineno))
+	}
+	s := &numericSolver{stack: stack, f: f, name: name, lineno: lineno}
+	switch CommaMode {
+	case floatComma, rationalComma:
+		s.prec = 53
+	case bigfloatComma:
+		s.prec = floatPrec
+	default:
+		panic(fmt.Errorf("Real mode undefined, use @:r to select rational or @:f to select floating point at line %d", lineno))
+	}
+	return s
+}
+
+// Returns a new big float with the precision of the computation, set to x
+func (s *numericSolver) num(x float64) *big.Float {
+	return new(big.Float).SetPrec(s.prec).SetFloat64(x)
+}
+
+// Returns the argument v as a big float
+func (s *numericSolver) real(v *value) *big.Float {
+	if v.kind == DVAL && (math.IsInf(v.dval, 0) || math.IsNaN(v.dval)) {
+		panic(fmt.Errorf("Can not apply %s to %s at line %d: not a finite number", s.name, v, s.lineno))
+	}
+	if v.kind != IVAL && v.kind != RVAL && v.kind != DECVAL && v.kind != DVAL && v.kind != FVAL {
+		panic(fmt.Errorf("Can not apply %s to %s at line %d: not a real number", s.name, v, s.lineno))
+	}
+	return new(big.Float).SetPrec(s.prec).SetRat(v.Rat(s.lineno))
+}
+
+// Returns x as a number of the current mode, exact is false for results that are approximations
+func (s *numericSolver) value(x *big.Float, exact bool) *value {
+	switch CommaMode {
+	case floatComma:
+		f, _ := x.Float64()
+		return newFloatval(f, DECFLV)
+	case bigfloatComma:
+		return newBigFloatval(newBigFloat(floatPrec).Set(x))
+	}
+	r, _ := x.Rat(nil)
+	if exact && r.IsInt() {
+		return newIntval(*r.Num(), DECFLV)
+	}
+	prec := 0
+	if !r.IsInt() {
+		prec = 12
+	}
+	v := newRatval(*r, prec)
+	v.inexact = !exact
+	return v
+}
+
+// Calls f with the argument x, errors in f report where it was called from
+func (s *numericSolver) eval(x *big.Float) *big.Float {
+	defer func() {
+		if p := recover(); p != nil {
+			if err, ok := p.(error); ok {
+				panic(fmt.Errorf("%v (in the function called by %s at line %d)", err, s.name, s.lineno))
+			}
+			panic(p)
+		}
+	}()
+	r := callFunction(s.stack, s.f, []*value{s.value(x, true)}, s.name, s.lineno)
+	if r.kind == DVAL && (math.IsInf(r.dval, 0) || math.IsNaN(r.dval)) {
+		panic(fmt.Errorf("Function called by %s is not finite at %s at line %d", s.name, s.value(x, true), s.lineno))
+	}
+	if r.kind != IVAL && r.kind != RVAL && r.kind != DECVAL && r.kind != DVAL && r.kind != FVAL {
+		panic(fmt.Errorf("Function called by %s must return real numbers, it returned %s at %s at line %d", s.name, r, s.value(x, true), s.lineno))
+	}
+	y := new(big.Float).SetPrec(s.prec).SetRat(r.Rat(s.lineno))
+	s.exact = y.Sign() == 0 && (r.kind == IVAL || r.kind == RVAL || r.kind == DECVAL) && !r.inexact
+	return y
+}
+
+// Arithmetic on big floats with the precision of the computation
+func (s *numericSolver) add(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(s.prec).Add(x, y)
+}
+func (s *numericSolver) sub(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(s.prec).Sub(x, y)
+}
+func (s *numericSolver) mul(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(s.prec).Mul(x, y)
+}
+func (s *numericSolver) quo(x, y *big.Float) *big.Float {
+	return new(big.Float).SetPrec(s.prec).Quo(x, y)
+}
+func (s *numericSolver) abs(x *big.Float) *big.Float { return new(big.Float).SetPrec(s.prec).Abs(x) }
+func (s *numericSolver) neg(x *big.Float) *big.Float { return new(big.Float).SetPrec(s.prec).Neg(x) }
+
+// Returns the relative precision of the computation, 2**-prec
+func (s *numericSolver) eps() *big.Float {
+	return new(big.Float).SetPrec(s.prec).SetMantExp(big.NewFloat(1), -int(s.prec))
+}
+
+// Returns a root of f in [a, b] with Brent's method, f(a) and f(b) must have opposite signs
+func (s *numericSolver) solve(a, b *big.Float) (*big.Float, bool) {
+	fa, fb := s.eval(a), s.eval(b)
+	if fa.Sign() == 0 {
+		s.eval(a)
+		return a, s.exact
+	}
+	if fb.Sign() == 0 {
+		return b, s.exact
+	}
+	if fa.Sign() == fb.Sign() {
+		panic(fmt.Errorf("Solve needs a function with opposite signs at the ends of the interval at line %d: f(%s) = %s and f(%s) = %s", s.lineno, s.value(a, true), s.value(fa, true), s.value(b, true), s.value(fb, true)))
+	}
+	two, half := s.num(2), s.num(0.5)
+	eps := s.eps()
+	c, fc := a, fa
+	d := s.sub(b, a)
+	e := d
+	for i := 0; i < 10*int(s.prec); i++ {
+		if fb.Sign() == fc.Sign() {
+			c, fc = a, fa
+			d = s.sub(b, a)
+			e = d
+		}
+		if s.abs(fc).Cmp(s.abs(fb)) < 0 {
+			a, b, c = b, c, b
+			fa, fb, fc = fb, fc, fb
+		}
+		// tolerance: two ulps of b, or the smallest positive number representable with the precision near 0
+		tol := s.add(s.mul(s.mul(two, eps), s.abs(b)), eps)
+		m := s.mul(half, s.sub(c, b))
+		if s.abs(m).Cmp(tol) <= 0 || fb.Sign() == 0 {
+			return b, fb.Sign() == 0 && s.exact
+		}
+		if s.abs(e).Cmp(tol) < 0 || s.abs(fa).Cmp(s.abs(fb)) <= 0 {
+			// bisection
+			d, e = m, m
+		} else {
+			var p, q *big.Float
+			r := s.quo(fb, fa)
+			if a.Cmp(c) == 0 {
+				// secant
+				p = s.mul(s.mul(two, m), r)
+				q = s.sub(s.num(1), r)
+			} else {
+				// inverse quadratic interpolation
+				q = s.quo(fa, fc)
+				t := s.quo(fb, fc)
+				p = s.mul(r, s.sub(s.mul(s.mul(s.mul(two, m), q), s.sub(q, t)), s.mul(s.sub(b, a), s.sub(t, s.num(1)))))
+				q = s.mul(s.mul(s.sub(q, s.num(1)), s.sub(t, s.num(1))), s.sub(r, s.num(1)))
+			}
+			if p.Sign() > 0 {
+				q = s.neg(q)
+			} else {
+				p = s.neg(p)
+			}
+			prev := e
+			e = d
+			bound1 := s.sub(s.mul(s.mul(s.num(3), m), q), s.abs(s.mul(tol, q)))
+			bound2 := s.abs(s.mul(s.mul(half, prev), q))
+			if s.mul(two, p).Cmp(bound1) < 0 && p.Cmp(bound2) < 0 {
+				d = s.quo(p, q)
+			} else {
+				d, e = m, m
+			}
+		}
+		a, fa = b, fb
+		if s.abs(d).Cmp(tol) > 0 {
+			b = s.add(b, d)
+		} else if m.Sign() > 0 {
+			b = s.add(b, tol)
+		} else {
+			b = s.sub(b, tol)
+		}
+		fb = s.eval(b)
+	}
+	panic(fmt.Errorf("Solve did not converge at line %d", s.lineno))
+}
+
+// Returns the position of a minimum of f in [a, b] with Brent's method (golden section search and parabolic
+// interpolation). The position of a minimum can only be found to about half the digits of the precision.
+func (s *numericSolver) minimize(a, b *big.Float) *big.Float {
+	if a.Cmp(b) > 0 {
+		a, b = b, a
+	}
+	half, two := s.num(0.5), s.num(2)
+	golden := s.num((3 - math.Sqrt(5)) / 2)
+	if s.prec > 53 {
+		sqrt5 := new(big.Float).SetPrec(s.prec).Sqrt(s.num(5))
+		golden = s.mul(half, s.sub(s.num(3), sqrt5))
+	}
+	sqrtEps := new(big.Float).SetPrec(s.prec).Sqrt(s.eps())
+	x := s.add(a, s.mul(golden, s.sub(b, a)))
+	w, v := x, x
+	fx := s.eval(x)
+	fw, fv := fx, fx
+	d, e := s.num(0), s.num(0)
+	for i := 0; i < 10*int(s.prec); i++ {
+		xm := s.mul(half, s.add(a, b))
+		tol1 := s.add(s.mul(sqrtEps, s.abs(x)), s.quo(sqrtEps, s.num(3)))
+		tol2 := s.mul(two, tol1)
+		if s.abs(s.sub(x, xm)).Cmp(s.sub(tol2, s.mul(half, s.sub(b, a)))) <= 0 {
+			return x
+		}
+		useGolden := true
+		if s.abs(e).Cmp(tol1) > 0 {
+			// parabola through x, w and v
+			r := s.mul(s.sub(x, w), s.sub(fx, fv))
+			q := s.mul(s.sub(x, v), s.sub(fx, fw))
+			p := s.sub(s.mul(s.sub(x, v), q), s.mul(s.sub(x, w), r))
+			q = s.mul(two, s.sub(q, r))
+			if q.Sign() > 0 {
+				p = s.neg(p)
+			}
+			q = s.abs(q)
+			prev := e
+			e = d
+			if s.abs(p).Cmp(s.abs(s.mul(s.mul(half, q), prev))) < 0 && p.Cmp(s.mul(q, s.sub(a, x))) > 0 && p.Cmp(s.mul(q, s.sub(b, x))) < 0 {
+				d = s.quo(p, q)
+				u := s.add(x, d)
+				if s.sub(u, a).Cmp(tol2) < 0 || s.sub(b, u).Cmp(tol2) < 0 {
+					d = tol1
+					if xm.Cmp(x) < 0 {
+						d = s.neg(tol1)
+					}
+				}
+				useGolden = false
+			}
+		}
+		if useGolden {
+			if x.Cmp(xm) >= 0 {
+				e = s.sub(a, x)
+			} else {
+				e = s.sub(b, x)
+			}
+			d = s.mul(golden, e)
+		}
+		var u *big.Float
+		switch {
+		case s.abs(d).Cmp(tol1) >= 0:
+			u = s.add(x, d)
+		case d.Sign() > 0:
+			u = s.add(x, tol1)
+		default:
+			u = s.sub(x, tol1)
+		}
+		fu := s.eval(u)
+		if fu.Cmp(fx) <= 0 {
+			if u.Cmp(x) >= 0 {
+				a = x
+			} else {
+				b = x
+			}
+			v, fv, w, fw, x, fx = w, fw, x, fx, u, fu
+		} else {
+			if u.Cmp(x) < 0 {
+				a = u
+			} else {
+				b = u
+			}
+			if fu.Cmp(fw) <= 0 || w.Cmp(x) == 0 {
+				v, fv, w, fw = w, fw, u, fu
+			} else if fu.Cmp(fv) <= 0 || v.Cmp(x) == 0 || v.Cmp(w) == 0 {
+				v, fv = u, fu
+			}
+		}
+	}
+	panic(fmt.Errorf("Minimize did not converge at line %d", s.lineno))
+}
+
+// Nodes and weights of the Gauss-Legendre rules, indexed by number of points and precision
+var gaussLegendreCache = map[[2]uint][2][]*big.Float{}
+
+// Returns the nodes and weights of the n points Gauss-Legendre rule on [-1, 1], computed with Newton's method on the
+// Legendre polynomial of degree n
+func (s *numericSolver) gaussLegendre(n int) ([]*big.Float, []*big.Float) {
+	key := [2]uint{uint(n), s.prec}
+	if r, ok := gaussLegendreCache[key]; ok {
+		return r[0], r[1]
+	}
+	wp := s.prec + 32
+	nf := func(x float64) *big.Float { return new(big.Float).SetPrec(wp).SetFloat64(x) }
+	eps := new(big.Float).SetMantExp(big.NewFloat(1), -int(s.prec)-8)
+	nodes, weights := make([]*big.Float, n), make([]*big.Float, n)
+	for i := 0; i < n; i++ {
+		x := nf(math.Cos(math.Pi * (float64(i) + 0.75) / (float64(n) + 0.5)))
+		var dp *big.Float
+		for iter := 0; iter < 100; iter++ {
+			// P_k(x) by the recurrence k*P_k = (2k-1)*x*P_(k-1) - (k-1)*P_(k-2)
+			p0, p1 := nf(1), new(big.Float).SetPrec(wp).Set(x)
+			for k := 2; k <= n; k++ {
+				t := new(big.Float).SetPrec(wp).Mul(nf(float64(2*k-1)), x)
+				t.Mul(t, p1)
+				t.Sub(t, new(big.Float).SetPrec(wp).Mul(nf(float64(k-1)), p0))
+				t.Quo(t, nf(float64(k)))
+				p0, p1 = p1, t
+			}
+			// P'_n(x) = n*(x*P_n - P_(n-1))/(x**2 - 1)
+			dp = new(big.Float).SetPrec(wp).Mul(x, p1)
+			dp.Sub(dp, p0)
+			dp.Mul(dp, nf(float64(n)))
+			x2 := new(big.Float).SetPrec(wp).Mul(x, x)
+			dp.Quo(dp, x2.Sub(x2, nf(1)))
+			dx := new(big.Float).SetPrec(wp).Quo(p1, dp)
+			x.Sub(x, dx)
+			if dx.Abs(dx).Cmp(eps) <= 0 {
+				break
+			}
+		}
+		// w = 2/((1 - x**2)*P'_n(x)**2)
+		w := new(big.Float).SetPrec(wp).Mul(x, x)
+		w.Sub(nf(1), w)
+		w.Mul(w, dp)
+		w.Mul(w, dp)
+		w.Quo(nf(2), w)
+		nodes[i] = new(big.Float).SetPrec(s.prec).Set(x)
+		weights[i] = new(big.Float).SetPrec(s.prec).Set(w)
+	}
+	gaussLegendreCache[key] = [2][]*big.Float{nodes, weights}
+	return nodes, weights
+}
+
+// Applies the Gauss-Legendre rule to f on [a, b], returns the integral and the integral of |f|
+func (s *numericSolver) quadrature(a, b *big.Float, nodes, weights []*big.Float) (*big.Float, *big.Float) {
+	half := s.num(0.5)
+	r := s.mul(half, s.sub(b, a))
+	c := s.mul(half, s.add(a, b))
+	sum, sumAbs := s.num(0), s.num(0)
+	for i := range nodes {
+		y := s.mul(weights[i], s.eval(s.add(c, s.mul(r, nodes[i]))))
+		sum = s.add(sum, y)
+		sumAbs = s.add(sumAbs, s.abs(y))
+	}
+	return s.mul(r, sum), s.abs(s.mul(r, sumAbs))
+}
+
+// Returns the integral of f on [a, b] with adaptive Gauss-Legendre quadrature: intervals are halved until the sum of
+// the integrals on the two halves agrees with the integral on the whole interval. Intervals at a or b that still don't
+// converge after many halvings have a singularity at the end of the integral, they are integrated with tanh-sinh
+// quadrature. Singularities inside [a, b] are errors.
+func (s *numericSolver) integrate(a, b *big.Float) *big.Float {
+	nodes, weights := s.gaussLegendre(max(10, int(s.prec)/10))
+	whole, wholeAbs := s.quadrature(a, b, nodes, weights)
+	if wholeAbs.Sign() == 0 {
+		return whole
+	}
+	// the error is relative to the integral of |f|, so that integrals that are (almost) zero terminate, the last bits
+	// are lost to rounding errors in f
+	tol := s.mul(s.mul(wholeAbs, s.eps()), s.num(256))
+	intervals := 0
+	var adapt func(a1, b1, whole, wholeAbs *big.Float, depth int) *big.Float
+	adapt = func(a1, b1, whole, wholeAbs *big.Float, depth int) *big.Float {
+		intervals++
+		if intervals > 10000 || depth > 60 {
+			panic(fmt.Errorf("Integrate did not converge at line %d: the function might have a singularity", s.lineno))
+		}
+		m := s.mul(s.num(0.5), s.add(a1, b1))
+		left, leftAbs := s.quadrature(a1, m, nodes, weights)
+		right, rightAbs := s.quadrature(m, b1, nodes, weights)
+		sum := s.add(left, right)
+		// the integrals of |f| must agree too, otherwise a function that blows up with opposite signs on both sides of
+		// the middle (1/x on [-1, 1]) converges to 0
+		blowup := s.abs(s.sub(s.add(leftAbs, rightAbs), wholeAbs)).Cmp(s.mul(s.num(0.125), wholeAbs)) > 0
+		if s.abs(s.sub(sum, whole)).Cmp(tol) <= 0 && !blowup {
+			return sum
+		}
+		if depth >= 16 && (a1.Cmp(a) == 0 || b1.Cmp(b) == 0) {
+			return s.tanhSinh(a1, b1)
+		}
+		return s.add(adapt(a1, m, left, leftAbs, depth+1), adapt(m, b1, right, rightAbs, depth+1))
+	}
+	return adapt(a, b, whole, wholeAbs, 0)
+}
+
+// Returns the integral of f on [a, b] with tanh-sinh quadrature, which handles integrable singularities at a and b:
+// x = c + r*tanh(pi/2*sinh(t)) crowds the nodes double exponentially close to the ends, where the weights vanish as
+// fast. The step h is halved until two estimates agree. The terms of a function that is not integrable don't vanish
+// before the nodes reach the ends.
+func (s *numericSolver) tanhSinh(a, b *big.Float) *big.Float {
+	wp := s.prec + 32
+	halfPi := newBigFloat(wp).Quo(bigPi(wp), big.NewFloat(2))
+	c, r := s.mul(s.num(0.5), s.add(a, b)), s.mul(s.num(0.5), s.sub(b, a))
+	// the nodes don't get closer to the ends than r*eps**8
+	minDist := s.mul(r, new(big.Float).SetPrec(s.prec).SetMantExp(big.NewFloat(1), -8*int(s.prec)))
+	// the nodes at t and -t: with u = pi/2*sinh(t) and q = exp(-2u), their distance to the ends is r*2q/(1 + q) and
+	// their weight r*pi/2*cosh(t)*4q/(1 + q)**2
+	node := func(t *big.Float) (*big.Float, *big.Float) {
+		u := newBigFloat(wp).Mul(halfPi, bigSinh(t, wp))
+		q := bigExp(newBigFloat(wp).Mul(u, big.NewFloat(-2)), wp)
+		q1 := newBigFloat(wp).Add(q, big.NewFloat(1))
+		d := newBigFloat(wp).Quo(newBigFloat(wp).Mul(q, big.NewFloat(2)), q1)
+		w := newBigFloat(wp).Mul(halfPi, bigCosh(t, wp))
+		w.Mul(w, newBigFloat(wp).Quo(newBigFloat(wp).Mul(q, big.NewFloat(4)), newBigFloat(wp).Mul(q1, q1)))
+		return s.mul(r, d), s.mul(r, w)
+	}
+	sum := s.mul(s.mul(r, halfPi), s.eval(c))
+	sumAbs := s.abs(sum)
+	h := s.num(1)
+	// the terms beyond the last nodes before the ends, where they round to the ends, are missing from the sums
+	truncated := s.num(0)
+	var prev *big.Float
+	for level := 0; level < 12; level++ {
+		// the first level has the nodes k*h, the others add the odd multiples of h
+		t, step := s.num(1), s.num(1)
+		if level > 0 {
+			t, step = h, s.mul(h, s.num(2))
+		}
+		// the last two terms at each end, done once the nodes reach that end
+		ends := [2]*big.Float{a, b}
+		var last, prevLast [2]*big.Float
+		var done [2]bool
+		for !done[0] || !done[1] {
+			d, w := node(t)
+			negligibleTerms := true
+			for i, x := range []*big.Float{s.add(a, d), s.sub(b, d)} {
+				if done[i] {
+					continue
+				}
+				if d.Cmp(minDist) < 0 || x.Cmp(ends[i]) == 0 {
+					// the terms of an integrable function decrease until the nodes reach the end
+					if prevLast[i] != nil && last[i].Cmp(prevLast[i]) >= 0 {
+						panic(fmt.Errorf("Integrate did not converge at line %d: the function might have a singularity", s.lineno))
+					}
+					if last[i] != nil && last[i].Cmp(truncated) > 0 {
+						truncated = last[i]
+					}
+					done[i] = true
+					continue
+				}
+				y := s.mul(w, s.eval(x))
+				sum, sumAbs = s.add(sum, y), s.add(sumAbs, s.abs(y))
+				last[i], prevLast[i] = s.abs(y), last[i]
+				negligibleTerms = negligibleTerms && negligible(last[i], sumAbs, s.prec)
+			}
+			if negligibleTerms {
+				break
+			}
+			t = s.add(t, step)
+		}
+		est := s.mul(sum, h)
+		tol := s.mul(s.add(s.mul(s.mul(sumAbs, s.eps()), s.num(256)), truncated), h)
+		if prev != nil && s.abs(s.sub(est, prev)).Cmp(tol) <= 0 {
+			return est
+		}
+		prev = est
+		h = s.mul(h, s.num(0.5))
+	}
+	panic(fmt.Errorf("Integrate did not converge at line %d: the function might have a singularity", s.lineno))
+}
+
+// Returns the limit of f at x0 from the side of sign dir (1 from the right, -1 from the left) and an estimate of its
+// error, using Richardson extrapolation of f(x0 + dir*h) for h = h0/2**k. If the extrapolation doesn't reach at least
+// half the digits of the precision the limit is an error.
+func (s *numericSolver) limit(x0 *big.Float, dir int) (*big.Float, *big.Float) {
+	h := s.mul(s.num(float64(dir)/16), s.add(s.abs(x0), s.num(1)))
+	var best, bestErr *big.Float
+	prev := []*big.Float{}
+	samples := []*big.Float{}
+	for k := 0; k < 40; k++ {
+		row := []*big.Float{s.eval(s.add(x0, h))}
+		samples = append(samples, row[0])
+		// T[k][j] = T[k][j-1] + (T[k][j-1] - T[k-1][j-1])/(2**j - 1)
+		for j := 1; j <= k && j <= 12; j++ {
+			t := s.quo(s.sub(row[j-1], prev[j-1]), s.num(math.Pow(2, float64(j))-1))
+			row = append(row, s.add(row[j-1], t))
+		}
+		if k > 0 {
+			last, prevLast := row[len(row)-1], prev[len(prev)-1]
+			err := s.abs(s.sub(last, prevLast))
+			if best == nil || err.Cmp(bestErr) < 0 {
+				best, bestErr = last, err
+			}
+			tol := s.mul(s.mul(s.eps(), s.num(16)), s.add(s.abs(last), s.num(1)))
+			if err.Cmp(tol) <= 0 {
+				break
+			}
+		}
+		prev = row
+		h = s.mul(h, s.num(0.5))
+	}
+	if bestErr.Cmp(s.mul(new(big.Float).SetPrec(s.prec).Sqrt(s.eps()), s.add(s.abs(best), s.num(1)))) > 0 {
+		// values of the same sign that keep growing
+		growing := true
+		for i := len(samples) - 10; i < len(samples); i++ {
+			if samples[i].Sign() == 0 || samples[i].Sign() != samples[i-1].Sign() || s.abs(samples[i]).Cmp(s.abs(samples[i-1])) <= 0 {
+				growing = false
+			}
+		}
+		if growing {
+			panic(fmt.Errorf("Limit did not converge at line %d: the function grows without bound at %s", s.lineno, s.value(x0, true)))
+		}
+		panic(fmt.Errorf("Limit did not converge at %s at line %d", s.value(x0, true), s.lineno))
+	}
+	return best, bestErr
+}
+
+// Root of f between a and b
+var btnSolve = makeStackFuncValue(3, 3, func(stack []CallFrame, argv []*value, lineno int) *value {
+	s := newNumericSolver(stack, argv[0], "solve", lineno)
+	return s.value(s.solve(s.real(argv[1]), s.real(argv[2])))
+})
+
+// Integral of f from a to b
+var btnIntegrate = makeStackFuncValue(3, 3, func(stack []CallFrame, argv []*value, lineno int) *value {
+	s := newNumericSolver(stack, argv[0], "integrate", lineno)
+	a, b := s.real(argv[1]), s.real(argv[2])
+	if a.Cmp(b) == 0 {
+		return s.value(s.num(0), true)
+	}
+	return s.value(s.integrate(a, b), false)
+})
+
+// Position of a minimum of f between a and b
+var btnMinimize = makeStackFuncValue(3, 3, func(stack []CallFrame, argv []*value, lineno int) *value {
+	s := newNumericSolver(stack, argv[0], "minimize", lineno)
+	return s.value(s.minimize(s.real(argv[1]), s.real(argv[2])), false)
+})
+
+// Limit of f at x, from the right if dir is positive, from the left if it is negative, from both sides if it is omitted
+var btnLimit = makeStackFuncValue(2, 3, func(stack []CallFrame, argv []*value, lineno int) *value {
+	s := newNumericSolver(stack, argv[0], "limit", lineno)
+	x := s.real(argv[1])
+	if len(argv) == 3 {
+		dir := s.real(argv[2])
+		if dir.Sign() == 0 {
+			panic(fmt.Errorf("The direction of limit must be positive (from the right) or negative (from the left) at line %d", lineno))
+		}
+		l, _ := s.limit(x, dir.Sign())
+		return s.value(l, false)
+	}
+	right, rightErr := s.limit(x, 1)
+	left, leftErr := s.limit(x, -1)
+	// the limits agree if they differ by less than their estimated errors, or half the digits
+	tol := s.mul(new(big.Float).SetPrec(s.prec).Sqrt(s.eps()), s.add(s.abs(right), s.num(1)))
+	if s.abs(s.sub(right, left)).Cmp(s.add(tol, s.mul(s.num(10), s.add(rightErr, leftErr)))) > 0 {
+		panic(fmt.Errorf("The limits from the left (%s) and from the right (%s) are different at line %d", s.value(left, false), s.value(right, false), lineno))
+	}
+	return s.value(s.mul(s.num(0.5), s.add(left, right)), false)
+})
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Number theory on unbounded integers: all these builtins need integer arguments and return integers (or lists of
+// integers), they work on numbers of any size.
+
+var bigOne = big.NewInt(1)
+
+// Returns the arguments as integers, panics if one of them is not an integer
+func intArgs(name string, argv []*value, lineno int) []*big.Int {
+	r := make([]*big.Int, len(argv))
+	for i, vv := range argv {
+		if vv.kind != IVAL {
+			panic(fmt.Errorf("Can not apply %s to non-integer value at line %d", name, lineno))
+		}
+		r[i] = &vv.ival
+	}
+	return r
+}
+
+// Returns the integer argument of a builtin that needs a non negative argument that fits in an int64
+func smallIntArg(name string, vv *value, lineno int) int64 {
+	x := intArgs(name, []*value{vv}, lineno)[0]
+	if x.Sign() < 0 || !x.IsInt64() {
+		panic(fmt.Errorf("Can not apply %s to %s at line %d: needs a non negative integer smaller than 2**63", name, x, lineno))
+	}
+	return x.Int64()
+}
+
+// Returns the modulus argument of name, which must be positive
+func modulusArg(name string, m *big.Int, lineno int) *big.Int {
+	if m.Sign() <= 0 {
+		panic(fmt.Errorf("The modulus of %s must be positive, not %s at line %d", name, m, lineno))
+	}
+	return m
+}
+
+func newBigIntval(x *big.Int) *value {
+	return newIntval(*x, DECFLV)
+}
+
+// Returns the inverse of a modulo m, panics if it does not exist
+func modInverse(name string, a, m *big.Int, lineno int) *big.Int {
+	x := new(big.Int).Mod(a, m)
+	if m.Cmp(bigOne) == 0 {
+		return x
+	}
+	if x.ModInverse(x, m) == nil {
+		panic(fmt.Errorf("Can not apply %s at line %d: %s has no inverse modulo %s", name, lineno, a, m))
+	}
+	return x
+}
+
+var btnGcd = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	r := new(big.Int)
+	for _, x := range intArgs("gcd", argv, lineno) {
+		r.GCD(nil, nil, r, new(big.Int).Abs(x))
+	}
+	return newBigIntval(r)
+})
+
+var btnLcm = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	r := big.NewInt(1)
+	for _, x := range intArgs("lcm", argv, lineno) {
+		if x.Sign() == 0 {
+			return newZeroVal(IVAL, DECFLV, 0)
+		}
+		g := new(big.Int).GCD(nil, nil, r, new(big.Int).Abs(x))
+		r.Mul(r, new(big.Int).Quo(new(big.Int).Abs(x), g))
+	}
+	return newBigIntval(r)
+})
+
+// b**e mod m, negative exponents use the inverse of b
+var btnModpow = makeFuncValue(3, func(argv []*value, lineno int) *value {
+	x := intArgs("modpow", argv, lineno)
+	b, e, m := x[0], x[1], modulusArg("modpow", x[2], lineno)
+	if e.Sign() < 0 {
+		b, e = modInverse("modpow", b, m, lineno), new(big.Int).Neg(e)
+	}
+	return newBigIntval(new(big.Int).Exp(new(big.Int).Mod(b, m), e, m))
+})
+
+var btnModinv = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	x := intArgs("modinv", argv, lineno)
+	return newBigIntval(modInverse("modinv", x[0], modulusArg("modinv", x[1], lineno), lineno))
+})
+
+// Number of Miller-Rabin rounds of isprime, ProbablyPrime also runs a Baillie-PSW test which has no known
+// counterexamples
+const primeRounds = 20
+
+var btnIsprime = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	return newBoolval(intArgs("isprime", argv, lineno)[0].ProbablyPrime(primeRounds))
+})
+
+// Smallest prime larger than n
+var btnNextprime = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	n := intArgs("nextprime", argv, lineno)[0]
+	if n.Cmp(big.NewInt(2)) < 0 {
+		return newIntval(*big.NewInt(2), DECFLV)
+	}
+	p := new(big.Int).Add(n, bigOne)
+	if p.Bit(0) == 0 {
+		p.Add(p, bigOne)
+	}
+	for ; !p.ProbablyPrime(primeRounds); p.Add(p, big.NewInt(2)) {
+	}
+	return newBigIntval(p)
+})
+
+// Returns a non trivial factor of the composite odd number n with Pollard's rho method (Brent's variant)
+func pollardRho(n *big.Int) *big.Int {
+	for c := int64(1); ; c++ {
+		bc := big.NewInt(c)
+		f := func(x *big.Int) *big.Int {
+			x.Mul(x, x)
+			x.Add(x, bc)
+			return x.Mod(x, n)
+		}
+		y, x, q := big.NewInt(2), new(big.Int), big.NewInt(1)
+		ys := new(big.Int)
+		g := big.NewInt(1)
+		// products of 128 differences are computed before taking their gcd with n
+		const m = 128
+		for r := 1; g.Cmp(bigOne) == 0; r *= 2 {
+			x.Set(y)
+			for i := 0; i < r; i++ {
+				f(y)
+			}
+			for k := 0; k < r && g.Cmp(bigOne) == 0; k += m {
+				ys.Set(y)
+				for i := 0; i < min(m, r-k); i++ {
+					f(y)
+					d := new(big.Int).Sub(x, y)
+					q.Mul(q, d.Abs(d))
+					q.Mod(q, n)
+				}
+				g.GCD(nil, nil, q, n)
+			}
+		}
+		if g.Cmp(n) == 0 {
+			// the product of the last batch was a multiple of n, redo it one step at a time
+			for {
+				f(ys)
+				d := new(big.Int).Sub(x, ys)
+				g.GCD(nil, nil, d.Abs(d), n)
+				if g.Cmp(bigOne) != 0 {
+					break
+				}
+			}
+		}
+		if g.Cmp(n) != 0 {
+			return g
+		}
+		// the cycle closed without finding a factor, try another polynomial
+	}
+}
+
+// Returns the prime factors of n > 0, sorted and repeated according to their multiplicity
+func primeFactors(n *big.Int) []*big.Int {
+	factors := []*big.Int{}
+	n = new(big.Int).Set(n)
+	// small factors by trial division
+	for p := int64(2); p < 1000; p++ {
+		bp := big.NewInt(p)
+		if new(big.Int).Mul(bp, bp).Cmp(n) > 0 {
+			break
+		}
+		for new(big.Int).Mod(n, bp).Sign() == 0 {
+			factors = append(factors, bp)
+			n.Quo(n, bp)
+		}
+	}
+	var split func(n *big.Int)
+	split = func(n *big.Int) {
+		if n.Cmp(bigOne) == 0 {
+			return
+		}
+		if n.ProbablyPrime(primeRounds) {
+			factors = append(factors, n)
+			return
+		}
+		d := pollardRho(n)
+		split(d)
+		split(new(big.Int).Quo(n, d))
+	}
+	split(n)
+	sort.Slice(factors, func(i, j int) bool { return factors[i].Cmp(factors[j]) < 0 })
+	return factors
+}
+
+// List of the prime factors of n, prod(factor(n)) is n
+var btnFactor = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	n := intArgs("factor", argv, lineno)[0]
+	if n.Sign() <= 0 {
+		panic(fmt.Errorf("Factor needs a positive integer, not %s at line %d", n, lineno))
+	}
+	elems := []*value{}
+	for _, p := range primeFactors(n) {
+		elems = append(elems, newBigIntval(p))
+	}
+	return newListval(elems)
+})
+
+// Euler's totient function, the number of integers between 1 and n coprime with n
+var btnPhi = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	n := intArgs("phi", argv, lineno)[0]
+	if n.Sign() <= 0 {
+		panic(fmt.Errorf("Phi needs a positive integer, not %s at line %d", n, lineno))
+	}
+	// phi(n) = n * prod((p - 1)/p) for the distinct primes p dividing n
+	r := new(big.Int).Set(n)
+	var last *big.Int
+	for _, p := range primeFactors(n) {
+		if last == nil || p.Cmp(last) != 0 {
+			r.Quo(r, p)
+			r.Mul(r, new(big.Int).Sub(p, bigOne))
+			last = p
+		}
+	}
+	return newBigIntval(r)
+})
+
+var btnFactorial = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	n := smallIntArg("factorial", argv[0], lineno)
+	if n > 1e7 {
+		panic(fmt.Errorf("Factorial of %d is too large at line %d", n, lineno))
+	}
+	return newBigIntval(new(big.Int).MulRange(1, n))
+})
+
+// Binomial coefficient, the number of ways to choose k elements among n
+var btnBinomial = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	x := intArgs("binomial", argv, lineno)
+	n, k := x[0], x[1]
+	if n.Sign() < 0 {
+		panic(fmt.Errorf("Binomial needs a non negative n, not %s at line %d", n, lineno))
+	}
+	if k.Sign() < 0 || k.Cmp(n) > 0 {
+		return newZeroVal(IVAL, DECFLV, 0)
+	}
+	// C(n, k) = C(n, n-k), the loop runs min(k, n-k) times
+	if kk := new(big.Int).Sub(n, k); kk.Cmp(k) < 0 {
+		k = kk
+	}
+	if !k.IsInt64() || k.Int64() > 1e7 {
+		panic(fmt.Errorf("Can not apply binomial to %s, %s at line %d: result too large", n, x[1], lineno))
+	}
+	r := big.NewInt(1)
+	for i := int64(1); i <= k.Int64(); i++ {
+		// r*(n-k+i) is always divisible by i
+		r.Mul(r, new(big.Int).Add(new(big.Int).Sub(n, k), big.NewInt(i)))
+		r.Quo(r, big.NewInt(i))
+	}
+	return newBigIntval(r)
+})
+
+// Integer square root, rounded down
+var btnIsqrt = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	n := intArgs("isqrt", argv, lineno)[0]
+	if n.Sign() < 0 {
+		panic(fmt.Errorf("Isqrt needs a non negative integer, not %s at line %d", n, lineno))
+	}
+	return newBigIntval(new(big.Int).Sqrt(n))
+})
+
+// Integer k-th root, rounded towards zero, odd roots of negative numbers are negative
+var btnIroot = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	n := intArgs("iroot", argv, lineno)[0]
+	k := smallIntArg("iroot", argv[1], lineno)
+	if k == 0 {
+		panic(fmt.Errorf("Iroot needs a positive k at line %d", lineno))
+	}
+	if n.Sign() < 0 {
+		if k%2 == 0 {
+			panic(fmt.Errorf("Even root of negative number %s in iroot at line %d", n, lineno))
+		}
+		r, _ := intRoot(new(big.Int).Neg(n), k)
+		return newBigIntval(r.Neg(r))
+	}
+	r, _ := intRoot(n, k)
+	return newBigIntval(r)
+})
+
+// Jacobi symbol (a/n), n must be odd and positive
+var btnJacobi = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	x := intArgs("jacobi", argv, lineno)
+	if x[1].Sign() <= 0 || x[1].Bit(0) == 0 {
+		panic(fmt.Errorf("Jacobi needs an odd positive n, not %s at line %d", x[1], lineno))
+	}
+	return newIntval(*big.NewInt(int64(big.Jacobi(x[0], x[1]))), DECFLV)
+})
+
+// Chinese remainder theorem: the smallest x >= 0 with x = rs[i] mod ms[i] for every i, the moduli do not need to be
+// coprime but then the remainders must be compatible
+var btnCrt = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	rs := intArgs("crt", listArg("crt", argv[0], lineno), lineno)
+	ms := intArgs("crt", listArg("crt", argv[1], lineno), lineno)
+	if len(rs) != len(ms) {
+		panic(fmt.Errorf("Crt needs as many remainders as moduli at line %d", lineno))
+	}
+	x, m := big.NewInt(0), big.NewInt(1)
+	for i := range rs {
+		mi := modulusArg("crt", ms[i], lineno)
+		// x + m*t = rs[i] mod mi, solvable if g = gcd(m, mi) divides rs[i] - x
+		g, u := new(big.Int), new(big.Int)
+		g.GCD(u, nil, m, mi)
+		d := new(big.Int).Sub(rs[i], x)
+		if new(big.Int).Mod(d, g).Sign() != 0 {
+			panic(fmt.Errorf("No solution for crt at line %d: %s mod %s is incompatible with the previous remainders", lineno, rs[i], mi))
+		}
+		mig := new(big.Int).Quo(mi, g)
+		t := d.Quo(d, g)
+		t.Mul(t, u)
+		t.Mod(t, mig)
+		x.Add(x, t.Mul(t, m))
+		m.Mul(m, mig)
+		x.Mod(x, m)
+	}
+	return newBigIntval(x)
+})
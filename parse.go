@@ -13,6 +13,8 @@ import (
 type tokenStream struct {
 	tokStream chan token
 	rewound   []token // lookahead tokens
+	loopDepth int     // number of loops enclosing the statement being parsed
+	fnDepth   int     // number of function definitions enclosing the statement being parsed
 }
 
 func (ts *tokenStream) get() token {
@@ -71,7 +73,7 @@ func parse(tokStream chan token) (n AstNode, err error) {
 			}
 		}
 	}()
-	ts := &tokenStream{tokStream, make([]token, 0)}
+	ts := &tokenStream{tokStream, make([]token, 0), 0, 0}
 	n = parseStatements(ts, true)
 	return
 }
@@ -119,12 +121,19 @@ func parseStatements(ts *tokenStream, toplevel bool) AstNode {
 }
 
 // Parses a function definition
+// func-def ::= func <symbol> <func-literal>
 func parseFnDef(ts *tokenStream, lineno int) AstNode {
 	nameTok := ts.get()
 	if nameTok.ttype != SYMTOK {
 		unexpectedToken(nameTok, " (while parsing function definition)")
 	}
 
+	return parseFnLiteral(ts, nameTok.val, lineno)
+}
+
+// Parses the argument list and body of a function, the 'func' keyword and the name (if any) have already been read
+// func-literal ::= (<symbol>, …) { <statement-list> }
+func parseFnLiteral(ts *tokenStream, name string, lineno int) AstNode {
 	tokMust(PAROPTOK, ts, " (while parsing function definition)")
 	first := true
 	args := []string{}
@@ -148,16 +157,20 @@ func parseFnDef(ts *tokenStream, lineno int) AstNode {
 	}
 
 	tokMust(CRLOPTOK, ts, " (while parsing function definition)")
+	loopDepth := ts.loopDepth
+	ts.loopDepth = 0
+	ts.fnDepth++
 	body := parseStatements(ts, false)
+	ts.fnDepth--
+	ts.loopDepth = loopDepth
 	tokMust(CRLCLTOK, ts, " (while parsing function definition)")
 
-	return NewFnDefNode(nameTok.val, args, body, lineno)
+	return NewFnDefNode(name, args, body, lineno)
 }
 
 // Parses a statement, the first token already read
-// statement ::= <if> | <while> | <for> | <func-def> | "@" [<expression>] | <expression>;
+// statement ::= <if> | <while> | <for> | <func-def> | <return> | <decl>; | break; | continue; | "@" [<expression>] | <expression>;
 // the semicolon at the end of the expression becomes optional if toplevel == true
-// function definitions can only appear at toplevel
 func parseStatement(ts *tokenStream, toplevel bool) AstNode {
 	tok := ts.get()
 
@@ -176,8 +189,14 @@ func parseStatement(ts *tokenStream, toplevel bool) AstNode {
 
 	switch tok.val {
 	case "func":
-		if !toplevel {
-			unexpectedToken(tok, " (can not define nested functions)")
+		tok2 := ts.get()
+		ts.rewind(tok2)
+		if tok2.ttype != SYMTOK {
+			// anonymous function used as an expression
+			ts.rewind(tok)
+			e := parseExpressionSet(ts)
+			parseSemicolon(ts, toplevel)
+			return e
 		}
 		return parseFnDef(ts, tok.lineno)
 	case "if":
@@ -190,6 +209,29 @@ func parseStatement(ts *tokenStream, toplevel bool) AstNode {
 		e := parseExit(ts, tok.lineno)
 		parseSemicolon(ts, toplevel)
 		return e
+	case "return":
+		if ts.fnDepth <= 0 {
+			unexpectedToken(tok, " (outside of a function)")
+		}
+		e := parseReturn(ts, tok.lineno)
+		parseSemicolon(ts, toplevel)
+		return e
+	case "local", "global":
+		if ts.fnDepth <= 0 {
+			unexpectedToken(tok, " (outside of a function)")
+		}
+		e := parseDecl(ts, tok)
+		parseSemicolon(ts, toplevel)
+		return e
+	case "break", "continue":
+		if ts.loopDepth <= 0 {
+			unexpectedToken(tok, " (outside of a loop)")
+		}
+		parseSemicolon(ts, toplevel)
+		if tok.val == "break" {
+			return &BreakNode{tok.lineno}
+		}
+		return &ContinueNode{tok.lineno}
 	}
 	unexpectedToken(tok, " (while parsing a statement)")
 	panic("Unreachable")
@@ -242,15 +284,35 @@ func parseWhile(ts *tokenStream, lineno int) AstNode {
 	guard := parseExpressionSet(ts)
 	tokMust(PARCLTOK, ts, " (parsing 'while' statement)")
 	tokMust(CRLOPTOK, ts, " (parsing 'while' statement)")
+	ts.loopDepth++
 	body := parseStatements(ts, false)
+	ts.loopDepth--
 	tokMust(CRLCLTOK, ts, " (parsing 'while' statement)")
 	return NewWhileNode(guard, body, lineno)
 }
 
 // Parses a for statement, note that the 'for' keyword itself has already been read
-// for ::= for (<expression>; <expression>; <expression>) { <statement-list> }
+// for ::= for (<expression>; <expression>; <expression>) { <statement-list> } | for (<symbol> in <expression>) { <statement-list> }
 func parseFor(ts *tokenStream, lineno int) AstNode {
 	tokMust(PAROPTOK, ts, " (parsing 'for' statement)")
+
+	tok1 := ts.get()
+	if tok1.ttype == SYMTOK {
+		tok2 := ts.get()
+		if tok2.ttype == KWDTOK && tok2.val == "in" {
+			expr := parseExpressionSet(ts)
+			tokMust(PARCLTOK, ts, " (parsing 'for' statement)")
+			tokMust(CRLOPTOK, ts, " (parsing 'for' statement)")
+			ts.loopDepth++
+			body := parseStatements(ts, false)
+			ts.loopDepth--
+			tokMust(CRLCLTOK, ts, " (parsing 'for' statements)")
+			return NewForInNode(tok1.val, expr, body, lineno)
+		}
+		ts.rewind(tok2)
+	}
+	ts.rewind(tok1)
+
 	initExpr := parseExpressionSet(ts)
 	tokMust(SCOLTOK, ts, " (parsing 'for' statement)")
 	guard := parseExpressionSet(ts)
@@ -258,7 +320,9 @@ func parseFor(ts *tokenStream, lineno int) AstNode {
 	incrExpr := parseExpressionSet(ts)
 	tokMust(PARCLTOK, ts, " (parsing 'for' statement)")
 	tokMust(CRLOPTOK, ts, " (parsing 'for' statement)")
+	ts.loopDepth++
 	body := parseStatements(ts, false)
+	ts.loopDepth--
 	tokMust(CRLCLTOK, ts, " (parsing 'for' statements)")
 	return NewForNode(initExpr, guard, incrExpr, body, lineno)
 }
@@ -269,7 +333,7 @@ func parseDpy(ts *tokenStream, lineno int) AstNode {
 	switch tok.ttype {
 	case SCOLTOK, EOFTOK:
 		ts.rewind(tok)
-		return &DpyNode{NewVarNode("_", lineno), false, false, 0, lineno}
+		return &DpyNode{expr: NewVarNode("_", lineno), lineno: lineno}
 	case COLONTOK:
 		tok = ts.get()
 		if tok.ttype != SYMTOK {
@@ -277,13 +341,85 @@ func parseDpy(ts *tokenStream, lineno int) AstNode {
 		}
 		switch tok.val {
 		case "p":
-			return &DpyNode{nil, true, false, 0, lineno}
+			return &DpyNode{toggleProg: true, lineno: lineno}
 		case "f":
 			CommaMode = floatComma
-			return &DpyNode{nil, false, true, floatComma, lineno}
+			return &DpyNode{changeComma: true, commaMode: floatComma, lineno: lineno}
 		case "r":
 			CommaMode = rationalComma
-			return &DpyNode{nil, false, true, rationalComma, lineno}
+			return &DpyNode{changeComma: true, commaMode: rationalComma, lineno: lineno}
+		case "b":
+			tok = ts.get()
+			if tok.ttype == INTTOK {
+				prec, err := strconv.ParseUint(tok.val, 10, 32)
+				if err != nil || prec < 2 || prec > 1<<20 {
+					panic(fmt.Errorf("Syntax error: invalid precision %s at line %d", tok.val, tok.lineno))
+				}
+				floatPrec = uint(prec)
+			} else {
+				ts.rewind(tok)
+			}
+			CommaMode = bigfloatComma
+			return &DpyNode{changeComma: true, commaMode: bigfloatComma, floatPrec: floatPrec, lineno: lineno}
+		case "strict", "nostrict":
+			return &DpyNode{changeStrict: true, strict: tok.val == "strict", lineno: lineno}
+		case "u8", "u16", "u32", "u64", "i8", "i16", "i32", "i64", "int":
+			return &DpyNode{changeIntMode: true, intMode: parseIntType(tok.val), lineno: lineno}
+		case "overflow", "wrap":
+			return &DpyNode{changeOverflow: true, overflow: tok.val == "overflow", lineno: lineno}
+		case "bits":
+			tok = ts.get()
+			if tok.ttype != INTTOK {
+				unexpectedToken(tok, " (expected bit width while parsing display statement)")
+			}
+			bits, err := strconv.Atoi(tok.val)
+			if err != nil || bits > 1<<16 {
+				panic(fmt.Errorf("Syntax error: invalid bit width %s at line %d", tok.val, tok.lineno))
+			}
+			return &DpyNode{changeBits: true, bits: bits, lineno: lineno}
+		case "scale":
+			tok = ts.get()
+			if tok.ttype != INTTOK {
+				unexpectedToken(tok, " (expected number of digits while parsing display statement)")
+			}
+			scale, err := strconv.Atoi(tok.val)
+			if err != nil || scale > 1000 {
+				panic(fmt.Errorf("Syntax error: invalid scale %s at line %d", tok.val, tok.lineno))
+			}
+			return &DpyNode{changeScale: true, scale: scale, lineno: lineno}
+		case "mod":
+			tok = ts.get()
+			if tok.ttype != INTTOK {
+				unexpectedToken(tok, " (expected modulus while parsing display statement)")
+			}
+			m, ok := new(big.Int).SetString(tok.val, 10)
+			if !ok {
+				panic(fmt.Errorf("Syntax error: invalid modulus %s at line %d", tok.val, tok.lineno))
+			}
+			if m.Sign() == 0 {
+				// @:mod 0 goes back to ordinary integers
+				m = nil
+			}
+			return &DpyNode{changeModulus: true, modulus: m, lineno: lineno}
+		case "unit":
+			return parseUnitDef(ts, lineno)
+		case "round":
+			tok = ts.get()
+			name := tok.val
+			if tok.ttype == SYMTOK && name == "half" {
+				// half-even is lexed as half - even
+				if minus := ts.get(); minus.ttype == SUBOPTOK {
+					tok = ts.get()
+					name += "-" + tok.val
+				} else {
+					ts.rewind(minus)
+				}
+			}
+			mode, ok := parseRoundingMode(name)
+			if tok.ttype != SYMTOK || !ok {
+				unexpectedToken(tok, " (expected half-even, half-up, down, up, ceiling or floor while parsing display statement)")
+			}
+			return &DpyNode{changeRounding: true, rounding: mode, lineno: lineno}
 		default:
 			unexpectedToken(tok, " (while parsing display statement)")
 		}
@@ -291,7 +427,40 @@ func parseDpy(ts *tokenStream, lineno int) AstNode {
 
 	ts.rewind(tok)
 	expr := parseExpressionSet(ts)
-	return &DpyNode{expr, false, false, 0, lineno}
+	return &DpyNode{expr: expr, lineno: lineno}
+}
+
+// Parses the definition of a new unit, its value is a number followed by a unit (or either of them)
+// unit-def ::= unit <symbol> = [<number>] [<unit>]
+func parseUnitDef(ts *tokenStream, lineno int) AstNode {
+	name := tokMust(SYMTOK, ts, " (expected unit name while parsing unit definition)")
+	tokMust(SETOPTOK, ts, " (while parsing unit definition)")
+	factor := big.NewRat(1, 1)
+	tok := ts.get()
+	if tok.ttype == INTTOK || tok.ttype == REALTOK {
+		if _, ok := factor.SetString(tok.val); !ok {
+			panic(fmt.Errorf("Syntax error: wrong number format at line %d", tok.lineno))
+		}
+		tok = ts.get()
+	}
+	ts.rewind(tok)
+	u := newUnit(nil)
+	if tok.ttype == SYMTOK || tok.ttype == PAROPTOK {
+		u = parseUnit(ts, " (while parsing unit definition)")
+	}
+	// units are used by the parser, the unit is defined immediately
+	def := &unitDef{factor.Mul(factor, u.factor), u.dim, false}
+	unitTable[name] = def
+	return &DpyNode{defineUnit: true, unitName: name, unit: def, lineno: lineno}
+}
+
+// Parses the name of an integer type: u8, u16, u32, u64, i8, i16, i32, i64 or int for unbounded integers
+func parseIntType(name string) intType {
+	if name == "int" {
+		return intType{}
+	}
+	bits, _ := strconv.Atoi(name[1:])
+	return intType{bits: bits, signed: name[0] == 'i'}
 }
 
 func parseExit(ts *tokenStream, lineno int) AstNode {
@@ -303,36 +472,108 @@ func parseExit(ts *tokenStream, lineno int) AstNode {
 	return &ExitNode{lineno}
 }
 
+// Parses a variable declaration, the 'local' or 'global' keyword is kwtok
+// decl ::= local <symbol> [= <expression>], … | global <symbol>, …
+func parseDecl(ts *tokenStream, kwtok token) AstNode {
+	names := []string{}
+	inits := []AstNode{}
+	for {
+		tok := ts.get()
+		if tok.ttype != SYMTOK {
+			unexpectedToken(tok, " (expected symbol while parsing declaration)")
+		}
+		names = append(names, tok.val)
+
+		var init AstNode
+		tok = ts.get()
+		if tok.ttype == SETOPTOK && kwtok.val == "local" {
+			init = parseExpressionInfix(ts)
+			tok = ts.get()
+		}
+		inits = append(inits, init)
+
+		if tok.ttype != COMMATOK {
+			ts.rewind(tok)
+			break
+		}
+	}
+	if kwtok.val == "global" {
+		return &GlobalNode{names, kwtok.lineno}
+	}
+	return &LocalNode{names, inits, kwtok.lineno}
+}
+
+// Parses a return statement, the 'return' keyword has already been read
+// return ::= return [<expression>]
+func parseReturn(ts *tokenStream, lineno int) AstNode {
+	tok := ts.get()
+	ts.rewind(tok)
+	if tok.ttype == SCOLTOK || tok.ttype == EOFTOK || tok.ttype == CRLCLTOK {
+		return &ReturnNode{nil, lineno}
+	}
+	return &ReturnNode{parseExpressionSet(ts), lineno}
+}
+
 // Parses assignment expression, this only does the infix operator parsing, everything else is offloaded to parseExpressionNoinfix
-// expressionSet ::= <var> <assignment-operator> <expressionInfix> | <expressionInfix>
+// expressionSet ::= <var> <assignment-operator> <expressionInfix> | <expressionNoinfix><index> <assignment-operator> <expressionInfix> | <expressionInfix>
 func parseExpressionSet(ts *tokenStream) AstNode {
 	tok1 := ts.get()
-	if tok1.ttype != SYMTOK {
-		ts.rewind(tok1)
-		return parseExpressionInfix(ts)
+	if tok1.ttype == SYMTOK {
+		tok2 := ts.get() // fun fact: this the thing that makes this grammar LL(2) instead of LL(1)
+		if tok2.ttype.IsSetOperator {
+			return NewSetOpNode(tok2, tok1.val, parseExpressionConv(ts))
+		}
+		ts.rewind(tok2)
 	}
+	ts.rewind(tok1)
 
-	tok2 := ts.get() // fun fact: this the thing that makes this grammar LL(2) instead of LL(1)
-	if tok2.ttype.IsSetOperator {
-		return NewSetOpNode(tok2, tok1.val, parseExpressionInfix(ts))
+	n := parseExpressionConv(ts)
+
+	// assignment to an element: the left hand side is only known to be assignable after parsing it
+	tok := ts.get()
+	if tok.ttype.IsSetOperator {
+		target, ok := n.(*IndexNode)
+		if !ok {
+			unexpectedToken(tok, " (left side of assignment is not assignable)")
+		}
+		return NewSetIndexNode(tok, target, parseExpressionConv(ts))
 	}
+	ts.rewind(tok)
+	return n
+}
 
-	ts.rewind(tok2)
-	ts.rewind(tok1)
-	return parseExpressionInfix(ts)
+// Parses an expression followed by conversions to units
+// expressionConv ::= <expressionConv> -> <unit> | <expressionInfix>
+func parseExpressionConv(ts *tokenStream) AstNode {
+	n := parseExpressionInfix(ts)
+	for {
+		tok := ts.get()
+		if tok.ttype != CONVTOK {
+			ts.rewind(tok)
+			return n
+		}
+		n = NewConvNode(n, parseUnit(ts, " (while parsing unit conversion)"), tok.lineno)
+	}
 }
 
 // Parses infix expression using dijkstra algorithm:
 // https://en.wikipedia.org/wiki/Shunting-yard_algorithm
 //
 // Equivalent BNF productions:
+// expressionCond ::= <expressionComp> ? <expressionSet> : <expressionCond> | <expressionComp>
 // expressionComp ::= <expressionBool> <comparison-operator> <expressionComp> | <expressionBool>
 // expressionBool ::= <expressionAdd> <bool-opeartor> <expressionBool> | <expressionAdd>
 // expressionAdd ::= <expressionMul> <add-or-subtract> <expressionAdd> | <expressionMul>
 // expressionMul ::= <expressionNoninfix> <mul-or-div> <expressionMul> | <expressionNoninfix>
 func parseExpressionInfix(ts *tokenStream) AstNode {
+	// operators waiting for their right operand, mid is the middle expression of a conditional operator
+	type pendingOp struct {
+		tok token
+		mid AstNode
+	}
+
 	outStack := []AstNode{}
-	opStack := []token{}
+	opStack := []pendingOp{}
 
 	outpop := func() AstNode {
 		r := outStack[len(outStack)-1]
@@ -345,14 +586,21 @@ func parseExpressionInfix(ts *tokenStream) AstNode {
 		opStack = opStack[:len(opStack)-1]
 		right := outpop()
 		left := outpop()
-		outStack = append(outStack, NewBinOpNode(op, left, right))
+		switch op.tok.ttype {
+		case QMARKTOK:
+			outStack = append(outStack, NewCondNode(left, op.mid, right, op.tok.lineno))
+		case ANDOPTOK, OROPTOK:
+			outStack = append(outStack, NewShortCircuitNode(op.tok, left, right))
+		default:
+			outStack = append(outStack, NewBinOpNode(op.tok, left, right))
+		}
 	}
 
 	for {
 		outStack = append(outStack, parseExpressionNoinfix(ts))
 
 		tokop := ts.get()
-		if tokop.ttype == EOFTOK || tokop.ttype == PARCLTOK || tokop.ttype == SCOLTOK || tokop.ttype == COMMATOK {
+		if tokop.ttype == EOFTOK || tokop.ttype == PARCLTOK || tokop.ttype == SCOLTOK || tokop.ttype == COMMATOK || tokop.ttype == BRKCLTOK || tokop.ttype == COLONTOK || tokop.ttype == CRLCLTOK || tokop.ttype == CONVTOK || tokop.ttype.IsSetOperator {
 			ts.rewind(tokop)
 			break
 		}
@@ -367,14 +615,22 @@ func parseExpressionInfix(ts *tokenStream) AstNode {
 			}
 
 			prevop := opStack[len(opStack)-1]
-			if tokop.ttype.Priority > prevop.ttype.Priority {
+			if tokop.ttype.Priority > prevop.tok.ttype.Priority {
+				break
+			}
+			// right associative operators (** and ?:) don't pop operators of the same priority: 2**3**2 is 2**(3**2)
+			if tokop.ttype.RightAssoc && tokop.ttype.Priority == prevop.tok.ttype.Priority {
 				break
 			}
-			// if tokop is right associative break when tokop.ttype.Priority >= prevop.ttype.Priority
 			oppop()
 		}
 
-		opStack = append(opStack, tokop)
+		op := pendingOp{tok: tokop}
+		if tokop.ttype == QMARKTOK {
+			op.mid = parseExpressionSet(ts)
+			tokMust(COLONTOK, ts, " (while parsing conditional expression)")
+		}
+		opStack = append(opStack, op)
 	}
 
 	for len(opStack) > 0 {
@@ -389,24 +645,30 @@ func parseExpressionInfix(ts *tokenStream) AstNode {
 }
 
 // Parses everything related to expressions except infix operators (because they are hard)
-// expressionNoinfix ::= <literal> | <symbol>++ | <symbol>-- | <symbol>(<expression>, …) | <symbol> | +<expressionNoinfix> | -<expressionNoinfix> | !<expressionNoinfix> | (<expression>)
+// expressionNoinfix ::= <literal> | <symbol>++ | <symbol>-- | <symbol>(<expression>, …) | <symbol> | +<expressionNoinfix> | -<expressionNoinfix> | !<expressionNoinfix> | (<expression>) | [<expression>, …] | <map> | func <func-literal> | <expressionNoinfix>(<expression>, …) | <expressionNoinfix><index>
 func parseExpressionNoinfix(ts *tokenStream) AstNode {
 	tok := ts.get()
 
 	switch tok.ttype {
 	/* leaves */
 	case REALTOK:
-		return parseReal(tok.val, tok.lineno)
+		return parseUnitLiteral(ts, parseReal(tok.val, tok.lineno), tok.lineno)
 	case INTTOK:
-		return parseInt(tok.val, 10, tok.lineno)
+		return parseUnitLiteral(ts, parseInt(tok.val, 10, tok.lineno), tok.lineno)
 	case HEXTOK:
 		return parseInt(tok.val[2:], 16, tok.lineno)
 	case OCTTOK:
 		return parseInt(tok.val[1:], 8, tok.lineno)
+	case IMAGTOK:
+		return parseImag(tok.val, tok.lineno)
+	case DECTOK:
+		return parseUnitLiteral(ts, parseDecimal(tok.val, tok.lineno), tok.lineno)
 	case DATETOK:
 		return parseDate(tok.val, tok.lineno)
 	case TIMETOK:
 		return parseTime(tok.val, tok.lineno)
+	case STRTOK:
+		return parsePostfix(ts, NewConstNode(newStrval(tok.val), tok.lineno))
 
 	/* variables, function calls, postfix operators */
 	case SYMTOK:
@@ -421,12 +683,12 @@ func parseExpressionNoinfix(ts *tokenStream) AstNode {
 
 		/* function call */
 		case PAROPTOK:
-			return parseFnCall(tok.val, ts, tok.lineno)
+			return parsePostfix(ts, parseFnCall(tok.val, ts, tok.lineno))
 
 		/* just a simple variable */
 		default:
 			ts.rewind(tok2)
-			return NewVarNode(tok.val, tok.lineno)
+			return parsePostfix(ts, NewVarNode(tok.val, tok.lineno))
 		}
 
 	/* prefix unary operators */
@@ -434,15 +696,28 @@ func parseExpressionNoinfix(ts *tokenStream) AstNode {
 		return parseExpressionNoinfix(ts)
 	case SUBOPTOK:
 		return NewUniOpNode(tok, parseExpressionNoinfix(ts))
-	case NEGOPTOK:
+	case NEGOPTOK, BWNOTOPTOK:
 		return NewUniOpNode(tok, parseExpressionNoinfix(ts))
 
 	/* subexpression */
 	case PAROPTOK:
 		n := parseExpressionSet(ts)
 		tokMust(PARCLTOK, ts, " (while parsing subexpression)")
-		return n
+		return parsePostfix(ts, n)
+
+	/* list literal */
+	case BRKOPTOK:
+		return parsePostfix(ts, NewListNode(parseExpressionList(ts, BRKCLTOK, " (while parsing list)"), tok.lineno))
 
+	/* map literal */
+	case CRLOPTOK:
+		return parsePostfix(ts, parseMap(ts, tok.lineno))
+
+	/* anonymous function */
+	case KWDTOK:
+		if tok.val == "func" {
+			return parsePostfix(ts, parseFnLiteral(ts, "", tok.lineno))
+		}
 	}
 
 	unexpectedToken(tok, " (while parsing basic expression)")
@@ -451,17 +726,91 @@ func parseExpressionNoinfix(ts *tokenStream) AstNode {
 
 // parses a function call, both the name of the function and the parenthesis have already been parsed
 func parseFnCall(name string, ts *tokenStream, lineno int) AstNode {
+	return NewFnCallNode(name, parseExpressionList(ts, PARCLTOK, " (while parsing function call)"), lineno)
+}
+
+// parses calls and indexing applied to the value of n, as in make_adder(3)(4) or xs[1][2]
+func parsePostfix(ts *tokenStream, n AstNode) AstNode {
+	for {
+		tok := ts.get()
+		switch tok.ttype {
+		case PAROPTOK:
+			n = NewExprCallNode(n, parseExpressionList(ts, PARCLTOK, " (while parsing function call)"), tok.lineno)
+		case BRKOPTOK:
+			n = parseIndex(ts, n, tok.lineno)
+		default:
+			ts.rewind(tok)
+			return n
+		}
+	}
+}
+
+// parses an index or a slice of n, the open bracket has already been parsed
+// index ::= [<expression>] | [[<expression>] : [<expression>]]
+func parseIndex(ts *tokenStream, n AstNode, lineno int) AstNode {
+	var lo AstNode
+	tok := ts.get()
+	ts.rewind(tok)
+	if tok.ttype != COLONTOK {
+		lo = parseExpressionSet(ts)
+	}
+
+	tok = ts.get()
+	switch tok.ttype {
+	case BRKCLTOK:
+		if lo != nil {
+			return NewIndexNode(n, lo, lineno)
+		}
+	case COLONTOK:
+		var hi AstNode
+		tok2 := ts.get()
+		ts.rewind(tok2)
+		if tok2.ttype != BRKCLTOK {
+			hi = parseExpressionSet(ts)
+		}
+		tokMust(BRKCLTOK, ts, " (while parsing slice)")
+		return NewSliceNode(n, lo, hi, lineno)
+	}
+	unexpectedToken(tok, " (while parsing index)")
+	panic("Unreachable")
+}
+
+// parses a map literal, the open brace has already been parsed
+// map ::= { <expression>: <expression>, … }
+func parseMap(ts *tokenStream, lineno int) AstNode {
+	keys, vals := []AstNode{}, []AstNode{}
+	for {
+		tok := ts.get()
+		if tok.ttype == CRLCLTOK {
+			return NewMapNode(keys, vals, lineno)
+		}
+		if len(keys) > 0 {
+			if tok.ttype != COMMATOK {
+				unexpectedToken(tok, " (while parsing map)")
+			}
+		} else {
+			ts.rewind(tok)
+		}
+
+		keys = append(keys, parseExpressionSet(ts))
+		tokMust(COLONTOK, ts, " (while parsing map)")
+		vals = append(vals, parseExpressionSet(ts))
+	}
+}
+
+// parses a comma separated list of expressions terminated by close, the opening token has already been parsed
+func parseExpressionList(ts *tokenStream, close tokenType, when string) []AstNode {
 	args := []AstNode{}
 	first := true
 	for {
 		tok := ts.get()
-		if tok.ttype == PARCLTOK {
-			return NewFnCallNode(name, args, lineno)
+		if tok.ttype == close {
+			return args
 		}
 
 		if !first {
 			if tok.ttype != COMMATOK {
-				unexpectedToken(tok, " (while parsing function call)")
+				unexpectedToken(tok, when)
 			}
 		} else {
 			ts.rewind(tok)
@@ -496,11 +845,151 @@ func parseReal(s string, lineno int) AstNode {
 			panic(fmt.Errorf("Syntax error: wrong number format at line %d", lineno))
 		}
 		return NewConstNode(newRatval(v, max(1, strprec(s))), lineno)
+	case bigfloatComma:
+		v, _, err := big.ParseFloat(s, 10, floatPrec, big.ToNearestEven)
+		if err != nil {
+			panic(fmt.Errorf("Syntax error: wrong number format at line %d: %s", lineno, err.Error()))
+		}
+		return NewConstNode(newBigFloatval(v), lineno)
 	default:
 		panic("unexpected")
 	}
 }
 
+// Parses the unit following a number literal n, if there is one. The unit must be on the same line as the number and,
+// after * and /, only names of known units are part of it: 10 m / s is a speed but 10 m / t divides by the variable t.
+// unit-literal ::= <unit-term> | <unit-literal> * <unit-term> | <unit-literal> / <unit-term>
+func parseUnitLiteral(ts *tokenStream, n AstNode, lineno int) AstNode {
+	isUnit := func(tok token) bool {
+		_, ok := lookupUnit(tok.val)
+		return tok.ttype == SYMTOK && tok.lineno == lineno && ok
+	}
+	tok := ts.get()
+	if !isUnit(tok) {
+		ts.rewind(tok)
+		return n
+	}
+	u := parseUnitTerm(ts, tok)
+	for {
+		op := ts.get()
+		if op.ttype != MULOPTOK && op.ttype != DIVOPTOK {
+			ts.rewind(op)
+			break
+		}
+		tok := ts.get()
+		if !isUnit(tok) {
+			ts.rewind(tok)
+			ts.rewind(op)
+			break
+		}
+		u = unitMulOp(u, parseUnitTerm(ts, tok), op)
+	}
+	x := n.(*ConstNode).v
+	return NewConstNode(newQuantityval(&x, u, lineno), lineno)
+}
+
+// Parses a unit
+// unit ::= <unit-factor> | <unit> * <unit-factor> | <unit> / <unit-factor>
+// unit-factor ::= <unit-term> | ( <unit> ) [** [-]<integer>] | 1
+func parseUnit(ts *tokenStream, when string) unit {
+	var u unit
+	for first := true; ; first = false {
+		var op token
+		if !first {
+			op = ts.get()
+			if op.ttype != MULOPTOK && op.ttype != DIVOPTOK {
+				ts.rewind(op)
+				return u
+			}
+		}
+		var f unit
+		tok := ts.get()
+		switch {
+		case tok.ttype == SYMTOK:
+			f = parseUnitTerm(ts, tok)
+		case tok.ttype == PAROPTOK:
+			f = parseUnit(ts, when)
+			tokMust(PARCLTOK, ts, when)
+			f = parseUnitExp(ts, f)
+		case tok.ttype == INTTOK && tok.val == "1":
+			f = newUnit(nil)
+		default:
+			unexpectedToken(tok, when)
+		}
+		if first {
+			u = f
+		} else {
+			u = unitMulOp(u, f, op)
+		}
+	}
+}
+
+func unitMulOp(u, f unit, op token) unit {
+	if op.ttype == DIVOPTOK {
+		return unitMul(u, f, -1)
+	}
+	return unitMul(u, f, 1)
+}
+
+// Parses a unit name, already read as tok, and its exponent
+// unit-term ::= <symbol> [** [-]<integer>]
+func parseUnitTerm(ts *tokenStream, tok token) unit {
+	def, ok := lookupUnit(tok.val)
+	if !ok {
+		panic(fmt.Errorf("Syntax error: unknown unit '%s' in line %d", tok.val, tok.lineno))
+	}
+	return parseUnitExp(ts, namedUnit(tok.val, def))
+}
+
+// Parses the optional integer exponent of the unit u
+func parseUnitExp(ts *tokenStream, u unit) unit {
+	tok := ts.get()
+	if tok.ttype != POWOPTOK {
+		ts.rewind(tok)
+		return u
+	}
+	sign := 1
+	tok = ts.get()
+	if tok.ttype == SUBOPTOK {
+		sign = -1
+		tok = ts.get()
+	}
+	if tok.ttype != INTTOK {
+		unexpectedToken(tok, " (expected integer exponent while parsing unit)")
+	}
+	n, err := strconv.Atoi(tok.val)
+	if err != nil || n > 1<<16 {
+		panic(fmt.Errorf("Syntax error: invalid exponent %s at line %d", tok.val, tok.lineno))
+	}
+	r, _ := unitPow(u, sign*n, 1)
+	return r
+}
+
+// Parses an imaginary constant, the 'i' or 'j' suffix has already been removed by the lexer
+func parseImag(s string, lineno int) AstNode {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		panic(fmt.Errorf("Syntax error: wrong number format at line %d: %s", lineno, err.Error()))
+	}
+	return NewConstNode(newComplexval(complex(0, v)), lineno)
+}
+
+// Parses a decimal constant, the 'd' suffix has already been removed by the lexer. The scale is the number of
+// digits written after the comma, when the constant is evaluated it is raised to the scale selected with @:scale
+func parseDecimal(s string, lineno int) AstNode {
+	var v big.Rat
+	if _, ok := v.SetString(s); !ok {
+		panic(fmt.Errorf("Syntax error: wrong number format at line %d", lineno))
+	}
+	// digits needed to represent the number exactly, they can be more than the written ones with an exponent
+	digits := 0
+	for x := new(big.Rat).Set(&v); !x.IsInt(); digits++ {
+		x.Mul(x, big.NewRat(10, 1))
+	}
+	scale := max(digits, strprec(s))
+	return NewConstNode(newDecimalval(roundRat(&v, scale, roundDown), scale), lineno)
+}
+
 // if s is a string representing a floating point number it returns the
 // number of digits after the comma.
 func strprec(s string) int {
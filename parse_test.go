@@ -125,6 +125,93 @@ func TestParseFnDef(t *testing.T) {
 		"BodyNode<[FnDefNode<afn, [a b c], BodyNode<[SetOpNode<=, a, ConstNode<0, 0, 0>>]>>]>")
 }
 
+func TestParseClosure(t *testing.T) {
+	matchAst(t,
+		"f = func(x) { x; }",
+		"BodyNode<[SetOpNode<=, f, FnDefNode<, [x], BodyNode<[VarNode<x>]>>>]>")
+	matchAst(t,
+		"adder(3)(4)",
+		"BodyNode<[FnCallNode<FnCallNode<adder, [ConstNode<0, 3, 0>]>, [ConstNode<0, 4, 0>]>]>")
+	matchAst(t,
+		"func a() { func b() { 1; } }",
+		"BodyNode<[FnDefNode<a, [], BodyNode<[FnDefNode<b, [], BodyNode<[ConstNode<0, 1, 0>]>>]>>]>")
+}
+
+func TestParseList(t *testing.T) {
+	matchAst(t,
+		"[1, a]",
+		"BodyNode<[ListNode<[ConstNode<0, 1, 0> VarNode<a>]>]>")
+	matchAst(t,
+		"xs[1][i+1]",
+		"BodyNode<[IndexNode<IndexNode<VarNode<xs>, ConstNode<0, 1, 0>>, BinOpNode<+, VarNode<i>, ConstNode<0, 1, 0>>>]>")
+	matchAst(t,
+		"xs[1:3]",
+		"BodyNode<[SliceNode<VarNode<xs>, ConstNode<0, 1, 0>, ConstNode<0, 3, 0>>]>")
+	matchAst(t,
+		"xs[:n]",
+		"BodyNode<[SliceNode<VarNode<xs>, <nil>, VarNode<n>>]>")
+	matchAst(t,
+		"xs[0] += 2",
+		"BodyNode<[SetOpNode<+=, IndexNode<VarNode<xs>, ConstNode<0, 0, 0>>, ConstNode<0, 2, 0>>]>")
+	if _, err := parseString("a + b = 2"); err == nil {
+		t.Errorf("no error assigning to an expression")
+	}
+}
+
+func TestParseMap(t *testing.T) {
+	matchAst(t,
+		`m = {"k": 1, 2: a}`,
+		`BodyNode<[SetOpNode<=, m, MapNode<[ConstNode<7, "k"> ConstNode<0, 2, 0>], [ConstNode<0, 1, 0> VarNode<a>]>>]>`)
+	matchAst(t,
+		"if (a) { {1: 2}[1]; }",
+		"BodyNode<[IfNode<VarNode<a>, BodyNode<[IndexNode<MapNode<[ConstNode<0, 1, 0>], [ConstNode<0, 2, 0>]>, ConstNode<0, 1, 0>>]>, nil>]>")
+	matchAst(t,
+		"for (k in m) { a = 1:30; }",
+		"BodyNode<[ForInNode<k, VarNode<m>, BodyNode<[SetOpNode<=, a, ConstNode<0, 90, 0>>]>>]>")
+}
+
+func TestParseReturn(t *testing.T) {
+	matchAst(t,
+		"func afn(a) { while (a) { break; continue; } return a; return; }",
+		"BodyNode<[FnDefNode<afn, [a], BodyNode<[WhileNode<VarNode<a>, BodyNode<[BreakNode<> ContinueNode<>]>> ReturnNode<VarNode<a>> ReturnNode<>]>>]>")
+
+	for _, pgm := range []string{"return 1", "break", "while (1) { func f() { break; } }"} {
+		if _, err := parseString(pgm); err == nil {
+			t.Errorf("no error parsing %q", pgm)
+		}
+	}
+}
+
+func TestParseDecl(t *testing.T) {
+	matchAst(t,
+		"func f() { local a = 1, b; global c, d; }",
+		"BodyNode<[FnDefNode<f, [], BodyNode<[LocalNode<[a b], [ConstNode<0, 1, 0> <nil>]> GlobalNode<[c d]>]>>]>")
+
+	for _, pgm := range []string{"local a", "global a", "func f() { local; }", "func f() { global a = 1; }"} {
+		if _, err := parseString(pgm); err == nil {
+			t.Errorf("no error parsing %q", pgm)
+		}
+	}
+}
+
+func TestParseConditional(t *testing.T) {
+	matchAst(t,
+		"a && b || c",
+		"BodyNode<[ShortCircuitNode<||, ShortCircuitNode<&&, VarNode<a>, VarNode<b>>, VarNode<c>>]>")
+	matchAst(t,
+		"a < b ? a : b",
+		"BodyNode<[CondNode<BinOpNode<lt, VarNode<a>, VarNode<b>>, VarNode<a>, VarNode<b>>]>")
+	matchAst(t,
+		"a ? b : c ? d : e",
+		"BodyNode<[CondNode<VarNode<a>, VarNode<b>, CondNode<VarNode<c>, VarNode<d>, VarNode<e>>>]>")
+	matchAst(t,
+		"a ? b ? c : d : e",
+		"BodyNode<[CondNode<VarNode<a>, CondNode<VarNode<b>, VarNode<c>, VarNode<d>>, VarNode<e>>]>")
+	if _, err := parseString("a ? b"); err == nil {
+		t.Errorf("no error parsing conditional without ':'")
+	}
+}
+
 func TestParseOk3(t *testing.T) {
 	matchAst(t,
 		"2**(1/2)",
@@ -145,7 +232,42 @@ func TestParseMulDiv(t *testing.T) {
 		"BodyNode<[BinOpNode<*, BinOpNode</, ConstNode<0, 11, 0>, ConstNode<0, 25, 0>>, ConstNode<0, 2, 0>>]>")
 	matchAst(t,
 		"2 * 11/25",
-		"BodyNode<[BinOpNode<*, ConstNode<0, 2, 0>, BinOpNode</, ConstNode<0, 11, 0>, ConstNode<0, 25, 0>>>]>")
+		"BodyNode<[BinOpNode</, BinOpNode<*, ConstNode<0, 2, 0>, ConstNode<0, 11, 0>>, ConstNode<0, 25, 0>>]>")
+}
+
+func TestParsePrecedence(t *testing.T) {
+	bin := func(op, a, b string) string {
+		return fmt.Sprintf("BinOpNode<%s, %s, %s>", op, a, b)
+	}
+	v := func(name string) string {
+		return fmt.Sprintf("VarNode<%s>", name)
+	}
+	c := func(pgm, exp string) {
+		t.Helper()
+		matchAst(t, pgm, "BodyNode<["+exp+"]>")
+	}
+
+	c("2**3**2", bin("**", "ConstNode<0, 2, 0>", bin("**", "ConstNode<0, 3, 0>", "ConstNode<0, 2, 0>")))
+	c("a*b/c", bin("/", bin("*", v("a"), v("b")), v("c")))
+	c("a/b*c", bin("*", bin("/", v("a"), v("b")), v("c")))
+	c("a%b*c", bin("*", bin("%", v("a"), v("b")), v("c")))
+	c("a-b-c", bin("-", bin("-", v("a"), v("b")), v("c")))
+	c("a+b*c", bin("+", v("a"), bin("*", v("b"), v("c"))))
+	c("a*b**c", bin("*", v("a"), bin("**", v("b"), v("c"))))
+	c("a**b*c", bin("*", bin("**", v("a"), v("b")), v("c")))
+	c("a < b == c > d", bin("==", bin("lt", v("a"), v("b")), bin("gt", v("c"), v("d"))))
+	c("a + b < c", bin("lt", bin("+", v("a"), v("b")), v("c")))
+	c("x & 1 == 0", bin("&", v("x"), bin("==", "ConstNode<0, 1, 0>", "ConstNode<0, 0, 0>")))
+	c("a | b & c", bin("|", v("a"), bin("&", v("b"), v("c"))))
+	c("a & b | c", bin("|", bin("&", v("a"), v("b")), v("c")))
+	c("a | b && c", "ShortCircuitNode<&&, "+bin("|", v("a"), v("b"))+", "+v("c")+">")
+	c("a || b && c", "ShortCircuitNode<||, "+v("a")+", ShortCircuitNode<&&, "+v("b")+", "+v("c")+">>")
+	c("a == b && c != d", "ShortCircuitNode<&&, "+bin("==", v("a"), v("b"))+", "+bin("!=", v("c"), v("d"))+">")
+	c("a << 1 + b", bin("<<", v("a"), bin("+", "ConstNode<0, 1, 0>", v("b"))))
+	c("a < b << c", bin("lt", v("a"), bin("<<", v("b"), v("c"))))
+	c("a | b ^ c & d", bin("|", v("a"), bin("^", v("b"), bin("&", v("c"), v("d")))))
+	c("a &^ b ^ c", bin("^", bin("&^", v("a"), v("b")), v("c")))
+	c("a || b ? c : d", "CondNode<ShortCircuitNode<||, "+v("a")+", "+v("b")+">, "+v("c")+", "+v("d")+">")
 }
 
 func TestAtSyntax(t *testing.T) {
@@ -177,3 +299,50 @@ func TestPrecision(t *testing.T) {
 	c(".23E5", 2)
 	c("", 0)
 }
+
+func TestParseConv(t *testing.T) {
+	matchAst(t,
+		"x -> km/h",
+		"BodyNode<[ConvNode<VarNode<x>, km/h>]>")
+	matchAst(t,
+		"y = x * 2 -> J/(kg*K)",
+		"BodyNode<[SetOpNode<=, y, ConvNode<BinOpNode<*, VarNode<x>, ConstNode<0, 2, 0>>, J/(kg*K)>>]>")
+	matchAst(t,
+		"x -> s**-1",
+		"BodyNode<[ConvNode<VarNode<x>, s**-1>]>")
+}
+
+func TestSource(t *testing.T) {
+	// the source form must parse back to the same tree
+	for _, pgm := range []string{
+		"x**3 + 2*x - y/(z - 1)",
+		"-x**2 + -(x**2) - (-3)",
+		"a = b ? c : d ? e : f",
+		"f(x, [1, 2], {\"k\": 1.5})[0][1:] && !g(x) || x >= 3",
+		"2**3**2 + (2**3)**2 + (a - b) - (c - d) + a*(b/c)",
+		"x -> km/h; 3 m/s * t",
+		"func f(x, y) { local z = 1; global w; if (x > 0) { return z; } else if (x < 0) { z += 1; } else { x++; } for (i in [1, 2]) { break; } while (y) { continue; } return 1/2; }",
+		"g = func(x) { return 1.5e3*x + 0x1f + 2i + 3.25d; }",
+	} {
+		n, err := parseString(pgm)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", pgm, err)
+		}
+		stmts := []string{}
+		for _, s := range n.(*BodyNode).statements {
+			src := stmtSource(s, "")
+			if !isBlockStatement(s) {
+				src += ";"
+			}
+			stmts = append(stmts, src)
+		}
+		src := strings.Join(stmts, "\n")
+		n2, err := parseString(src)
+		if err != nil {
+			t.Fatalf("Error parsing source %q of %q: %v", src, pgm, err)
+		}
+		if n.String() != n2.String() {
+			t.Fatalf("Source %q of %q parsed to a different tree:\n%s\n%s", src, pgm, n2, n)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+)
+
+// Random numbers. Every session (call stack) has its own generator, kept in the global frame, so that seed(n) or
+// the --seed option make a script reproducible without affecting other sessions. randbits uses the cryptographically
+// secure generator of the operating system instead, it can not be seeded.
+
+// Returns a new generator with a random seed
+func newRandomSource() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+// Sets the seed of the generator of the session, the lowest 64 bits of n are used. The generator is reseeded in place:
+// function calls append frames to copies of the stack, a new generator set in stack[0] would be lost on return.
+func seedRandom(stack []CallFrame, n *big.Int) {
+	m := new(big.Int).And(n, new(big.Int).SetUint64(math.MaxUint64))
+	stack[0].rng.Seed(int64(m.Uint64()))
+}
+
+// Returns a uniform random integer between 0 and n-1, n > 0
+func randomBigInt(rng *rand.Rand, n *big.Int) *big.Int {
+	if n.IsInt64() {
+		return big.NewInt(rng.Int63n(n.Int64()))
+	}
+	// random numbers with the bit length of n, retried until they are smaller than n (at least half of them are)
+	bits := n.BitLen()
+	buf := make([]byte, (bits+63)/64*8)
+	mask := new(big.Int).Sub(new(big.Int).Lsh(bigOne, uint(bits)), bigOne)
+	for {
+		for i := 0; i < len(buf); i += 8 {
+			binary.LittleEndian.PutUint64(buf[i:], rng.Uint64())
+		}
+		r := new(big.Int).SetBytes(buf)
+		if r.And(r, mask).Cmp(n) < 0 {
+			return r
+		}
+	}
+}
+
+// Returns the list argument of name, which must not be empty
+func nonEmptyListArg(name string, vv *value, lineno int) []*value {
+	elems := listArg(name, vv, lineno)
+	if len(elems) == 0 {
+		panic(fmt.Errorf("Can not apply %s to an empty list at line %d", name, lineno))
+	}
+	return elems
+}
+
+// Uniform random number between 0 (included) and 1 (excluded): a floating point number, an exact rational with 53
+// random bits in rational mode or a big float with the selected precision in big float mode
+var btnRand = makeStackFuncValue(0, 0, func(stack []CallFrame, argv []*value, lineno int) *value {
+	rng := stack[0].rng
+	switch CommaMode {
+	case rationalComma:
+		var r big.Rat
+		r.SetFrac(big.NewInt(rng.Int63n(1<<53)), new(big.Int).Lsh(bigOne, 53))
+		return newRatval(r, 12)
+	case bigfloatComma:
+		m := randomBigInt(rng, new(big.Int).Lsh(bigOne, floatPrec))
+		x := newBigFloat(floatPrec).SetInt(m)
+		return newBigFloatval(x.SetMantExp(x, -int(floatPrec)))
+	}
+	return newFloatval(rng.Float64(), DECFLV)
+})
+
+// Uniform random integer between a and b (both included)
+var btnRandint = makeStackFuncValue(2, 2, func(stack []CallFrame, argv []*value, lineno int) *value {
+	x := intArgs("randint", argv, lineno)
+	n := new(big.Int).Sub(x[1], x[0])
+	if n.Sign() < 0 {
+		panic(fmt.Errorf("Randint needs a <= b, not %s > %s at line %d", x[0], x[1], lineno))
+	}
+	r := randomBigInt(stack[0].rng, n.Add(n, bigOne))
+	return newBigIntval(r.Add(r, x[0]))
+})
+
+// Normally distributed random number with mean mu and standard deviation sigma (0 and 1 if omitted)
+var btnRandn = makeStackFuncValue(0, 2, func(stack []CallFrame, argv []*value, lineno int) *value {
+	args := distArgs(argv, lineno)
+	mu, sigma := normalParams("randn", args, lineno)
+	y := mu + sigma*stack[0].rng.NormFloat64()
+	if CommaMode == rationalComma {
+		var r big.Rat
+		r.SetFloat64(y)
+		return newRatval(r, 12)
+	}
+	return floatResult(y, lineno)
+})
+
+// New list with the elements of xs in random order
+var btnShuffle = makeStackFuncValue(1, 1, func(stack []CallFrame, argv []*value, lineno int) *value {
+	elems := append([]*value{}, listArg("shuffle", argv[0], lineno)...)
+	stack[0].rng.Shuffle(len(elems), func(i, j int) { elems[i], elems[j] = elems[j], elems[i] })
+	return newListval(elems)
+})
+
+// Random element of xs
+var btnChoice = makeStackFuncValue(1, 1, func(stack []CallFrame, argv []*value, lineno int) *value {
+	elems := nonEmptyListArg("choice", argv[0], lineno)
+	return elems[stack[0].rng.Intn(len(elems))]
+})
+
+var btnSeed = makeStackFuncValue(1, 1, func(stack []CallFrame, argv []*value, lineno int) *value {
+	seedRandom(stack, intArgs("seed", argv, lineno)[0])
+	return newZeroVal(IVAL, DECFLV, 0)
+})
+
+// Random integer of n bits (between 0 and 2**n-1) from the cryptographically secure generator
+var btnRandbits = makeFuncValue(1, func(argv []*value, lineno int) *value {
+	n := smallIntArg("randbits", argv[0], lineno)
+	if n > 1<<24 {
+		panic(fmt.Errorf("Can not apply randbits at line %d: %d bits are too many", lineno, n))
+	}
+	if n == 0 {
+		return newZeroVal(IVAL, DECFLV, 0)
+	}
+	r, err := crand.Int(crand.Reader, new(big.Int).Lsh(bigOne, uint(n)))
+	if err != nil {
+		panic(fmt.Errorf("Can not apply randbits at line %d: %v", lineno, err))
+	}
+	return newBigIntval(r)
+})
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Source form of the AST, the String methods of the nodes print their structure for debugging, these functions print
+// them as cala code that parses back to the same tree. Used to show functions (dpy and source) and the results of diff.
+
+// Priority of expressions that never need parentheses: variables, constants, calls, …
+const atomPriority = powPriority + 1
+
+// Returns the source form of the function fn
+func fnSource(fn *FnDefNode) string {
+	if fn.name == "" {
+		return exprSource(fn)
+	}
+	return stmtSource(fn, "")
+}
+
+// Returns the source form of a block of statements, the lines of the statements are indented by indent plus a tab
+func blockSource(body AstNode, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	statements := []AstNode{body}
+	if n, ok := body.(*BodyNode); ok {
+		statements = n.statements
+	}
+	for _, n := range statements {
+		b.WriteString(indent + "\t" + stmtSource(n, indent+"\t"))
+		if !isBlockStatement(n) {
+			b.WriteString(";")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// Returns true for the statements ending with a block, they are not followed by a semicolon
+func isBlockStatement(n AstNode) bool {
+	switch n := n.(type) {
+	case *IfNode, *WhileNode, *ForNode, *ForInNode:
+		return true
+	case *FnDefNode:
+		return n.name != ""
+	}
+	return false
+}
+
+// Returns the source form of the statement n, indent is the indentation of the line where it starts
+func stmtSource(n AstNode, indent string) string {
+	switch n := n.(type) {
+	case *IfNode:
+		s := "if (" + exprSource(n.guard) + ") " + blockSource(n.ifBody, indent)
+		switch e := n.elseBody.(type) {
+		case nil:
+		case *IfNode:
+			s += " else " + stmtSource(e, indent)
+		default:
+			s += " else " + blockSource(e, indent)
+		}
+		return s
+	case *WhileNode:
+		return "while (" + exprSource(n.guard) + ") " + blockSource(n.body, indent)
+	case *ForNode:
+		return "for (" + exprSource(n.initExpr) + "; " + exprSource(n.guard) + "; " + exprSource(n.incrExpr) + ") " + blockSource(n.body, indent)
+	case *ForInNode:
+		return "for (" + n.varName + " in " + exprSource(n.expr) + ") " + blockSource(n.body, indent)
+	case *FnDefNode:
+		return "func " + n.name + "(" + strings.Join(n.args, ", ") + ") " + blockSource(n.body, indent)
+	case *ReturnNode:
+		if n.expr == nil {
+			return "return"
+		}
+		return "return " + exprSource(n.expr)
+	case *LocalNode:
+		decls := make([]string, len(n.names))
+		for i, name := range n.names {
+			decls[i] = name
+			if n.inits[i] != nil {
+				decls[i] += " = " + exprSource(n.inits[i])
+			}
+		}
+		return "local " + strings.Join(decls, ", ")
+	case *GlobalNode:
+		return "global " + strings.Join(n.names, ", ")
+	case *BreakNode:
+		return "break"
+	case *ContinueNode:
+		return "continue"
+	case *ExitNode:
+		return "exit"
+	case *DpyNode:
+		return dpySource(n)
+	}
+	return exprSource(n)
+}
+
+// Returns the source form of a display statement
+func dpySource(n *DpyNode) string {
+	switch {
+	case n.toggleProg:
+		return "@:p"
+	case n.changeComma:
+		switch n.commaMode {
+		case floatComma:
+			return "@:f"
+		case rationalComma:
+			return "@:r"
+		}
+		return fmt.Sprintf("@:b %d", n.floatPrec)
+	case n.changeStrict:
+		if n.strict {
+			return "@:strict"
+		}
+		return "@:nostrict"
+	case n.changeIntMode:
+		return "@:" + n.intMode.String()
+	case n.changeOverflow:
+		if n.overflow {
+			return "@:overflow"
+		}
+		return "@:wrap"
+	case n.changeBits:
+		return fmt.Sprintf("@:bits %d", n.bits)
+	case n.changeScale:
+		return fmt.Sprintf("@:scale %d", n.scale)
+	case n.changeRounding:
+		return "@:round " + n.rounding.String()
+	case n.changeModulus:
+		if n.modulus == nil {
+			return "@:mod 0"
+		}
+		return "@:mod " + n.modulus.String()
+	case n.defineUnit:
+		si := siUnit(n.unit.dim)
+		return "@:unit " + n.unitName + " = " + ratSource(n.unit.factor) + " " + si.String()
+	}
+	return "@ " + exprSource(n.expr)
+}
+
+// Returns the priority of the expression n, operands with a lower priority than their operator need parentheses
+func exprPriority(n AstNode) int {
+	switch n := n.(type) {
+	case *BinOpNode:
+		return n.priority
+	case *ShortCircuitNode:
+		return TokenTypes[n.name].Priority
+	case *CondNode:
+		return condPriority
+	case *ConvNode, *SetOpNode, *FnDefNode:
+		return -1
+	case *ConstNode:
+		if n.v.kind == QVAL {
+			// the unit would continue after a * or /
+			return addPriority
+		}
+	}
+	return atomPriority
+}
+
+// Returns true if the source of n starts with a minus sign
+func isNegative(n AstNode) bool {
+	switch n := n.(type) {
+	case *UniOpNode:
+		return n.name == "-"
+	case *ConstNode:
+		return strings.HasPrefix(constSource(&n.v), "-")
+	}
+	return false
+}
+
+// Returns the symbol of the operator called name, comparison operators have a printable name different from their symbol
+func opSymbol(name string) string {
+	if tt, ok := TokenTypes[name]; ok && tt.Name == name {
+		return name
+	}
+	for _, tt := range TokenTypes {
+		if tt.Name == name {
+			return tt.XName
+		}
+	}
+	return name
+}
+
+// Returns the source form of the binary operation op1 name op2 with the given priority
+func binarySource(name string, op1, op2 AstNode, priority int) string {
+	rightAssoc := TokenTypes[opSymbol(name)].RightAssoc
+	left := exprSource(op1)
+	if p := exprPriority(op1); p < priority || (p == priority && rightAssoc) || (name == "**" && isNegative(op1)) {
+		// -x**2 is (-x)**2, the parentheses make it clear
+		left = "(" + left + ")"
+	}
+	right := exprSource(op2)
+	if p := exprPriority(op2); p < priority || (p == priority && !rightAssoc) || isNegative(op2) {
+		right = "(" + right + ")"
+	}
+	switch name {
+	case "*", "/", "%", "**":
+		return left + name + right
+	}
+	return left + " " + opSymbol(name) + " " + right
+}
+
+// Returns the source form of the expression n
+func exprSource(n AstNode) string {
+	switch n := n.(type) {
+	case *ConstNode:
+		return constSource(&n.v)
+	case *VarNode:
+		return n.name
+	case *BinOpNode:
+		return binarySource(n.name, n.op1, n.op2, n.priority)
+	case *ShortCircuitNode:
+		return binarySource(n.name, n.op1, n.op2, TokenTypes[n.name].Priority)
+	case *UniOpNode:
+		if n.name == "++" || n.name == "--" {
+			return exprSource(n.child) + n.name
+		}
+		s := exprSource(n.child)
+		if exprPriority(n.child) < atomPriority || isNegative(n.child) {
+			s = "(" + s + ")"
+		}
+		return n.name + s
+	case *CondNode:
+		cond := exprSource(n.cond)
+		if exprPriority(n.cond) <= condPriority {
+			cond = "(" + cond + ")"
+		}
+		ifFalse := exprSource(n.ifFalse)
+		if exprPriority(n.ifFalse) < condPriority {
+			ifFalse = "(" + ifFalse + ")"
+		}
+		return cond + " ? " + exprSource(n.ifTrue) + " : " + ifFalse
+	case *ConvNode:
+		return exprSource(n.expr) + " -> " + n.u.String()
+	case *SetOpNode:
+		target := n.varName
+		if n.target != nil {
+			target = exprSource(n.target)
+		}
+		value := exprSource(n.op1)
+		if _, ok := n.op1.(*SetOpNode); ok {
+			value = "(" + value + ")"
+		}
+		return target + " " + n.name + " " + value
+	case *FnCallNode:
+		callee := n.name
+		if callee == "" {
+			callee = exprSource(n.callee)
+			if exprPriority(n.callee) < atomPriority {
+				callee = "(" + callee + ")"
+			}
+		}
+		return callee + "(" + listSource(n.args) + ")"
+	case *ListNode:
+		return "[" + listSource(n.elems) + "]"
+	case *MapNode:
+		entries := make([]string, len(n.keys))
+		for i := range n.keys {
+			entries[i] = exprSource(n.keys[i]) + ": " + exprSource(n.vals[i])
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case *IndexNode:
+		expr := exprSource(n.expr)
+		if exprPriority(n.expr) < atomPriority {
+			expr = "(" + expr + ")"
+		}
+		if !n.slice {
+			return expr + "[" + exprSource(n.index) + "]"
+		}
+		lo, hi := "", ""
+		if n.index != nil {
+			lo = exprSource(n.index)
+		}
+		if n.hi != nil {
+			hi = exprSource(n.hi)
+		}
+		return expr + "[" + lo + ":" + hi + "]"
+	case *FnDefNode:
+		return "func(" + strings.Join(n.args, ", ") + ") " + blockSource(n.body, "")
+	}
+	return n.String()
+}
+
+func listSource(elems []AstNode) string {
+	r := make([]string, len(elems))
+	for i, e := range elems {
+		r[i] = exprSource(e)
+	}
+	return strings.Join(r, ", ")
+}
+
+// Returns the source form of a rational number, as a decimal number when it has a finite decimal representation
+func ratSource(x *big.Rat) string {
+	if x.IsInt() {
+		return x.Num().String()
+	}
+	// the denominator divides 10**digits if it only has the factors 2 and 5
+	d := new(big.Int).Set(x.Denom())
+	digits := 0
+	for _, f := range []int64{2, 5} {
+		n := 0
+		for new(big.Int).Mod(d, big.NewInt(f)).Sign() == 0 {
+			d.Quo(d, big.NewInt(f))
+			n++
+		}
+		digits = max(digits, n)
+	}
+	if d.Cmp(big.NewInt(1)) == 0 {
+		return x.FloatString(digits)
+	}
+	return "(" + x.String() + ")"
+}
+
+// Returns the source form of a constant
+func constSource(v *value) string {
+	switch v.kind {
+	case IVAL:
+		switch v.flavor {
+		case HEXFLV:
+			return fmt.Sprintf("%#x", &v.ival)
+		case OCTFLV:
+			return fmt.Sprintf("%#o", &v.ival)
+		case TIMEFLV:
+			return v.format(false)
+		}
+		return v.ival.String()
+	case DVAL:
+		s := strconv.FormatFloat(v.dval, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			// keeps it a real number when parsed back
+			s += ".0"
+		}
+		return s
+	case RVAL:
+		return ratSource(&v.rval)
+	case DECVAL:
+		return decimalString(&v.ival, v.prec) + "d"
+	case FVAL:
+		return v.fval.Text('g', bigFloatDigits(v.fval))
+	case CVAL:
+		im := strconv.FormatFloat(imag(v.cval), 'g', -1, 64) + "i"
+		if real(v.cval) == 0 {
+			return im
+		}
+		if !strings.HasPrefix(im, "-") {
+			im = "+" + im
+		}
+		return "(" + strconv.FormatFloat(real(v.cval), 'g', -1, 64) + im + ")"
+	case QVAL:
+		return constSource(v.qty.x) + " " + v.qty.u.String()
+	}
+	return v.String()
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Special functions: gamma, beta, error and Bessel functions. Floating point numbers use the math package, big float
+// versions follow the conventions of bigfloat.go and return nil outside of the domain of the function.
+
+// Largest argument of gamma and beta computed exactly with factorials in rational mode
+const maxExactGamma = 10000
+
+// Position of the minimum of gamma on the positive axis
+const gammaMin = 1.4616321449683623
+
+// Bernoulli numbers B0, B1, B2…, computed when needed
+var bernoulliCache = []*big.Rat{big.NewRat(1, 1), big.NewRat(-1, 2)}
+
+// Returns the Bernoulli number Bn, using the recurrence sum(binomial(m+1, k)*Bk, k = 0…m) = 0
+func bernoulli(n int) *big.Rat {
+	for m := len(bernoulliCache); m <= n; m++ {
+		b := new(big.Rat)
+		if m%2 == 0 {
+			var c big.Int
+			for k := 0; k < m; k++ {
+				if k > 1 && k%2 == 1 {
+					continue
+				}
+				c.Binomial(int64(m+1), int64(k))
+				b.Add(b, new(big.Rat).Mul(new(big.Rat).SetInt(&c), bernoulliCache[k]))
+			}
+			b.Quo(b, big.NewRat(int64(-m-1), 1))
+		}
+		bernoulliCache = append(bernoulliCache, b)
+	}
+	return bernoulliCache[n]
+}
+
+// Digamma function, the derivative of lgamma
+func digamma(x float64) float64 {
+	if x <= 0 && x == math.Floor(x) {
+		return math.NaN()
+	}
+	if x < 0 {
+		// reflection formula
+		return digamma(1-x) - math.Pi/math.Tan(math.Pi*x)
+	}
+	r := 0.0
+	for x < 10 {
+		r -= 1 / x
+		x++
+	}
+	x2 := 1 / (x * x)
+	return r + math.Log(x) - 0.5/x - x2*(1.0/12-x2*(1.0/120-x2*(1.0/252-x2*(1.0/240-x2/132))))
+}
+
+// Returns n if x is a positive integer not larger than max
+func smallPositiveInt(x *big.Rat, max int64) (int64, bool) {
+	if !x.IsInt() || x.Sign() <= 0 || x.Num().Cmp(big.NewInt(max)) > 0 {
+		return 0, false
+	}
+	return x.Num().Int64(), true
+}
+
+// Returns true if x is an integer smaller or equal to 0, a pole of gamma
+func isGammaPole(x *big.Float) bool {
+	return x.Sign() <= 0 && x.IsInt()
+}
+
+// ln(gamma(x)) for x > 0 with Stirling's series. Small arguments are first shifted to z = x + n with the recurrence
+// gamma(x) = gamma(x + n)/(x*(x + 1)*…*(x + n - 1)), so that the series converges quickly enough
+func bigLgammaPos(x *big.Float, wp uint) *big.Float {
+	z := newBigFloat(wp).Set(x)
+	prod := newBigFloat(wp).SetInt64(1)
+	shift := false
+	if f, _ := x.Float64(); f < float64(wp)/2 {
+		for n := int(math.Ceil(float64(wp)/2 - f)); n > 0; n-- {
+			prod.Mul(prod, z)
+			z.Add(z, big.NewFloat(1))
+		}
+		shift = true
+	}
+
+	// (z - 1/2)*ln(z) - z + ln(2*pi)/2
+	lnz := bigLog(z, wp)
+	r := newBigFloat(wp).Sub(z, big.NewFloat(0.5))
+	r.Mul(r, lnz)
+	r.Sub(r, z)
+	twoPi := newBigFloat(wp).Mul(bigPi(wp), big.NewFloat(2))
+	r.Add(r, newBigFloat(wp).Quo(bigLog(twoPi, wp), big.NewFloat(2)))
+
+	// sum(B2k/(2k*(2k - 1)*z**(2k - 1)), k = 1…)
+	pow := newBigFloat(wp).Quo(big.NewFloat(1), z)
+	zinv2 := newBigFloat(wp).Mul(pow, pow)
+	for k := 1; k < 4*int(wp); k++ {
+		term := newBigFloat(wp).SetRat(bernoulli(2 * k))
+		term.Mul(term, pow)
+		term.Quo(term, big.NewFloat(float64(2*k*(2*k-1))))
+		if negligible(term, r, wp) {
+			break
+		}
+		r.Add(r, term)
+		pow.Mul(pow, zinv2)
+	}
+
+	if shift {
+		r.Sub(r, bigLog(prod, wp))
+	}
+	return r
+}
+
+// Returns sin(pi*x), reducing x exactly to [-1/2, 1/2] first
+func bigSinPi(x *big.Float, wp uint) *big.Float {
+	n := bigRound(x, big.ToNearestEven, 0)
+	f := newBigFloat(wp+x.Prec()).Sub(x, newBigFloat(0).SetInt(n))
+	s := bigSin(f.Mul(f, bigPi(wp)), wp)
+	if n.Bit(0) == 1 {
+		s.Neg(s)
+	}
+	return s
+}
+
+// ln(abs(gamma(x))) computed with the working precision wp, for x > 0 or with the reflection formula
+// gamma(x)*gamma(1 - x) = pi/sin(pi*x) for x < 0
+func bigLgammaAt(x *big.Float, wp uint) *big.Float {
+	if x.Sign() > 0 {
+		return bigLgammaPos(x, wp)
+	}
+	s := bigSinPi(x, wp)
+	r := bigLog(bigPi(wp), wp)
+	r.Sub(r, bigLog(s.Abs(s), wp))
+	return r.Sub(r, bigLgammaPos(newBigFloat(wp).Sub(big.NewFloat(1), x), wp))
+}
+
+// ln(abs(gamma(x))), +Inf at the poles
+func bigLgamma(x *big.Float, prec uint) *big.Float {
+	if x.IsInf() || isGammaPole(x) {
+		return newBigFloat(prec).SetInf(false)
+	}
+	if x.Cmp(big.NewFloat(1)) == 0 || x.Cmp(big.NewFloat(2)) == 0 {
+		return newBigFloat(prec)
+	}
+	// the series has a small absolute error, near the zeros of the result more bits are needed
+	wp := prec + guardBits
+	r := bigLgammaAt(x, wp)
+	for i := 0; i < 4; i++ {
+		e := r.MantExp(nil)
+		if r.Sign() != 0 && e >= 0 {
+			break
+		}
+		need := prec + guardBits + 2*wp
+		if r.Sign() != 0 {
+			need = prec + guardBits + uint(-e) + 16
+		}
+		if need <= wp {
+			break
+		}
+		wp = need
+		r = bigLgammaAt(x, wp)
+	}
+	return newBigFloat(prec).Set(r)
+}
+
+// Gamma function, exp(lgamma(x)) with the sign given by the reflection formula for negative x, nil at the poles
+// except 0 which gives +Inf like math.Gamma
+func bigGamma(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBigFloat(prec).SetInf(false)
+	}
+	if isGammaPole(x) {
+		return nil
+	}
+	if x.IsInf() {
+		return newBigFloat(prec).SetInf(false)
+	}
+	if x.IsInt() {
+		if n, acc := x.Int64(); acc == big.Exact && n <= maxExactGamma {
+			return newBigFloat(prec).SetInt(new(big.Int).MulRange(1, n-1))
+		}
+	}
+	// the absolute error of lgamma becomes the relative error of the result, it grows like x*ln(x)
+	wp := prec + guardBits
+	if e := x.MantExp(nil); e > 0 {
+		wp += 2 * uint(e)
+	}
+	r := bigExp(bigLgammaAt(x, wp), wp)
+	if x.Sign() < 0 && bigSinPi(x, wp).Sign() < 0 {
+		r.Neg(r)
+	}
+	return newBigFloat(prec).Set(r)
+}
+
+// Beta function gamma(a)*gamma(b)/gamma(a + b), 0 when a + b is a pole of gamma
+func bigBeta(a, b *big.Float, prec uint) *big.Float {
+	if isGammaPole(a) || isGammaPole(b) {
+		return nil
+	}
+	wp := prec + guardBits
+	s := newBigFloat(wp+a.Prec()+b.Prec()).Add(a, b)
+	if isGammaPole(s) {
+		return newBigFloat(prec)
+	}
+	ga, gb, gs := bigGamma(a, wp), bigGamma(b, wp), bigGamma(s, wp)
+	r := newBigFloat(wp).Mul(ga, gb)
+	return newBigFloat(prec).Quo(r, gs)
+}
+
+// Float beta function, with lgamma when the gamma functions overflow
+func beta(a, b float64) float64 {
+	if a <= 0 && a == math.Floor(a) || b <= 0 && b == math.Floor(b) {
+		return math.NaN()
+	}
+	if s := a + b; s <= 0 && s == math.Floor(s) {
+		return 0
+	}
+	r := math.Gamma(a) * math.Gamma(b) / math.Gamma(a+b)
+	if !math.IsInf(r, 0) && !math.IsNaN(r) && r != 0 {
+		return r
+	}
+	la, sa := math.Lgamma(a)
+	lb, sb := math.Lgamma(b)
+	ls, ss := math.Lgamma(a + b)
+	return float64(sa*sb*ss) * math.Exp(la+lb-ls)
+}
+
+// Exact beta function of positive integers: (a - 1)!*(b - 1)!/(a + b - 1)!
+func exactBeta(a, b *big.Rat) *big.Rat {
+	m, ok1 := smallPositiveInt(a, maxExactGamma)
+	n, ok2 := smallPositiveInt(b, maxExactGamma)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	num := new(big.Int).Mul(new(big.Int).MulRange(1, m-1), new(big.Int).MulRange(1, n-1))
+	return new(big.Rat).SetFrac(num, new(big.Int).MulRange(1, m+n-1))
+}
+
+// Exact gamma function of positive integers: (n - 1)!
+func exactGamma(x *big.Rat) *big.Rat {
+	n, ok := smallPositiveInt(x, maxExactGamma)
+	if !ok {
+		return nil
+	}
+	return new(big.Rat).SetInt(new(big.Int).MulRange(1, n-1))
+}
+
+// lgamma(1) = lgamma(2) = 0 are the only rational values
+func exactLgamma(x *big.Rat) *big.Rat {
+	if x.Cmp(big.NewRat(1, 1)) == 0 || x.Cmp(big.NewRat(2, 1)) == 0 {
+		return new(big.Rat)
+	}
+	return nil
+}
+
+// Makes the interval extension of fn, decreasing on [dlo, xmin] and increasing on [xmin, dhi]
+func ivUnimodal(fn func(float64) float64, xmin, dlo, dhi float64) func(interval, int) interval {
+	dec := ivDecreasing(fn, dlo, xmin)
+	inc := ivIncreasing(fn, xmin, dhi)
+	return func(x interval, lineno int) interval {
+		if x.lo < dlo || x.hi > dhi {
+			panic(fmt.Errorf("Interval %s is not contained in the domain of the function [%g, %g] at line %d", x, dlo, dhi, lineno))
+		}
+		switch {
+		case x.hi <= xmin:
+			return dec(x, lineno)
+		case x.lo >= xmin:
+			return inc(x, lineno)
+		}
+		l := dec(interval{xmin, xmin}, lineno)
+		return interval{l.lo, math.Max(dec(interval{x.lo, x.lo}, lineno).hi, inc(interval{x.hi, x.hi}, lineno).hi)}
+	}
+}
+
+func lgamma(x float64) float64 {
+	r, _ := math.Lgamma(x)
+	return r
+}
+
+// 2/sqrt(pi)*exp(-x**2)*sum((2*x**2)**n*x/(1*3*…*(2n + 1)), n = 0…), the terms are positive
+func bigErfSeries(x *big.Float, wp uint) *big.Float {
+	x2 := newBigFloat(wp).Mul(x, x)
+	a := newBigFloat(wp).Mul(x2, big.NewFloat(2))
+	term := newBigFloat(wp).Set(x)
+	sum := newBigFloat(wp).Set(x)
+	xf, _ := x2.Float64()
+	for n := 1; ; n++ {
+		term.Mul(term, a)
+		term.Quo(term, big.NewFloat(float64(2*n+1)))
+		// the terms grow until n is about x**2
+		if float64(n) > xf && negligible(term, sum, wp) {
+			break
+		}
+		sum.Add(sum, term)
+	}
+	sum.Mul(sum, bigExp(x2.Neg(x2), wp))
+	sum.Mul(sum, big.NewFloat(2))
+	return sum.Quo(sum, bigSqrt(bigPi(wp), wp))
+}
+
+// Returns true if erfc(abs(x)) is smaller than 2**-wp, which makes the asymptotic series accurate and erf(x) = ±1
+func erfcNegligible(x *big.Float, wp uint) bool {
+	x2, _ := newBigFloat(53).Mul(x, x).Float64()
+	return x2*math.Log2E > float64(wp)
+}
+
+func bigErf(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBigFloat(prec)
+	}
+	wp := prec + guardBits
+	if x.IsInf() || erfcNegligible(x, wp) {
+		return newBigFloat(prec).SetInt64(int64(x.Sign()))
+	}
+	return newBigFloat(prec).Set(bigErfSeries(x, wp))
+}
+
+// erfc(x) = 1 - erf(x), computed with enough extra bits to compensate for the cancellation, or for large x with the
+// asymptotic series exp(-x**2)/(x*sqrt(pi))*sum((-1)**n*(1*3*…*(2n - 1))/(2*x**2)**n, n = 0…)
+func bigErfc(x *big.Float, prec uint) *big.Float {
+	if x.IsInf() {
+		return newBigFloat(prec).SetInt64(int64(1 - x.Sign()))
+	}
+	wp := prec + guardBits
+	if x.Sign() < 0 && erfcNegligible(x, wp) {
+		return newBigFloat(prec).SetInt64(2)
+	}
+	if x.Sign() <= 0 || !erfcNegligible(x, wp) {
+		if x.Sign() > 0 {
+			x2, _ := newBigFloat(53).Mul(x, x).Float64()
+			wp += uint(x2*math.Log2E) + 1
+		}
+		r := newBigFloat(wp).Sub(big.NewFloat(1), bigErfSeries(x, wp))
+		return newBigFloat(prec).Set(r)
+	}
+	x2 := newBigFloat(wp).Mul(x, x)
+	a := newBigFloat(wp).Mul(x2, big.NewFloat(-2))
+	term := newBigFloat(wp).SetInt64(1)
+	sum := newBigFloat(wp).SetInt64(1)
+	for n := 1; ; n++ {
+		next := newBigFloat(wp).Mul(term, big.NewFloat(float64(2*n-1)))
+		next.Quo(next, a)
+		if negligible(next, sum, wp) || new(big.Float).Abs(next).Cmp(new(big.Float).Abs(term)) >= 0 {
+			break
+		}
+		term = next
+		sum.Add(sum, term)
+	}
+	sum.Mul(sum, bigExp(x2.Neg(x2), wp))
+	sum.Quo(sum, x)
+	return newBigFloat(prec).Quo(sum, bigSqrt(bigPi(wp), wp))
+}
+
+// Inverse error function with Newton's method, starting from the floating point result. Arguments close to 1
+// solve erfc(y) = 1 - x instead to avoid the cancellation in erf(y) - x
+func bigErfinv(x *big.Float, prec uint) *big.Float {
+	ax := new(big.Float).Abs(x)
+	switch ax.Cmp(big.NewFloat(1)) {
+	case 1:
+		return nil
+	case 0:
+		return newBigFloat(prec).SetInf(x.Sign() < 0)
+	}
+	if x.Sign() == 0 {
+		return newBigFloat(prec)
+	}
+	wp := prec + guardBits
+	half := ax.Cmp(big.NewFloat(0.5)) <= 0
+	t := newBigFloat(wp+x.Prec()).Sub(big.NewFloat(1), ax)
+	var y *big.Float
+	if half {
+		f, _ := ax.Float64()
+		y = newBigFloat(wp).SetFloat64(math.Erfinv(f))
+	} else if f, _ := t.Float64(); f > 0 && !math.IsInf(math.Erfcinv(f), 0) {
+		y = newBigFloat(wp).SetFloat64(math.Erfcinv(f))
+	} else {
+		// exp(-y**2)/(y*sqrt(pi)) = t, y**2 is about -ln(t) - ln(sqrt(-pi*ln(t)))
+		m := new(big.Float)
+		e := t.MantExp(m)
+		mf, _ := m.Float64()
+		l := -(math.Log(mf) + float64(e)*math.Ln2)
+		y = newBigFloat(wp).SetFloat64(math.Sqrt(l - 0.5*math.Log(math.Pi*l)))
+	}
+	c := newBigFloat(wp).Quo(bigSqrt(bigPi(wp), wp), big.NewFloat(2))
+	for i := 0; i < 100; i++ {
+		// y = y - (erf(y) - x)/erf'(y) with erf'(y) = 2/sqrt(pi)*exp(-y**2), or the same with erfc
+		var d *big.Float
+		if half {
+			d = newBigFloat(wp).Sub(bigErf(y, wp), ax)
+		} else {
+			d = newBigFloat(wp).Sub(t, bigErfc(y, wp))
+		}
+		y2 := newBigFloat(wp).Mul(y, y)
+		d.Mul(d, bigExp(y2, wp))
+		d.Mul(d, c)
+		y.Sub(y, d)
+		if negligible(d, y, wp-guardBits/2) {
+			break
+		}
+	}
+	if x.Sign() < 0 {
+		y.Neg(y)
+	}
+	return newBigFloat(prec).Set(y)
+}
+
+func erfinvDeriv(x float64) float64 {
+	y := math.Erfinv(x)
+	return math.Sqrt(math.Pi) / 2 * math.Exp(y*y)
+}
+
+// Returns x**n for n >= 0
+func bigPowInt(x *big.Float, n int, prec uint) *big.Float {
+	r := newBigFloat(prec).SetInt64(1)
+	p := newBigFloat(prec).Set(x)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			r.Mul(r, p)
+		}
+		p.Mul(p, p)
+	}
+	return r
+}
+
+// Largest order and argument of the Bessel functions computed with big floats, the results of larger ones have the
+// precision of floating point numbers
+const maxBigBessel = 10000
+
+// Bessel function of the first kind with the series sum((-1)**k*(x/2)**(2k + n)/(k!*(k + n)!), k = 0…). The terms
+// grow to about exp(abs(x)) before they cancel, that many more bits are used
+func bigBesselJ(n int, x *big.Float, prec uint) *big.Float {
+	xf, _ := x.Float64()
+	if n > maxBigBessel || n < -maxBigBessel || math.Abs(xf) > maxBigBessel {
+		return newBigFloat(53).SetFloat64(math.Jn(n, xf))
+	}
+	// J(-n, x) = (-1)**n*J(n, x) and J(n, -x) = (-1)**n*J(n, x)
+	neg := false
+	if n < 0 {
+		n = -n
+		neg = n%2 == 1
+	}
+	if x.Sign() < 0 {
+		neg = neg != (n%2 == 1)
+	}
+	if x.Sign() == 0 {
+		if n == 0 {
+			return newBigFloat(prec).SetInt64(1)
+		}
+		return newBigFloat(prec)
+	}
+	wp := prec + guardBits + uint(math.Abs(xf)*math.Log2E)
+	h := newBigFloat(wp).Abs(x)
+	h.Quo(h, big.NewFloat(2))
+	h2 := newBigFloat(wp).Mul(h, h)
+	h2.Neg(h2)
+	term := bigPowInt(h, n, wp)
+	term.Quo(term, newBigFloat(wp).SetInt(new(big.Int).MulRange(1, int64(n))))
+	sum := newBigFloat(wp).Set(term)
+	hf := math.Abs(xf) / 2
+	for k := 1; ; k++ {
+		term.Mul(term, h2)
+		term.Quo(term, big.NewFloat(float64(k*(k+n))))
+		if float64(k) > hf && negligible(term, sum, wp) {
+			break
+		}
+		sum.Add(sum, term)
+	}
+	if neg {
+		sum.Neg(sum)
+	}
+	return newBigFloat(prec).Set(sum)
+}
+
+// Returns the order of a Bessel function, which must be an integer
+func besselOrder(name string, vv *value, lineno int) int {
+	n := intArgs(name, []*value{vv}, lineno)[0]
+	if !n.IsInt64() || n.Int64() > math.MaxInt32 || n.Int64() < math.MinInt32 {
+		panic(fmt.Errorf("Can not apply %s at line %d: order %s is too large", name, lineno, n))
+	}
+	return int(n.Int64())
+}
+
+// Derivative of the Bessel function fn of order n: (fn(n - 1, x) - fn(n + 1, x))/2
+func besselDeriv(fn func(int, float64) float64) func(n, x float64) (float64, float64) {
+	return func(n, x float64) (float64, float64) {
+		return 0, (fn(int(n)-1, x) - fn(int(n)+1, x)) / 2
+	}
+}
+
+// Makes besselj or bessely from the function of two real arguments fnv, checking that the order is an integer
+func makeBesselFuncValue(name string, fnv *value) *value {
+	return makeFuncValue(2, func(argv []*value, lineno int) *value {
+		besselOrder(name, argv[0], lineno)
+		return fnv.bval.fn(argv, lineno)
+	})
+}
+
+var btnGamma = makeRealFuncValue("gamma", math.Gamma, func(x float64) float64 { return math.Gamma(x) * digamma(x) }, bigGamma, exactGamma, ivUnimodal(math.Gamma, gammaMin, 0, math.Inf(1)))
+var btnLgamma = makeRealFuncValue("lgamma", lgamma, digamma, bigLgamma, exactLgamma, ivUnimodal(lgamma, gammaMin, 0, math.Inf(1)))
+var btnErf = makeRealFuncValue("erf", math.Erf, func(x float64) float64 { return 2 / math.SqrtPi * math.Exp(-x*x) }, bigErf, exactAt(0, 0), ivIncreasing(math.Erf, math.Inf(-1), math.Inf(1)))
+var btnErfc = makeRealFuncValue("erfc", math.Erfc, func(x float64) float64 { return -2 / math.SqrtPi * math.Exp(-x*x) }, bigErfc, exactAt(0, 1), ivDecreasing(math.Erfc, math.Inf(-1), math.Inf(1)))
+var btnErfinv = makeRealFuncValue("erfinv", math.Erfinv, erfinvDeriv, bigErfinv, exactAt(0, 0), ivIncreasing(math.Erfinv, -1, 1))
+
+var btnBeta = makeFloatFunc2Value("beta", beta, func(a, b float64) (float64, float64) {
+	r, d := beta(a, b), digamma(a+b)
+	return r * (digamma(a) - d), r * (digamma(b) - d)
+}, bigBeta, exactBeta)
+
+var btnBesselj = makeBesselFuncValue("besselj", makeFloatFunc2Value("besselj", func(n, x float64) float64 { return math.Jn(int(n), x) }, besselDeriv(math.Jn), func(n, x *big.Float, prec uint) *big.Float {
+	m, _ := n.Int64()
+	return bigBesselJ(int(m), x, prec)
+}, func(n, x *big.Rat) *big.Rat {
+	if x.Sign() != 0 {
+		return nil
+	}
+	if n.Sign() == 0 {
+		return big.NewRat(1, 1)
+	}
+	return new(big.Rat)
+}))
+
+var btnBessely = makeBesselFuncValue("bessely", makeFloatFunc2Value("bessely", func(n, x float64) float64 { return math.Yn(int(n), x) }, besselDeriv(math.Yn), nil, nil))
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// Descriptive statistics and probability distributions. The statistics accept a list or any number of arguments and
+// use the arithmetic operators, so they are exact in rational mode and work on any kind of number (and on quantities).
+// The distributions are computed with floating point numbers, except the binomial distribution which is exact for
+// rational probabilities.
+
+// Returns the values of a statistic: the elements of the list if there is a single list argument, the arguments
+// otherwise
+func statArgs(name string, argv []*value, lineno int) []*value {
+	xs := argv
+	if len(argv) == 1 && argv[0].kind == LVAL {
+		xs = *argv[0].lval
+	}
+	if len(xs) == 0 {
+		panic(fmt.Errorf("Can not apply %s to an empty list at line %d", name, lineno))
+	}
+	return xs
+}
+
+func statSum(xs []*value, lineno int) *value {
+	r := xs[0]
+	for _, x := range xs[1:] {
+		r = magnitudeOp("+", r, x, lineno)
+	}
+	return r
+}
+
+func statMean(xs []*value, lineno int) *value {
+	return magnitudeOp("/", statSum(xs, lineno), newIntval(*big.NewInt(int64(len(xs))), DECFLV), lineno)
+}
+
+// Sample covariance of xs and ys (with n-1 in the denominator), the sample variance if they are the same list
+func statCovariance(name string, xs, ys []*value, lineno int) *value {
+	if len(xs) != len(ys) {
+		panic(fmt.Errorf("Can not apply %s at line %d: needs two lists with the same length", name, lineno))
+	}
+	if len(xs) < 2 {
+		panic(fmt.Errorf("Can not apply %s at line %d: needs at least two values", name, lineno))
+	}
+	mx, my := statMean(xs, lineno), statMean(ys, lineno)
+	var r *value
+	for i := range xs {
+		d := magnitudeOp("*", magnitudeOp("-", xs[i], mx, lineno), magnitudeOp("-", ys[i], my, lineno), lineno)
+		if r == nil {
+			r = d
+		} else {
+			r = magnitudeOp("+", r, d, lineno)
+		}
+	}
+	return magnitudeOp("/", r, newIntval(*big.NewInt(int64(len(xs) - 1)), DECFLV), lineno)
+}
+
+// Returns a sorted copy of xs
+func statSorted(xs []*value, lineno int) []*value {
+	r := append([]*value{}, xs...)
+	sort.SliceStable(r, func(i, j int) bool {
+		return magnitudeOp("<", r[i], r[j], lineno).Bool(lineno)
+	})
+	return r
+}
+
+func statSqrt(x *value, lineno int) *value {
+	return btnSqrt.bval.fn([]*value{x}, lineno)
+}
+
+var btnMean = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	return statMean(statArgs("mean", argv, lineno), lineno)
+})
+
+var btnMedian = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	xs := statSorted(statArgs("median", argv, lineno), lineno)
+	n := len(xs)
+	if n%2 == 1 {
+		return xs[n/2]
+	}
+	return statMean(xs[n/2-1:n/2+1], lineno)
+})
+
+// Most frequent value, the smallest one if there are several
+var btnMode = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	xs := statSorted(statArgs("mode", argv, lineno), lineno)
+	best, bestCount := xs[0], 0
+	for i := 0; i < len(xs); {
+		j := i + 1
+		for j < len(xs) && magnitudeOp("==", xs[i], xs[j], lineno).Bool(lineno) {
+			j++
+		}
+		if j-i > bestCount {
+			best, bestCount = xs[i], j-i
+		}
+		i = j
+	}
+	return best
+})
+
+var btnVariance = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	xs := statArgs("variance", argv, lineno)
+	return statCovariance("variance", xs, xs, lineno)
+})
+
+var btnStddev = makeVariadicFuncValue(1, -1, func(argv []*value, lineno int) *value {
+	xs := statArgs("stddev", argv, lineno)
+	return statSqrt(statCovariance("stddev", xs, xs, lineno), lineno)
+})
+
+// p-th percentile of xs (0 <= p <= 100), interpolating linearly between the closest values
+var btnPercentile = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	xs := statSorted(listArg("percentile", argv[0], lineno), lineno)
+	if len(xs) == 0 {
+		panic(fmt.Errorf("Can not apply percentile to an empty list at line %d", lineno))
+	}
+	p := argv[1]
+	if pf := p.Real(lineno); pf < 0 || pf > 100 {
+		panic(fmt.Errorf("The percentile must be between 0 and 100, not %s at line %d", p, lineno))
+	}
+	// the position h = (n-1)*p/100 splits into an integer part i and a fraction f
+	h := magnitudeOp("/", magnitudeOp("*", newIntval(*big.NewInt(int64(len(xs) - 1)), DECFLV), p, lineno), newIntval(*big.NewInt(100), DECFLV), lineno)
+	i := int(math.Floor(h.Real(lineno)))
+	if i >= len(xs)-1 {
+		return xs[len(xs)-1]
+	}
+	f := magnitudeOp("-", h, newIntval(*big.NewInt(int64(i)), DECFLV), lineno)
+	if !magnitudeOp("!=", f, newZeroVal(IVAL, DECFLV, 0), lineno).Bool(lineno) {
+		return xs[i]
+	}
+	return magnitudeOp("+", xs[i], magnitudeOp("*", f, magnitudeOp("-", xs[i+1], xs[i], lineno), lineno), lineno)
+})
+
+var btnCovariance = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	return statCovariance("covariance", listArg("covariance", argv[0], lineno), listArg("covariance", argv[1], lineno), lineno)
+})
+
+// Pearson correlation coefficient
+var btnCorrelation = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	xs, ys := listArg("correlation", argv[0], lineno), listArg("correlation", argv[1], lineno)
+	cov := statCovariance("correlation", xs, ys, lineno)
+	vxy := magnitudeOp("*", statCovariance("correlation", xs, xs, lineno), statCovariance("correlation", ys, ys, lineno), lineno)
+	if !magnitudeOp("!=", vxy, newZeroVal(IVAL, DECFLV, 0), lineno).Bool(lineno) {
+		panic(fmt.Errorf("Correlation is undefined for a constant list at line %d", lineno))
+	}
+	return magnitudeOp("/", cov, statSqrt(vxy, lineno), lineno)
+})
+
+// Regularized incomplete beta function I_x(a, b), with the continued fraction of Numerical Recipes
+func incompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	// the continued fraction converges quickly for x < (a+1)/(a+b+2), otherwise I_x(a, b) = 1 - I_(1-x)(b, a)
+	if x > (a+1)/(a+b+2) {
+		return 1 - incompleteBeta(b, a, 1-x)
+	}
+	// ln Γ(a+b) - ln Γ(a) - ln Γ(b), computed from the larger of a and b
+	lg, _ := math.Lgamma(math.Min(a, b))
+	front := math.Exp(lgammaRatio(math.Max(a, b), math.Min(a, b)) - lg + a*math.Log(x) + b*math.Log1p(-x))
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1.0; m < 1000; m++ {
+		for _, num := range []float64{m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m)), -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < 1e-16 {
+			break
+		}
+	}
+	return front * h / a
+}
+
+// Returns ln Γ(x+a) - ln Γ(x). For large x the difference of the logarithms loses all digits, it is computed with
+// Stirling's series instead.
+func lgammaRatio(x, a float64) float64 {
+	if x < 100 {
+		l1, _ := math.Lgamma(x + a)
+		l2, _ := math.Lgamma(x)
+		return l1 - l2
+	}
+	stirling := func(z float64) float64 { return 1/(12*z) - 1/(360*z*z*z) }
+	return (x-0.5)*math.Log1p(a/x) + a*math.Log(x+a) - a + stirling(x+a) - stirling(x)
+}
+
+// Regularized lower incomplete gamma function P(a, x)
+func incompleteGamma(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	lga, _ := math.Lgamma(a)
+	front := math.Exp(a*math.Log(x) - x - lga)
+	if x < a+1 {
+		// series
+		sum, term := 1/a, 1/a
+		for n := 1.0; n < 1000; n++ {
+			term *= x / (a + n)
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-17 {
+				break
+			}
+		}
+		return front * sum
+	}
+	// continued fraction of the upper function Q(a, x) = 1 - P(a, x)
+	const tiny = 1e-300
+	b := x + 1 - a
+	c, d := 1/tiny, 1/b
+	h := d
+	for i := 1.0; i < 1000; i++ {
+		an := -i * (i - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		if math.Abs(d*c-1) < 1e-16 {
+			break
+		}
+	}
+	return 1 - front*h
+}
+
+// Inverts the continuous increasing cdf on (lo, hi) by bisection, the bounds grow until they contain the quantile
+func invertCdf(cdf func(float64) float64, p, lo, hi float64) float64 {
+	for math.IsInf(lo, -1) || cdf(lo) > p {
+		if math.IsInf(lo, -1) {
+			lo = -1
+		} else {
+			lo *= 2
+		}
+	}
+	for cdf(hi) < p {
+		hi *= 2
+	}
+	for i := 0; i < 200; i++ {
+		m := lo + (hi-lo)/2
+		if m == lo || m == hi {
+			break
+		}
+		if cdf(m) < p {
+			lo = m
+		} else {
+			hi = m
+		}
+	}
+	return lo + (hi-lo)/2
+}
+
+// Returns the real arguments of a distribution function
+func distArgs(argv []*value, lineno int) []float64 {
+	r := make([]float64, len(argv))
+	for i, v := range argv {
+		r[i] = v.Real(lineno)
+	}
+	return r
+}
+
+// Checks the probability argument of a quantile function
+func distProbability(name string, p float64, lineno int) float64 {
+	if !(p >= 0 && p <= 1) {
+		panic(fmt.Errorf("The probability of %s must be between 0 and 1, not %g at line %d", name, p, lineno))
+	}
+	return p
+}
+
+// Checks that the parameter of a distribution is positive
+func distPositive(name, param string, x float64, lineno int) float64 {
+	if !(x > 0) || math.IsInf(x, 1) {
+		panic(fmt.Errorf("The %s of %s must be positive, not %g at line %d", param, name, x, lineno))
+	}
+	return x
+}
+
+// Makes a builtin computing a function of a distribution with parameters, fn receives x and the parameters
+func makeDistFuncValue(minargs, maxargs int, fn func(x float64, params []float64, lineno int) float64) *value {
+	return makeVariadicFuncValue(minargs, maxargs, func(argv []*value, lineno int) *value {
+		args := distArgs(argv, lineno)
+		return floatResult(fn(args[0], args[1:], lineno), lineno)
+	})
+}
+
+// Mean and standard deviation of the normal distribution, 0 and 1 if omitted
+func normalParams(name string, params []float64, lineno int) (float64, float64) {
+	mu, sigma := 0.0, 1.0
+	if len(params) >= 1 {
+		mu = params[0]
+	}
+	if len(params) >= 2 {
+		sigma = distPositive(name, "standard deviation", params[1], lineno)
+	}
+	return mu, sigma
+}
+
+var btnNormpdf = makeDistFuncValue(1, 3, func(x float64, params []float64, lineno int) float64 {
+	mu, sigma := normalParams("normpdf", params, lineno)
+	z := (x - mu) / sigma
+	return math.Exp(-z*z/2) / (sigma * math.Sqrt(2*math.Pi))
+})
+
+var btnNormcdf = makeDistFuncValue(1, 3, func(x float64, params []float64, lineno int) float64 {
+	mu, sigma := normalParams("normcdf", params, lineno)
+	return math.Erfc(-(x-mu)/(sigma*math.Sqrt2)) / 2
+})
+
+var btnNormquantile = makeDistFuncValue(1, 3, func(p float64, params []float64, lineno int) float64 {
+	mu, sigma := normalParams("normquantile", params, lineno)
+	return mu + sigma*math.Sqrt2*math.Erfinv(2*distProbability("normquantile", p, lineno)-1)
+})
+
+func tCdf(t, nu float64) float64 {
+	// I_x(nu/2, 1/2) = 1 - I_(1-x)(1/2, nu/2), 1-x is computed directly since x rounds to 1 when t*t is much smaller than nu
+	tail := incompleteBeta(nu/2, 0.5, nu/(nu+t*t)) / 2
+	if t*t < nu {
+		tail = (1 - incompleteBeta(0.5, nu/2, t*t/(nu+t*t))) / 2
+	}
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+var btnTpdf = makeDistFuncValue(2, 2, func(t float64, params []float64, lineno int) float64 {
+	nu := distPositive("tpdf", "degrees of freedom", params[0], lineno)
+	return math.Exp(lgammaRatio(nu/2, 0.5)-(nu+1)/2*math.Log1p(t*t/nu)) / (math.Sqrt(nu) * math.SqrtPi)
+})
+
+var btnTcdf = makeDistFuncValue(2, 2, func(t float64, params []float64, lineno int) float64 {
+	return tCdf(t, distPositive("tcdf", "degrees of freedom", params[0], lineno))
+})
+
+var btnTquantile = makeDistFuncValue(2, 2, func(p float64, params []float64, lineno int) float64 {
+	nu := distPositive("tquantile", "degrees of freedom", params[0], lineno)
+	switch distProbability("tquantile", p, lineno) {
+	case 0:
+		return math.Inf(-1)
+	case 0.5:
+		return 0
+	case 1:
+		return math.Inf(1)
+	}
+	return invertCdf(func(t float64) float64 { return tCdf(t, nu) }, p, math.Inf(-1), 1)
+})
+
+var btnChi2pdf = makeDistFuncValue(2, 2, func(x float64, params []float64, lineno int) float64 {
+	k := distPositive("chi2pdf", "degrees of freedom", params[0], lineno)
+	if x < 0 {
+		return 0
+	}
+	if x == 0 {
+		switch {
+		case k < 2:
+			return math.Inf(1)
+		case k == 2:
+			return 0.5
+		}
+		return 0
+	}
+	lg, _ := math.Lgamma(k / 2)
+	return math.Exp((k/2-1)*math.Log(x) - x/2 - k/2*math.Ln2 - lg)
+})
+
+var btnChi2cdf = makeDistFuncValue(2, 2, func(x float64, params []float64, lineno int) float64 {
+	return incompleteGamma(distPositive("chi2cdf", "degrees of freedom", params[0], lineno)/2, x/2)
+})
+
+var btnChi2quantile = makeDistFuncValue(2, 2, func(p float64, params []float64, lineno int) float64 {
+	k := distPositive("chi2quantile", "degrees of freedom", params[0], lineno)
+	switch distProbability("chi2quantile", p, lineno) {
+	case 0:
+		return 0
+	case 1:
+		return math.Inf(1)
+	}
+	return invertCdf(func(x float64) float64 { return incompleteGamma(k/2, x/2) }, p, 0, k+1)
+})
+
+var btnExppdf = makeDistFuncValue(2, 2, func(x float64, params []float64, lineno int) float64 {
+	lambda := distPositive("exppdf", "rate", params[0], lineno)
+	if x < 0 {
+		return 0
+	}
+	return lambda * math.Exp(-lambda*x)
+})
+
+var btnExpcdf = makeDistFuncValue(2, 2, func(x float64, params []float64, lineno int) float64 {
+	lambda := distPositive("expcdf", "rate", params[0], lineno)
+	if x < 0 {
+		return 0
+	}
+	return -math.Expm1(-lambda * x)
+})
+
+var btnExpquantile = makeDistFuncValue(2, 2, func(p float64, params []float64, lineno int) float64 {
+	lambda := distPositive("expquantile", "rate", params[0], lineno)
+	return -math.Log1p(-distProbability("expquantile", p, lineno)) / lambda
+})
+
+// Returns the number of successes argument of a discrete distribution
+func distCount(name string, vv *value, lineno int) int64 {
+	if vv.kind != IVAL || !vv.ival.IsInt64() {
+		panic(fmt.Errorf("Can not apply %s to non-integer value at line %d", name, lineno))
+	}
+	return vv.ival.Int64()
+}
+
+// Returns the parameters n and p of the binomial distribution
+func binomialParams(name string, argv []*value, lineno int) (int64, *value) {
+	n := distCount(name, argv[1], lineno)
+	if n < 0 {
+		panic(fmt.Errorf("The number of trials of %s must not be negative, not %d at line %d", name, n, lineno))
+	}
+	if p := argv[2].Real(lineno); !(p >= 0 && p <= 1) {
+		panic(fmt.Errorf("The probability of %s must be between 0 and 1, not %s at line %d", name, argv[2], lineno))
+	}
+	return n, argv[2]
+}
+
+// Probability of k successes among n trials with probability p, computed with the arithmetic operators so that it is
+// exact for rational p. For floating point p the binomial coefficient and the powers overflow, the probability is
+// computed from their logarithms instead.
+func binomialPmf(k, n int64, p *value, lineno int) *value {
+	if p.kind == DVAL {
+		x := p.dval
+		switch {
+		case x == 0:
+			return newBoolval(k == 0)
+		case x == 1:
+			return newBoolval(k == n)
+		}
+		lc := lgammaRatio(float64(n-k)+1, float64(k)) - lgammaRatio(1, float64(k))
+		return newFloatval(math.Exp(lc+float64(k)*math.Log(x)+float64(n-k)*math.Log1p(-x)), DECFLV)
+	}
+	q := magnitudeOp("-", newBoolval(true), p, lineno)
+	c := newIntval(*new(big.Int).Binomial(n, k), DECFLV)
+	pk := magnitudeOp("**", p, newIntval(*big.NewInt(k), DECFLV), lineno)
+	qk := magnitudeOp("**", q, newIntval(*big.NewInt(n - k), DECFLV), lineno)
+	return magnitudeOp("*", c, magnitudeOp("*", pk, qk, lineno), lineno)
+}
+
+var btnBinompdf = makeFuncValue(3, func(argv []*value, lineno int) *value {
+	k := distCount("binompdf", argv[0], lineno)
+	n, p := binomialParams("binompdf", argv, lineno)
+	if k < 0 || k > n {
+		return newZeroVal(IVAL, DECFLV, 0)
+	}
+	return binomialPmf(k, n, p, lineno)
+})
+
+var btnBinomcdf = makeFuncValue(3, func(argv []*value, lineno int) *value {
+	k := distCount("binomcdf", argv[0], lineno)
+	n, p := binomialParams("binomcdf", argv, lineno)
+	cdf := newZeroVal(IVAL, DECFLV, 0)
+	for i := int64(0); i <= k && i <= n; i++ {
+		cdf = magnitudeOp("+", cdf, binomialPmf(i, n, p, lineno), lineno)
+	}
+	return cdf
+})
+
+// Smallest k such that binomcdf(k, n, p) >= q
+var btnBinomquantile = makeFuncValue(3, func(argv []*value, lineno int) *value {
+	n, p := binomialParams("binomquantile", argv, lineno)
+	q := argv[0]
+	distProbability("binomquantile", q.Real(lineno), lineno)
+	cdf := newZeroVal(IVAL, DECFLV, 0)
+	for k := int64(0); k < n; k++ {
+		cdf = magnitudeOp("+", cdf, binomialPmf(k, n, p, lineno), lineno)
+		if magnitudeOp(">=", cdf, q, lineno).Bool(lineno) {
+			return newIntval(*big.NewInt(k), DECFLV)
+		}
+	}
+	return newIntval(*big.NewInt(n), DECFLV)
+})
+
+func poissonCdf(k, lambda float64) float64 {
+	if k < 0 {
+		return 0
+	}
+	return 1 - incompleteGamma(k+1, lambda)
+}
+
+var btnPoissonpdf = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	k := distCount("poissonpdf", argv[0], lineno)
+	lambda := distPositive("poissonpdf", "mean", argv[1].Real(lineno), lineno)
+	if k < 0 {
+		return newZeroVal(IVAL, DECFLV, 0)
+	}
+	lf, _ := math.Lgamma(float64(k) + 1)
+	return floatResult(math.Exp(float64(k)*math.Log(lambda)-lambda-lf), lineno)
+})
+
+var btnPoissoncdf = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	k := distCount("poissoncdf", argv[0], lineno)
+	lambda := distPositive("poissoncdf", "mean", argv[1].Real(lineno), lineno)
+	if k < 0 {
+		return newZeroVal(IVAL, DECFLV, 0)
+	}
+	return floatResult(poissonCdf(float64(k), lambda), lineno)
+})
+
+// Smallest k such that poissoncdf(k, lambda) >= p
+var btnPoissonquantile = makeFuncValue(2, func(argv []*value, lineno int) *value {
+	p := distProbability("poissonquantile", argv[0].Real(lineno), lineno)
+	lambda := distPositive("poissonquantile", "mean", argv[1].Real(lineno), lineno)
+	if p == 1 {
+		panic(fmt.Errorf("The quantile 1 of the Poisson distribution is infinite at line %d", lineno))
+	}
+	// the quantile is near the mean, within a few standard deviations
+	hi := math.Ceil(lambda + 10*math.Sqrt(lambda) + 10)
+	for poissonCdf(hi, lambda) < p {
+		hi *= 2
+	}
+	lo := -1.0
+	for hi-lo > 1 {
+		m := math.Floor((lo + hi) / 2)
+		if poissonCdf(m, lambda) >= p {
+			hi = m
+		} else {
+			lo = m
+		}
+	}
+	return newIntval(*big.NewInt(int64(hi)), DECFLV)
+})
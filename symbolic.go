@@ -0,0 +1,515 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Symbolic differentiation. diff differentiates the expression returned by a function with the usual rules and
+// simplifies the result by putting it in a normal form: a sum of terms, each one a constant coefficient times a
+// product of powers. Building the normal form folds constants, drops x*1 and x+0, collects like terms (x + 2*x is 3*x,
+// x*x**2 is x**3) and distributes constant factors over sums.
+
+// A factor of a term, base**exp
+type symFactor struct {
+	base AstNode
+	exp  *big.Rat
+}
+
+// A term of a sum, coef times the product of its factors. float is set if a floating point constant was folded in coef
+type symTerm struct {
+	coef    *big.Rat
+	float   bool
+	factors []symFactor
+}
+
+func symBinOp(tt tokenType, op1, op2 AstNode, lineno int) AstNode {
+	return &BinOpNode{tt.Name, tt.BinFn, op1, op2, lineno, tt.Priority}
+}
+
+func symNeg(n AstNode, lineno int) AstNode {
+	return &UniOpNode{SUBOPTOK.Name, SUBOPTOK.UniFn, n, lineno}
+}
+
+func symInt(x int64, lineno int) AstNode {
+	return NewConstNode(newIntval(*big.NewInt(x), DECFLV), lineno)
+}
+
+func symCall(name string, arg AstNode, lineno int) AstNode {
+	return NewFnCallNode(name, []AstNode{arg}, lineno)
+}
+
+// Returns the constant c as an expression, rationals become a division
+func symConstNode(c *big.Rat, float bool, lineno int) AstNode {
+	if float {
+		f, _ := c.Float64()
+		return NewConstNode(newFloatval(f, DECFLV), lineno)
+	}
+	num := NewConstNode(newIntval(*new(big.Int).Set(c.Num()), DECFLV), lineno)
+	if c.IsInt() {
+		return num
+	}
+	return symBinOp(DIVOPTOK, num, NewConstNode(newIntval(*new(big.Int).Set(c.Denom()), DECFLV), lineno), lineno)
+}
+
+// Returns the value of a numeric constant, float is set for floating point constants
+func symConstValue(n AstNode) (c *big.Rat, float bool, ok bool) {
+	k, isConst := n.(*ConstNode)
+	if !isConst {
+		return nil, false, false
+	}
+	switch k.v.kind {
+	case IVAL:
+		return new(big.Rat).SetInt(&k.v.ival), false, true
+	case RVAL:
+		return new(big.Rat).Set(&k.v.rval), false, true
+	case DVAL:
+		if math.IsInf(k.v.dval, 0) || math.IsNaN(k.v.dval) {
+			return nil, false, false
+		}
+		return new(big.Rat).SetFloat64(k.v.dval), true, true
+	}
+	return nil, false, false
+}
+
+// Returns true if the value of n can depend on the variable x
+func symDepends(n AstNode, x string) bool {
+	switch n := n.(type) {
+	case *VarNode:
+		return n.name == x
+	case *ConstNode:
+		return false
+	case *BinOpNode:
+		return symDepends(n.op1, x) || symDepends(n.op2, x)
+	case *ShortCircuitNode:
+		return symDepends(n.op1, x) || symDepends(n.op2, x)
+	case *UniOpNode:
+		return symDepends(n.child, x)
+	case *CondNode:
+		return symDepends(n.cond, x) || symDepends(n.ifTrue, x) || symDepends(n.ifFalse, x)
+	case *ConvNode:
+		return symDepends(n.expr, x)
+	case *FnCallNode:
+		for _, arg := range n.args {
+			if symDepends(arg, x) {
+				return true
+			}
+		}
+		return symDepends(n.callee, x)
+	case *ListNode:
+		for _, e := range n.elems {
+			if symDepends(e, x) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Derivatives of the builtin functions of one argument u, the chain rule multiplies them by the derivative of u
+var symDerivatives = map[string]func(u AstNode, lineno int) AstNode{
+	"sin": func(u AstNode, lineno int) AstNode { return symCall("cos", u, lineno) },
+	"cos": func(u AstNode, lineno int) AstNode { return symNeg(symCall("sin", u, lineno), lineno) },
+	"tan": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(POWOPTOK, symCall("cos", u, lineno), symInt(2, lineno), lineno), lineno)
+	},
+	"sinh": func(u AstNode, lineno int) AstNode { return symCall("cosh", u, lineno) },
+	"cosh": func(u AstNode, lineno int) AstNode { return symCall("sinh", u, lineno) },
+	"tanh": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(POWOPTOK, symCall("cosh", u, lineno), symInt(2, lineno), lineno), lineno)
+	},
+	"asin": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symCall("sqrt", symBinOp(SUBOPTOK, symInt(1, lineno), symBinOp(POWOPTOK, u, symInt(2, lineno), lineno), lineno), lineno), lineno)
+	},
+	"acos": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(-1, lineno), symCall("sqrt", symBinOp(SUBOPTOK, symInt(1, lineno), symBinOp(POWOPTOK, u, symInt(2, lineno), lineno), lineno), lineno), lineno)
+	},
+	"atan": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(ADDOPTOK, symInt(1, lineno), symBinOp(POWOPTOK, u, symInt(2, lineno), lineno), lineno), lineno)
+	},
+	"asinh": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symCall("sqrt", symBinOp(ADDOPTOK, symBinOp(POWOPTOK, u, symInt(2, lineno), lineno), symInt(1, lineno), lineno), lineno), lineno)
+	},
+	"acosh": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symCall("sqrt", symBinOp(SUBOPTOK, symBinOp(POWOPTOK, u, symInt(2, lineno), lineno), symInt(1, lineno), lineno), lineno), lineno)
+	},
+	"atanh": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(SUBOPTOK, symInt(1, lineno), symBinOp(POWOPTOK, u, symInt(2, lineno), lineno), lineno), lineno)
+	},
+	"exp": func(u AstNode, lineno int) AstNode { return symCall("exp", u, lineno) },
+	"ln":  func(u AstNode, lineno int) AstNode { return symBinOp(DIVOPTOK, symInt(1, lineno), u, lineno) },
+	"log10": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(MULOPTOK, u, symCall("ln", symInt(10, lineno), lineno), lineno), lineno)
+	},
+	"log2": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(MULOPTOK, u, symCall("ln", symInt(2, lineno), lineno), lineno), lineno)
+	},
+	"expm1": func(u AstNode, lineno int) AstNode { return symCall("exp", u, lineno) },
+	"log1p": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(ADDOPTOK, symInt(1, lineno), u, lineno), lineno)
+	},
+	"sqrt": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(MULOPTOK, symInt(2, lineno), symCall("sqrt", u, lineno), lineno), lineno)
+	},
+	"cbrt": func(u AstNode, lineno int) AstNode {
+		return symBinOp(DIVOPTOK, symInt(1, lineno), symBinOp(MULOPTOK, symInt(3, lineno), symBinOp(POWOPTOK, symCall("cbrt", u, lineno), symInt(2, lineno), lineno), lineno), lineno)
+	},
+	"abs": func(u AstNode, lineno int) AstNode { return symBinOp(DIVOPTOK, u, symCall("abs", u, lineno), lineno) },
+}
+
+// Returns the derivative of the expression n with respect to the variable x, not simplified
+func symDerivative(n AstNode, x string, lineno int) AstNode {
+	if !symDepends(n, x) {
+		return symInt(0, lineno)
+	}
+	d := func(n AstNode) AstNode {
+		return symDerivative(n, x, lineno)
+	}
+	mul := func(a, b AstNode) AstNode {
+		return symBinOp(MULOPTOK, a, b, lineno)
+	}
+	switch n := n.(type) {
+	case *VarNode:
+		return symInt(1, lineno)
+	case *UniOpNode:
+		if n.name == "-" {
+			return symNeg(d(n.child), lineno)
+		}
+	case *BinOpNode:
+		a, b := n.op1, n.op2
+		switch n.name {
+		case "+", "-":
+			return symBinOp(TokenTypes[n.name], d(a), d(b), lineno)
+		case "*":
+			return symBinOp(ADDOPTOK, mul(d(a), b), mul(a, d(b)), lineno)
+		case "/":
+			num := symBinOp(SUBOPTOK, mul(d(a), b), mul(a, d(b)), lineno)
+			return symBinOp(DIVOPTOK, num, symBinOp(POWOPTOK, b, symInt(2, lineno), lineno), lineno)
+		case "**":
+			switch {
+			case !symDepends(b, x):
+				// b*a**(b-1)*a'
+				return mul(mul(b, symBinOp(POWOPTOK, a, symBinOp(SUBOPTOK, b, symInt(1, lineno), lineno), lineno)), d(a))
+			case !symDepends(a, x):
+				// a**b*ln(a)*b'
+				return mul(mul(n, symCall("ln", a, lineno)), d(b))
+			}
+			// a**b*(b'*ln(a) + b*a'/a)
+			return mul(n, symBinOp(ADDOPTOK, mul(d(b), symCall("ln", a, lineno)), symBinOp(DIVOPTOK, mul(b, d(a)), a, lineno), lineno))
+		}
+	case *FnCallNode:
+		if rule, ok := symDerivatives[n.name]; ok && len(n.args) == 1 {
+			return mul(rule(n.args[0], lineno), d(n.args[0]))
+		}
+	case *CondNode:
+		return NewCondNode(n.cond, d(n.ifTrue), d(n.ifFalse), lineno)
+	}
+	panic(fmt.Errorf("Can not differentiate %s at line %d", exprSource(n), lineno))
+}
+
+// Returns the simplified form of the expression n
+func symSimplify(n AstNode, lineno int) AstNode {
+	return symRebuild(symTerms(n, lineno), lineno)
+}
+
+// Simplifies the operands of n, used for the expressions that are not sums, products or powers
+func symSimplifyChildren(n AstNode, lineno int) AstNode {
+	switch n := n.(type) {
+	case *BinOpNode:
+		return &BinOpNode{n.name, n.fn, symSimplify(n.op1, lineno), symSimplify(n.op2, lineno), n.lineno, n.priority}
+	case *UniOpNode:
+		return &UniOpNode{n.name, n.fn, symSimplify(n.child, lineno), n.lineno}
+	case *CondNode:
+		return NewCondNode(symSimplify(n.cond, lineno), symSimplify(n.ifTrue, lineno), symSimplify(n.ifFalse, lineno), n.lineno)
+	case *FnCallNode:
+		args := make([]AstNode, len(n.args))
+		for i, arg := range n.args {
+			args[i] = symSimplify(arg, lineno)
+		}
+		return &FnCallNode{n.name, n.callee, args, n.lineno}
+	}
+	return n
+}
+
+// Returns a term made of the single factor n
+func symOpaque(n AstNode) symTerm {
+	return symTerm{big.NewRat(1, 1), false, []symFactor{{n, big.NewRat(1, 1)}}}
+}
+
+// Returns the terms of the sum n with like terms collected
+func symTerms(n AstNode, lineno int) []symTerm {
+	return symCollect(symTermsOf(n, lineno))
+}
+
+func symTermsOf(n AstNode, lineno int) []symTerm {
+	switch n := n.(type) {
+	case *ConstNode:
+		if c, float, ok := symConstValue(n); ok {
+			return []symTerm{{c, float, nil}}
+		}
+	case *UniOpNode:
+		if n.name == "-" {
+			return symMulTerms(symTerms(n.child, lineno), []symTerm{{big.NewRat(-1, 1), false, nil}})
+		}
+	case *BinOpNode:
+		switch n.name {
+		case "+":
+			return append(symTerms(n.op1, lineno), symTerms(n.op2, lineno)...)
+		case "-":
+			return append(symTerms(n.op1, lineno), symMulTerms(symTerms(n.op2, lineno), []symTerm{{big.NewRat(-1, 1), false, nil}})...)
+		case "*":
+			a, b := symTerms(n.op1, lineno), symTerms(n.op2, lineno)
+			if len(a) <= 1 || len(b) <= 1 {
+				return symMulTerms(a, b)
+			}
+			// products of sums are not expanded
+			return []symTerm{symOpaque(symBinOp(MULOPTOK, symRebuild(a, lineno), symRebuild(b, lineno), lineno))}
+		case "/":
+			a, b := symTerms(n.op1, lineno), symTerms(n.op2, lineno)
+			switch {
+			case len(b) == 0:
+				return []symTerm{symOpaque(symBinOp(DIVOPTOK, symRebuild(a, lineno), symInt(0, lineno), lineno))}
+			case len(b) == 1:
+				return symMulTerms(a, []symTerm{symInvert(b[0])})
+			}
+			return symMulTerms(a, []symTerm{{big.NewRat(1, 1), false, []symFactor{{symRebuild(b, lineno), big.NewRat(-1, 1)}}}})
+		case "**":
+			return symPower(n, lineno)
+		}
+	}
+	return []symTerm{symOpaque(symSimplifyChildren(n, lineno))}
+}
+
+// Returns the terms of the power n
+func symPower(n *BinOpNode, lineno int) []symTerm {
+	base, exp := symTerms(n.op1, lineno), symTerms(n.op2, lineno)
+	if len(exp) == 0 {
+		// x**0
+		return []symTerm{{big.NewRat(1, 1), false, nil}}
+	}
+	e := exp[0].coef
+	if len(exp) > 1 || len(exp[0].factors) > 0 || exp[0].float {
+		return []symTerm{symOpaque(symBinOp(POWOPTOK, symRebuild(base, lineno), symRebuild(exp, lineno), lineno))}
+	}
+	switch {
+	case len(base) == 0:
+		if e.Sign() > 0 {
+			return nil
+		}
+	case len(base) == 1 && e.IsInt() && e.Num().IsInt64() && intAbs(e.Num().Int64()) <= 64 && (base[0].coef.Sign() != 0 || e.Sign() > 0):
+		// (c*x**a)**k is c**k*x**(a*k) for an integer k
+		k := e.Num().Int64()
+		t := base[0]
+		num := new(big.Int).Exp(t.coef.Num(), big.NewInt(intAbs(k)), nil)
+		den := new(big.Int).Exp(t.coef.Denom(), big.NewInt(intAbs(k)), nil)
+		r := symTerm{new(big.Rat).SetFrac(num, den), t.float, make([]symFactor, len(t.factors))}
+		if k < 0 {
+			r.coef.Inv(r.coef)
+		}
+		for i, f := range t.factors {
+			r.factors[i] = symFactor{f.base, new(big.Rat).Mul(f.exp, e)}
+		}
+		return []symTerm{r}
+	case len(base) == 1 && base[0].coef.Cmp(big.NewRat(1, 1)) == 0 && len(base[0].factors) == 1 && base[0].factors[0].exp.Cmp(big.NewRat(1, 1)) == 0:
+		// x**e for a rational e
+		return []symTerm{{big.NewRat(1, 1), false, []symFactor{{base[0].factors[0].base, e}}}}
+	}
+	return []symTerm{{big.NewRat(1, 1), false, []symFactor{{symRebuild(base, lineno), e}}}}
+}
+
+// Returns 1/t
+func symInvert(t symTerm) symTerm {
+	r := symTerm{new(big.Rat).Inv(t.coef), t.float, make([]symFactor, len(t.factors))}
+	for i, f := range t.factors {
+		r.factors[i] = symFactor{f.base, new(big.Rat).Neg(f.exp)}
+	}
+	return r
+}
+
+// Returns the product of the sums a and b, one of them has at most one term
+func symMulTerms(a, b []symTerm) []symTerm {
+	r := []symTerm{}
+	for _, x := range a {
+		for _, y := range b {
+			t := symTerm{new(big.Rat).Mul(x.coef, y.coef), x.float || y.float, nil}
+			// multiplies the powers of the same base
+			pos := map[string]int{}
+			for _, f := range append(append([]symFactor{}, x.factors...), y.factors...) {
+				key := exprSource(f.base)
+				if i, ok := pos[key]; ok {
+					t.factors[i].exp = new(big.Rat).Add(t.factors[i].exp, f.exp)
+					continue
+				}
+				pos[key] = len(t.factors)
+				t.factors = append(t.factors, f)
+			}
+			factors := t.factors[:0]
+			for _, f := range t.factors {
+				if f.exp.Sign() != 0 {
+					factors = append(factors, f)
+				}
+			}
+			t.factors = factors
+			r = append(r, t)
+		}
+	}
+	return symCollect(r)
+}
+
+// Returns the key identifying the terms that differ only by their coefficient
+func symTermKey(t symTerm) string {
+	keys := make([]string, len(t.factors))
+	for i, f := range t.factors {
+		keys[i] = exprSource(f.base) + "**" + f.exp.String()
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "*")
+}
+
+// Adds the coefficients of like terms, terms with a zero coefficient are removed
+func symCollect(terms []symTerm) []symTerm {
+	pos := map[string]int{}
+	r := []symTerm{}
+	for _, t := range terms {
+		key := symTermKey(t)
+		if i, ok := pos[key]; ok {
+			r[i] = symTerm{new(big.Rat).Add(r[i].coef, t.coef), r[i].float || t.float, r[i].factors}
+			continue
+		}
+		pos[key] = len(r)
+		r = append(r, t)
+	}
+	nonzero := r[:0]
+	for _, t := range r {
+		if t.coef.Sign() != 0 {
+			nonzero = append(nonzero, t)
+		}
+	}
+	return nonzero
+}
+
+// Returns the expression of the sum of terms
+func symRebuild(terms []symTerm, lineno int) AstNode {
+	if len(terms) == 0 {
+		return symInt(0, lineno)
+	}
+	r := symProduct(terms[0], lineno)
+	for _, t := range terms[1:] {
+		if t.coef.Sign() < 0 {
+			abs := symTerm{new(big.Rat).Neg(t.coef), t.float, t.factors}
+			r = symBinOp(SUBOPTOK, r, symProduct(abs, lineno), lineno)
+		} else {
+			r = symBinOp(ADDOPTOK, r, symProduct(t, lineno), lineno)
+		}
+	}
+	return r
+}
+
+// Returns the expression of the term t: the coefficient and the factors with a positive exponent, divided by the
+// factors with a negative exponent. Variables come before the other factors.
+func symProduct(t symTerm, lineno int) AstNode {
+	power := func(f symFactor, exp *big.Rat) AstNode {
+		if exp.Cmp(big.NewRat(1, 1)) == 0 {
+			return f.base
+		}
+		return symBinOp(POWOPTOK, f.base, symConstNode(exp, false, lineno), lineno)
+	}
+	factors := append([]symFactor{}, t.factors...)
+	sort.SliceStable(factors, func(i, j int) bool {
+		_, vi := factors[i].base.(*VarNode)
+		_, vj := factors[j].base.(*VarNode)
+		return vi && !vj
+	})
+	num, den := []AstNode{}, []AstNode{}
+	for _, f := range factors {
+		if f.exp.Sign() > 0 {
+			num = append(num, power(f, f.exp))
+		} else {
+			den = append(den, power(f, new(big.Rat).Neg(f.exp)))
+		}
+	}
+
+	neg := t.coef.Sign() < 0
+	coef := new(big.Rat).Abs(t.coef)
+	one := big.NewRat(1, 1)
+	if t.float {
+		if coef.Cmp(one) != 0 || len(num) == 0 {
+			if neg {
+				coef.Neg(coef)
+				neg = false
+			}
+			num = append([]AstNode{symConstNode(coef, true, lineno)}, num...)
+		}
+	} else {
+		if coef.Denom().Cmp(one.Num()) != 0 {
+			den = append([]AstNode{symConstNode(new(big.Rat).SetInt(coef.Denom()), false, lineno)}, den...)
+		}
+		if coef.Num().Cmp(one.Num()) != 0 || len(num) == 0 {
+			c := new(big.Rat).SetInt(coef.Num())
+			if neg {
+				c.Neg(c)
+				neg = false
+			}
+			num = append([]AstNode{symConstNode(c, false, lineno)}, num...)
+		}
+	}
+	if neg && exprPriority(num[0]) == atomPriority {
+		// -x*y rather than -(x*y)
+		num[0] = symNeg(num[0], lineno)
+		neg = false
+	}
+
+	product := func(factors []AstNode) AstNode {
+		r := factors[0]
+		for _, f := range factors[1:] {
+			r = symBinOp(MULOPTOK, r, f, lineno)
+		}
+		return r
+	}
+	r := product(num)
+	if len(den) > 0 {
+		r = symBinOp(DIVOPTOK, r, product(den), lineno)
+	}
+	if neg {
+		r = symNeg(r, lineno)
+	}
+	return r
+}
+
+// Returns the expression returned by the function fn, if its body is a single expression or return statement
+func fnExpression(fn *FnDefNode) AstNode {
+	body, ok := fn.body.(*BodyNode)
+	if !ok || len(body.statements) != 1 {
+		return nil
+	}
+	switch n := body.statements[0].(type) {
+	case *ReturnNode:
+		return n.expr
+	case *BinOpNode, *UniOpNode, *VarNode, *ConstNode, *FnCallNode, *CondNode:
+		return n
+	}
+	return nil
+}
+
+// Returns a function computing the derivative of the function fnv with respect to its argument x
+func symDiff(fnv *value, x string, lineno int) *value {
+	fn := fnv.nval
+	expr := fnExpression(fn)
+	if expr == nil {
+		panic(fmt.Errorf("Can not differentiate a function with statements, its body must be a single expression at line %d", lineno))
+	}
+	found := false
+	for _, arg := range fn.args {
+		found = found || arg == x
+	}
+	if !found {
+		panic(fmt.Errorf("Variable %s is not an argument of the function at line %d", x, lineno))
+	}
+	d := symSimplify(symDerivative(expr, x, lineno), lineno)
+	body := NewBodyNode([]AstNode{&ReturnNode{d, lineno}}, lineno)
+	return newFnval(NewFnDefNode("", fn.args, body, lineno), fnv.env)
+}
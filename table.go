@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"math/cmplx"
 	"strings"
 )
 
@@ -19,9 +20,10 @@ type tokenTypeDef struct {
 
 	IsSetOperator bool // is set operator (=, +=, -=, etc)
 
-	Priority int                      // operator priority, set to -1 for non-operators
-	BinFn    BinOpFunc                // for binary operators this is the function called on execution
-	UniFn    func(*value, int) *value // for unary operators this is the function called on execution
+	Priority   int                      // operator priority, set to -1 for non-operators
+	RightAssoc bool                     // operator is right associative
+	BinFn      BinOpFunc                // for binary operators this is the function called on execution
+	UniFn      func(*value, int) *value // for unary operators this is the function called on execution
 
 	LexFollow []tokenType // lexing aid, if this operator is also the prefix for other tokens put the other tokens here
 }
@@ -57,44 +59,96 @@ func registerTokenType(t tokenType) {
 }
 
 func T(name string) tokenType {
-	r := &tokenTypeDef{nil, name, name, false, -1, nil, nil, nil}
+	r := &tokenTypeDef{nil, name, name, false, -1, false, nil, nil, nil}
 	r.Token = r
 	registerTokenType(r)
 	return r
 }
 
+// Wraps binFn so that integer, rational and decimal results computed from an inexact operand are inexact too
+func inexactBinFn(binFn BinOpFunc) BinOpFunc {
+	if binFn == nil {
+		return nil
+	}
+	return func(a1, a2 *value, kind valueKind, lineno int) *value {
+		r := binFn(a1, a2, kind, lineno)
+		if (a1.inexact || a2.inexact) && (r.kind == IVAL || r.kind == RVAL || r.kind == DECVAL) {
+			r.inexact = true
+		}
+		return r
+	}
+}
+
+// Same as inexactBinFn for unary operators
+func inexactUniFn(uniFn func(*value, int) *value) func(*value, int) *value {
+	if uniFn == nil {
+		return nil
+	}
+	return func(a1 *value, lineno int) *value {
+		r := uniFn(a1, lineno)
+		if a1.inexact && (r.kind == IVAL || r.kind == RVAL || r.kind == DECVAL) {
+			r.inexact = true
+		}
+		return r
+	}
+}
+
 func TOp2(name string, priority int, binFn BinOpFunc) tokenType {
 	return TOp2X(name, name, priority, binFn)
 }
 
 func TOp(name string, priority int, uniFn func(*value, int) *value) tokenType {
-	r := &tokenTypeDef{nil, name, name, false, priority, nil, uniFn, nil}
+	r := &tokenTypeDef{nil, name, name, false, priority, false, nil, inexactUniFn(uniFn), nil}
 	r.Token = r
 	registerTokenType(r)
 	return r
 }
 
 func TSetOp(name string, binFn BinOpFunc) tokenType {
-	r := &tokenTypeDef{nil, name, name, true, -1, binFn, nil, nil}
+	r := &tokenTypeDef{nil, name, name, true, -1, false, binFn, nil, nil}
 	r.Token = r
 	registerTokenType(r)
 	return r
 }
 
 func TOp12(name string, priority int, binFn BinOpFunc, uniFn func(*value, int) *value) tokenType {
-	r := &tokenTypeDef{nil, name, name, false, priority, binFn, uniFn, nil}
+	r := &tokenTypeDef{nil, name, name, false, priority, false, inexactBinFn(binFn), inexactUniFn(uniFn), nil}
 	r.Token = r
 	registerTokenType(r)
 	return r
 }
 
 func TOp2X(name, xname string, priority int, binFn BinOpFunc) tokenType {
-	r := &tokenTypeDef{nil, name, xname, false, priority, binFn, nil, nil}
+	r := &tokenTypeDef{nil, name, xname, false, priority, false, inexactBinFn(binFn), nil, nil}
+	r.Token = r
+	registerTokenType(r)
+	return r
+}
+
+func TOp2R(name string, priority int, binFn BinOpFunc) tokenType {
+	r := &tokenTypeDef{nil, name, name, false, priority, true, inexactBinFn(binFn), nil, nil}
 	r.Token = r
 	registerTokenType(r)
 	return r
 }
 
+// Operator priorities, from the loosest to the tightest binding. They follow C, ± and ** bind tighter than any C operator.
+const (
+	condPriority  = iota // ?: (right associative)
+	lorPriority          // ||
+	landPriority         // &&
+	borPriority          // |
+	bxorPriority         // ^
+	bandPriority         // &
+	eqPriority           // == !=
+	relPriority          // < <= > >=
+	shiftPriority        // << >>
+	addPriority          // + -
+	mulPriority          // * / %
+	uncPriority          // ± +/-
+	powPriority          // ** (right associative)
+)
+
 var ERRTOK = T("an error occoured")
 var EOFTOK = T("end of file")
 
@@ -102,18 +156,26 @@ var REALTOK = T("a real number")
 var INTTOK = T("an integer number")
 var HEXTOK = T("a hexadecimal number")
 var OCTTOK = T("an octal number")
+var IMAGTOK = T("an imaginary number")
+var DECTOK = T("a decimal number")
 var KWDTOK = T("a keyword")
 var SYMTOK = T("any symbol")
 var DATETOK = T("a date constant")
 var TIMETOK = T("a time constant")
+var STRTOK = T("a string")
 
 var PAROPTOK = T("(")
 var PARCLTOK = T(")")
 var CRLOPTOK = T("{")
 var CRLCLTOK = T("}")
+var BRKOPTOK = T("[")
+var BRKCLTOK = T("]")
 var DPYSTMTOK = T("@")
 var COLONTOK = T(":")
 
+// The conditional operator is parsed specially by parseExpressionInfix, it has no BinFn
+var QMARKTOK = TOp2R("?", condPriority, nil)
+
 func sortDtval(a1, a2 *value) (b1, b2 *value) {
 	if a1.kind == DTVAL {
 		return a1, a2
@@ -121,18 +183,57 @@ func sortDtval(a1, a2 *value) (b1, b2 *value) {
 	return a2, a1
 }
 
+// Returns the fixed width integer type of the result of an integer operation on args. Arguments without a fixed width
+// have the type selected with @:u32, @:i8, etc. Like in C's usual arithmetic conversions the widest type wins and, between
+// types of the same width, unsigned wins.
+func resultIntType(args ...*value) intType {
+	var t intType
+	for _, a := range args {
+		at := a.itype
+		if at.bits == 0 {
+			at = intMode
+		}
+		if at.bits > t.bits || (at.bits == t.bits && t.signed && !at.signed) {
+			t = at
+		}
+	}
+	return t
+}
+
+// Converts the arguments of an integer operation to their common type (see resultIntType)
+func intOperands(a1, a2 *value, lineno int) (intType, *big.Int, *big.Int) {
+	t := resultIntType(a1, a2)
+	return t, t.convert(a1.Int(lineno)), t.convert(a2.Int(lineno))
+}
+
 func badtype(name string, lineno int) error {
 	return fmt.Errorf("%d: can not apply %s to non-numeric value", lineno, name)
 }
 
-var ADDOPTOK = TOp2("+", 2, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var ADDOPTOK = TOp2("+", addPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
+	case QVAL:
+		return quantityAdd("+", a1, a2, lineno)
+	case DUVAL:
+		return dualAdd("+", a1, a2, lineno)
 	case IVAL:
+		t, x, y := intOperands(a1, a2, lineno)
 		v := newZeroVal(IVAL, a1.flavor, 0)
-		v.ival.Add(a1.Int(lineno), a2.Int(lineno))
-		return v
+		v.ival.Add(x, y)
+		return v.setIntType(t, true, "+", lineno).reduceMod()
 	case DVAL:
 		return newFloatvalDerived(a1.Real(lineno)+a2.Real(lineno), a1, a2)
+	case CVAL:
+		return newComplexval(a1.Complex(lineno) + a2.Complex(lineno))
+	case FVAL:
+		return newBigFloatval(newBigFloat(floatPrec).Add(a1.BigFloat(lineno), a2.BigFloat(lineno)))
+	case DECVAL:
+		return decimalOp(a1, a2, lineno, (*big.Rat).Add)
+	case IVLVAL:
+		return newIntervalval(ivAdd(a1.Interval(lineno), a2.Interval(lineno)))
+	case UVAL:
+		x, y := a1.Uncertain(lineno), a2.Uncertain(lineno)
+		return newUncertainval(uncCombine(x.x+y.x, x, 1, y, 1))
 	case RVAL:
 		var r big.Rat
 		r.Add(a1.Rat(lineno), a2.Rat(lineno))
@@ -140,19 +241,44 @@ var ADDOPTOK = TOp2("+", 2, func(a1, a2 *value, kind valueKind, lineno int) *val
 	case DTVAL:
 		a1, a2 = sortDtval(a1, a2)
 		return newDateval(a1.dtval.AddDate(0, 0, int(a2.Int(lineno).Int64())))
+	case SVAL:
+		return newStrval(a1.Str(lineno) + a2.Str(lineno))
+	case LVAL:
+		if a1.kind != LVAL || a2.kind != LVAL {
+			panic(fmt.Errorf("Can not add list and non-list value at line %d", lineno))
+		}
+		elems := make([]*value, 0, len(*a1.lval)+len(*a2.lval))
+		elems = append(elems, *a1.lval...)
+		return newListval(append(elems, *a2.lval...))
 	default:
 		panic(badtype("+", lineno))
 	}
 })
 
-var SUBOPTOK = TOp12("-", 2, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var SUBOPTOK = TOp12("-", addPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
+	case QVAL:
+		return quantityAdd("-", a1, a2, lineno)
+	case DUVAL:
+		return dualAdd("-", a1, a2, lineno)
 	case IVAL:
+		t, x, y := intOperands(a1, a2, lineno)
 		v := newZeroVal(IVAL, a1.flavor, 0)
-		v.ival.Sub(a1.Int(lineno), a2.Int(lineno))
-		return v
+		v.ival.Sub(x, y)
+		return v.setIntType(t, true, "-", lineno).reduceMod()
 	case DVAL:
 		return newFloatvalDerived(a1.Real(lineno)-a2.Real(lineno), a1, a2)
+	case CVAL:
+		return newComplexval(a1.Complex(lineno) - a2.Complex(lineno))
+	case FVAL:
+		return newBigFloatval(newBigFloat(floatPrec).Sub(a1.BigFloat(lineno), a2.BigFloat(lineno)))
+	case DECVAL:
+		return decimalOp(a1, a2, lineno, (*big.Rat).Sub)
+	case IVLVAL:
+		return newIntervalval(ivSub(a1.Interval(lineno), a2.Interval(lineno)))
+	case UVAL:
+		x, y := a1.Uncertain(lineno), a2.Uncertain(lineno)
+		return newUncertainval(uncCombine(x.x-y.x, x, 1, y, -1))
 	case RVAL:
 		var r big.Rat
 		r.Sub(a1.Rat(lineno), a2.Rat(lineno))
@@ -174,9 +300,23 @@ var SUBOPTOK = TOp12("-", 2, func(a1, a2 *value, kind valueKind, lineno int) *va
 		case IVAL:
 			v := newZeroVal(IVAL, a1.flavor, 0)
 			v.ival.Neg(&a1.ival)
-			return v
+			return v.setIntType(resultIntType(a1), true, "-", lineno).reduceMod()
 		case DVAL:
 			return newFloatval(-a1.dval, a1.flavor)
+		case CVAL:
+			return newComplexval(-a1.cval)
+		case FVAL:
+			return newBigFloatval(newBigFloat(floatPrec).Neg(a1.fval))
+		case DECVAL:
+			return newDecimalval(new(big.Int).Neg(&a1.ival), a1.prec)
+		case IVLVAL:
+			return newIntervalval(ivNeg(a1.ivl))
+		case UVAL:
+			return newUncertainval(uncApply(-a1.unc.x, a1.unc, -1))
+		case QVAL:
+			return newQuantityval(TokenTypes["-"].UniFn(a1.qty.x, lineno), a1.qty.u, lineno)
+		case DUVAL:
+			return dualNeg(a1, lineno)
 		case RVAL:
 			var r big.Rat
 			r.Neg(&a1.rval)
@@ -186,14 +326,30 @@ var SUBOPTOK = TOp12("-", 2, func(a1, a2 *value, kind valueKind, lineno int) *va
 		}
 	})
 
-var MULOPTOK = TOp2("*", 3, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var MULOPTOK = TOp2("*", mulPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
+	case QVAL:
+		return quantityMul("*", a1, a2, lineno)
+	case DUVAL:
+		return dualMul(a1, a2, lineno)
 	case IVAL:
+		t, x, y := intOperands(a1, a2, lineno)
 		v := newZeroVal(IVAL, a1.flavor, 0)
-		v.ival.Mul(a1.Int(lineno), a2.Int(lineno))
-		return v
+		v.ival.Mul(x, y)
+		return v.setIntType(t, true, "*", lineno).reduceMod()
 	case DVAL:
 		return newFloatvalDerived(a1.Real(lineno)*a2.Real(lineno), a1, a2)
+	case CVAL:
+		return newComplexval(a1.Complex(lineno) * a2.Complex(lineno))
+	case FVAL:
+		return newBigFloatval(newBigFloat(floatPrec).Mul(a1.BigFloat(lineno), a2.BigFloat(lineno)))
+	case DECVAL:
+		return decimalOp(a1, a2, lineno, (*big.Rat).Mul)
+	case IVLVAL:
+		return newIntervalval(ivMul(a1.Interval(lineno), a2.Interval(lineno)))
+	case UVAL:
+		x, y := a1.Uncertain(lineno), a2.Uncertain(lineno)
+		return newUncertainval(uncCombine(x.x*y.x, x, y.x, y, x.x))
 	case RVAL:
 		var r big.Rat
 		r.Mul(a1.Rat(lineno), a2.Rat(lineno))
@@ -203,7 +359,48 @@ var MULOPTOK = TOp2("*", 3, func(a1, a2 *value, kind valueKind, lineno int) *val
 	}
 })
 
-var DIVOPTOK = TOp2("/", 4, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var DIVOPTOK = TOp2("/", mulPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	if kind == QVAL {
+		return quantityMul("/", a1, a2, lineno)
+	}
+	if kind == DUVAL {
+		return dualQuo(a1, a2, lineno)
+	}
+	if kind == CVAL {
+		return newComplexval(a1.Complex(lineno) / a2.Complex(lineno))
+	}
+	if kind == DECVAL {
+		if a2.Rat(lineno).Sign() == 0 {
+			panic(fmt.Errorf("Division by zero at line %d", lineno))
+		}
+		return decimalOp(a1, a2, lineno, (*big.Rat).Quo)
+	}
+	if kind == IVLVAL {
+		return newIntervalval(ivQuo(a1.Interval(lineno), a2.Interval(lineno), lineno))
+	}
+	if kind == UVAL {
+		x, y := a1.Uncertain(lineno), a2.Uncertain(lineno)
+		return newUncertainval(uncCombine(x.x/y.x, x, 1/y.x, y, -x.x/(y.x*y.x)))
+	}
+	if t := resultIntType(a1, a2); kind == IVAL && t.bits != 0 {
+		// fixed width integer division truncates towards zero, like in C
+		_, x, y := intOperands(a1, a2, lineno)
+		if y.Sign() == 0 {
+			panic(fmt.Errorf("Division by zero at line %d", lineno))
+		}
+		v := newZeroVal(IVAL, a1.flavor, 0)
+		v.ival.Quo(x, y)
+		return v.setIntType(t, true, "/", lineno)
+	}
+	if kind == IVAL && modulus != nil && resultIntType(a1, a2).bits == 0 {
+		// multiplication by the inverse modulo the modulus
+		v := newZeroVal(IVAL, a1.flavor, 0)
+		v.ival.Mul(&a1.ival, modInverse("division", &a2.ival, modulus, lineno))
+		return v.reduceMod()
+	}
+	if kind == FVAL || (CommaMode == bigfloatComma && kind != DVAL) {
+		return newBigFloatval(newBigFloat(floatPrec).Quo(a1.BigFloat(lineno), a2.BigFloat(lineno)))
+	}
 	switch CommaMode {
 	case undefinedComma:
 		panic("Can not use division in undefined mode, use '@:f' for floating point or '@:r' for rational")
@@ -220,15 +417,37 @@ var DIVOPTOK = TOp2("/", 4, func(a1, a2 *value, kind valueKind, lineno int) *val
 	}
 })
 
-var MODOPTOK = TOp2("%", 4, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var MODOPTOK = TOp2("%", mulPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	t, x, y := intOperands(a1, a2, lineno)
+	if y.Sign() == 0 {
+		panic(fmt.Errorf("Division by zero at line %d", lineno))
+	}
 	v := newZeroVal(IVAL, a1.flavor, 0)
-	v.ival.Mod(a1.Int(lineno), a2.Int(lineno))
-	return v
+	if t.bits != 0 {
+		// the remainder of the truncated division has the sign of the dividend, like in C
+		v.ival.Rem(x, y)
+	} else {
+		v.ival.Mod(x, y)
+	}
+	return v.setIntType(t, true, "%", lineno)
 })
 
-var POWOPTOK = TOp2("**", 4, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var POWOPTOK = TOp2R("**", powPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
 	case IVAL:
+		if modulus != nil && resultIntType(a1, a2).bits == 0 {
+			// negative exponents, and reduced ones that were negative, are powers of the inverse
+			b, e := a1.Int(lineno), a2.Int(lineno)
+			if a2.modNegative {
+				e = new(big.Int).Sub(e, modulus)
+			}
+			if e.Sign() < 0 {
+				b, e = modInverse("power", b, modulus, lineno), new(big.Int).Neg(e)
+			}
+			v := newZeroVal(IVAL, a1.flavor, 0)
+			v.ival.Exp(new(big.Int).Mod(b, modulus), e, modulus)
+			return v
+		}
 		if a2.Int(lineno).Cmp(&big.Int{}) < 0 {
 			switch CommaMode {
 			case undefinedComma:
@@ -236,36 +455,128 @@ var POWOPTOK = TOp2("**", 4, func(a1, a2 *value, kind valueKind, lineno int) *va
 			case floatComma:
 				return newFloatvalDerived(math.Pow(a1.Real(lineno), a2.Real(lineno)), a1, a2)
 			case rationalComma:
-				return rationalPow(a2, a2, lineno)
+				return rationalPow(a1, a2, lineno)
+			case bigfloatComma:
+				return bigFloatPow(a1, a2, lineno)
 			default:
 				panic("impossible")
 			}
 
 		} else {
+			t := resultIntType(a1, a2)
 			v := newZeroVal(IVAL, a1.flavor, 0)
-			v.ival.Exp(a1.Int(lineno), a2.Int(lineno), nil)
-			return v
+			if t.bits > 0 && !overflowError {
+				// computes the power modulo 2**bits so that huge exponents don't make the intermediate result explode
+				m := new(big.Int).Lsh(big.NewInt(1), uint(t.bits))
+				v.ival.Exp(t.convert(a1.Int(lineno)), a2.Int(lineno), m)
+			} else {
+				v.ival.Exp(t.convert(a1.Int(lineno)), a2.Int(lineno), nil)
+			}
+			return v.setIntType(t, true, "**", lineno)
 		}
 	case DVAL:
-		return newFloatvalDerived(math.Pow(a1.Real(lineno), a2.Real(lineno)), a1, a2)
+		x, y := a1.Real(lineno), a2.Real(lineno)
+		r := math.Pow(x, y)
+		if math.IsNaN(r) && !math.IsNaN(x) && !math.IsNaN(y) {
+			// negative base with a fractional exponent
+			return newComplexval(complexPow(complex(x, 0), complex(y, 0)))
+		}
+		return newFloatvalDerived(r, a1, a2)
 	case RVAL:
 		return rationalPow(a1, a2, lineno)
+	case CVAL:
+		return newComplexval(complexPow(a1.Complex(lineno), a2.Complex(lineno)))
+	case FVAL:
+		return bigFloatPow(a1, a2, lineno)
+	case DECVAL:
+		return decimalPow(a1, a2, lineno)
+	case IVLVAL:
+		return newIntervalval(ivPow(a1, a2, lineno))
+	case UVAL:
+		return newUncertainval(uncPow(a1.Uncertain(lineno), a2.Uncertain(lineno)))
+	case QVAL:
+		return quantityPow(a1, a2, lineno)
+	case DUVAL:
+		return dualPow(a1, a2, lineno)
 	default:
 		panic(badtype("**", lineno))
 	}
 })
 
+// Power in big float mode, integer exponents are computed by repeated squaring, other exponents as exp(y*ln(x))
+func bigFloatPow(a1, a2 *value, lineno int) *value {
+	x, y := a1.BigFloat(lineno), a2.BigFloat(lineno)
+	wp := floatPrec + guardBits
+	if y.IsInt() && y.MantExp(nil) <= 31 {
+		n, _ := y.Int64()
+		r := newBigFloat(wp).SetInt64(1)
+		z := newBigFloat(wp).Set(x)
+		for k := n; k != 0; k /= 2 {
+			if k%2 != 0 {
+				r.Mul(r, z)
+			}
+			z.Mul(z, z)
+		}
+		if n < 0 {
+			r.Quo(big.NewFloat(1), r)
+		}
+		return newBigFloatval(newBigFloat(floatPrec).Set(r))
+	}
+	l := bigLog(x, wp)
+	if l == nil {
+		if x.Sign() == 0 {
+			return newBigFloatval(newBigFloat(floatPrec))
+		}
+		// negative base with a fractional exponent
+		return newComplexval(complexPow(a1.Complex(lineno), a2.Complex(lineno)))
+	}
+	return newBigFloatval(bigExp(l.Mul(l, y), floatPrec))
+}
+
+// Complex power, integer exponents are computed by repeated squaring so that (2i)**2 is exactly -4
+func complexPow(z, w complex128) complex128 {
+	n := real(w)
+	if imag(w) != 0 || n != math.Trunc(n) || math.Abs(n) > 1<<20 {
+		return cmplx.Pow(z, w)
+	}
+	r := complex(1, 0)
+	for k := int64(math.Abs(n)); k > 0; k >>= 1 {
+		if k&1 != 0 {
+			r *= z
+		}
+		z *= z
+	}
+	if n < 0 {
+		return 1 / r
+	}
+	return r
+}
+
+// Rational power, for a fractional exponent p/q the result is exact when the q-th root of the base is rational,
+// otherwise it is computed in floating point and marked inexact
 func rationalPow(a1, a2 *value, lineno int) *value {
 	expfr := a2.Rat(lineno)
 	if !expfr.IsInt() {
+		if expfr.Denom().IsInt64() {
+			if root := ratRoot(a1.Rat(lineno), expfr.Denom().Int64()); root != nil {
+				return rationalPow(newRatval(*root, a1.prec), newIntval(*new(big.Int).Set(expfr.Num()), DECFLV), lineno)
+			}
+		}
 		base, _ := a1.Rat(lineno).Float64()
 		exp, _ := a2.Rat(lineno).Float64()
+		y := math.Pow(base, exp)
+		if math.IsNaN(y) {
+			return newComplexval(complexPow(a1.Complex(lineno), a2.Complex(lineno)))
+		}
 		var r big.Rat
-		r.SetFloat64(math.Pow(base, exp))
-		return newRatval(r, max(12, a1.prec, a2.prec))
+		r.SetFloat64(y)
+		v := newRatval(r, max(12, a1.prec, a2.prec))
+		v.inexact = true
+		return v
 	}
 
-	exp := expfr.Num()
+	// the operands are not modified, they can be shared (for example by the parts of a dual number)
+	exp := new(big.Int).Set(expfr.Num())
 	swap := false
 	if exp.Sign() < 0 {
 		swap = true
@@ -273,11 +584,8 @@ func rationalPow(a1, a2 *value, lineno int) *value {
 	}
 
 	base := a1.Rat(lineno)
-	numerator := base.Num()
-	denominator := base.Denom()
-
-	numerator = numerator.Exp(numerator, exp, nil)
-	denominator = denominator.Exp(denominator, exp, nil)
+	numerator := new(big.Int).Exp(base.Num(), exp, nil)
+	denominator := new(big.Int).Exp(base.Denom(), exp, nil)
 
 	prec := max(a1.prec, a2.prec)
 
@@ -294,32 +602,156 @@ func rationalPow(a1, a2 *value, lineno int) *value {
 	return newRatval(r, prec)
 }
 
-var OROPTOK = TOp2("||", 1, func(a1, a2 *value, kind valueKind, lineno int) *value {
+// Returns the n-th root of x if it is rational, nil otherwise
+func ratRoot(x *big.Rat, n int64) *big.Rat {
+	neg := x.Sign() < 0
+	if n <= 0 || (neg && n%2 == 0) {
+		return nil
+	}
+	num := new(big.Int).Abs(x.Num())
+	num, numExact := intRoot(num, n)
+	den, denExact := intRoot(x.Denom(), n)
+	if !numExact || !denExact {
+		return nil
+	}
+	if neg {
+		num.Neg(num)
+	}
+	return new(big.Rat).SetFrac(num, den)
+}
+
+// Returns the integer n-th root of x >= 0 (rounded down) and whether it is exact
+func intRoot(x *big.Int, n int64) (*big.Int, bool) {
+	one := big.NewInt(1)
+	if x.Cmp(one) <= 0 {
+		return new(big.Int).Set(x), true
+	}
+	if n >= int64(x.BitLen()) {
+		// 1 <= root < 2
+		return one, false
+	}
+	// Newton's method starting from a power of two larger than the root
+	bn := big.NewInt(n)
+	bn1 := big.NewInt(n - 1)
+	r := new(big.Int).Lsh(one, uint(int64(x.BitLen())/n+1))
+	for {
+		// y = ((n-1)*r + x/r**(n-1)) / n
+		y := new(big.Int).Exp(r, bn1, nil)
+		y.Quo(x, y)
+		y.Add(y, new(big.Int).Mul(bn1, r))
+		y.Quo(y, bn)
+		if y.Cmp(r) >= 0 {
+			break
+		}
+		r = y
+	}
+	return r, new(big.Int).Exp(r, bn, nil).Cmp(x) == 0
+}
+
+var OROPTOK = TOp2("||", lorPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	return newBoolval(a1.Bool(lineno) || a2.Bool(lineno))
 })
 
-var BWOROPTOK = TOp2("|", 1, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var BWOROPTOK = TOp2("|", borPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	t, x, y := intOperands(a1, a2, lineno)
 	v := newZeroVal(IVAL, a1.flavor, 0)
-	v.ival.Or(a1.Int(lineno), a2.Int(lineno))
-	return v
+	v.ival.Or(x, y)
+	return v.setIntType(t, false, "|", lineno)
 })
 
-var ANDOPTOK = TOp2("&&", 1, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var ANDOPTOK = TOp2("&&", landPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	return newBoolval(a1.Bool(lineno) && a2.Bool(lineno))
 })
 
-var BWANDOPTOK = TOp2("&", 1, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var BWANDOPTOK = TOp2("&", bandPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	t, x, y := intOperands(a1, a2, lineno)
+	v := newZeroVal(IVAL, a1.flavor, 0)
+	v.ival.And(x, y)
+	return v.setIntType(t, false, "&", lineno)
+})
+
+var BWXOROPTOK = TOp2("^", bxorPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	t, x, y := intOperands(a1, a2, lineno)
+	v := newZeroVal(IVAL, a1.flavor, 0)
+	v.ival.Xor(x, y)
+	return v.setIntType(t, false, "^", lineno)
+})
+
+var BWANDNOTOPTOK = TOp2("&^", bandPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	t, x, y := intOperands(a1, a2, lineno)
+	v := newZeroVal(IVAL, a1.flavor, 0)
+	v.ival.AndNot(x, y)
+	return v.setIntType(t, false, "&^", lineno)
+})
+
+// Returns the shift amount for << and >>, it must be a non-negative integer
+func shiftCount(name string, a2 *value, lineno int) uint {
+	n := a2.Int(lineno)
+	if n.Sign() < 0 || !n.IsInt64() || n.Int64() > math.MaxInt32 {
+		panic(fmt.Errorf("Invalid shift count %s for %s at line %d", n.String(), name, lineno))
+	}
+	return uint(n.Int64())
+}
+
+var SHLOPTOK = TOp2("<<", shiftPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	// like in C the result has the type of the left operand
+	t := resultIntType(a1)
+	v := newZeroVal(IVAL, a1.flavor, 0)
+	v.ival.Lsh(t.convert(a1.Int(lineno)), shiftCount("<<", a2, lineno))
+	return v.setIntType(t, true, "<<", lineno)
+})
+
+// Right shift is arithmetic: negative numbers are rounded towards negative infinity
+var SHROPTOK = TOp2(">>", shiftPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	// like in C the result has the type of the left operand
+	t := resultIntType(a1)
+	v := newZeroVal(IVAL, a1.flavor, 0)
+	v.ival.Rsh(t.convert(a1.Int(lineno)), shiftCount(">>", a2, lineno))
+	return v.setIntType(t, false, ">>", lineno)
+})
+
+// Bitwise complement. Fixed width integers are complemented on their width, unbounded integers are complemented on
+// the lowest bitWidth bits (see @:bits), with a bit width of 0 ~x is -x-1, the two's complement of x with infinite sign extension
+var BWNOTOPTOK = TOp("~", -1, func(a1 *value, lineno int) *value {
+	if a1.kind != IVAL {
+		panic(badtype("~", lineno))
+	}
 	v := newZeroVal(IVAL, a1.flavor, 0)
-	v.ival.And(a1.Int(lineno), a2.Int(lineno))
+	v.ival.Not(&a1.ival)
+	if t := resultIntType(a1); t.bits > 0 {
+		return v.setIntType(t, false, "~", lineno)
+	}
+	if bitWidth > 0 {
+		mask := new(big.Int).Lsh(big.NewInt(1), uint(bitWidth))
+		mask.Sub(mask, big.NewInt(1))
+		v.ival.And(&v.ival, mask)
+	}
 	return v
 })
 
 var INCOPTOK = TOp("++", -1, func(a1 *value, lineno int) *value {
 	switch a1.kind {
 	case IVAL:
-		a1.ival.Add(&a1.ival, big.NewInt(1))
+		// the variable is only changed if the result fits its type
+		v := newZeroVal(IVAL, a1.flavor, 0)
+		v.ival.Add(&a1.ival, big.NewInt(1))
+		v.setIntType(resultIntType(a1), true, "++", lineno).reduceMod()
+		a1.ival = v.ival
+		a1.itype, a1.modNegative = v.itype, v.modNegative
 	case DVAL:
 		a1.dval++
+	case CVAL:
+		a1.cval += 1
+	case FVAL:
+		a1.fval = newBigFloat(a1.fval.Prec()).Add(a1.fval, big.NewFloat(1))
+	case DECVAL:
+		a1.ival.Add(&a1.ival, pow10(a1.prec))
+	case IVLVAL:
+		a1.ivl = ivAdd(a1.ivl, interval{1, 1})
+	case UVAL:
+		a1.unc = uncertain{a1.unc.x + 1, a1.unc.deps}
+	case DUVAL:
+		a1.dual = dual{magnitudeOp("+", a1.dual.x, newBoolval(true), lineno), a1.dual.dx, a1.dual.tag}
 	case RVAL:
 		a1.rval.Add(&a1.rval, big.NewRat(1, 1))
 	default:
@@ -331,9 +763,26 @@ var INCOPTOK = TOp("++", -1, func(a1 *value, lineno int) *value {
 var DECOPTOK = TOp("--", -1, func(a1 *value, lineno int) *value {
 	switch a1.kind {
 	case IVAL:
-		a1.ival.Sub(&a1.ival, big.NewInt(1))
+		// the variable is only changed if the result fits its type
+		v := newZeroVal(IVAL, a1.flavor, 0)
+		v.ival.Sub(&a1.ival, big.NewInt(1))
+		v.setIntType(resultIntType(a1), true, "--", lineno).reduceMod()
+		a1.ival = v.ival
+		a1.itype, a1.modNegative = v.itype, v.modNegative
 	case DVAL:
 		a1.dval--
+	case CVAL:
+		a1.cval -= 1
+	case FVAL:
+		a1.fval = newBigFloat(a1.fval.Prec()).Sub(a1.fval, big.NewFloat(1))
+	case DECVAL:
+		a1.ival.Sub(&a1.ival, pow10(a1.prec))
+	case IVLVAL:
+		a1.ivl = ivAdd(a1.ivl, interval{-1, -1})
+	case UVAL:
+		a1.unc = uncertain{a1.unc.x - 1, a1.unc.deps}
+	case DUVAL:
+		a1.dual = dual{magnitudeOp("-", a1.dual.x, newBoolval(true), lineno), a1.dual.dx, a1.dual.tag}
 	case RVAL:
 		a1.rval.Sub(&a1.rval, big.NewRat(1, 1))
 	default:
@@ -350,79 +799,189 @@ var NEGOPTOK = TOp("!", -1, func(a1 *value, lineno int) *value {
 	return newBoolval(a1.ival.Cmp(&big.Int{}) == 0)
 })
 
-var EQOPTOK = TOp2("==", 0, func(a1, a2 *value, kind valueKind, lineno int) *value {
+// Builds a value with an uncertainty, the ASCII spelling of ± is +/-
+var PMOPTOK = TOp2("±", uncPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
+	return uncPlusMinus(a1, a2, lineno)
+})
+var PMASCIIOPTOK = TOp2X("±", "+/-", uncPriority, PMOPTOK.BinFn)
+
+// Returns true if the integers a1 and a2 are equal, modulo the modulus selected with @:mod if there is one
+func intEqual(a1, a2 *value, lineno int) bool {
+	t, x, y := intOperands(a1, a2, lineno)
+	if modulus != nil && t.bits == 0 {
+		d := new(big.Int).Sub(x, y)
+		return d.Mod(d, modulus).Sign() == 0
+	}
+	return x.Cmp(y) == 0
+}
+
+var EQOPTOK = TOp2("==", eqPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
 	case IVAL:
-		return newBoolval(a1.Int(lineno).Cmp(a2.Int(lineno)) == 0)
+		return newBoolval(intEqual(a1, a2, lineno))
 	case DVAL:
 		return newBoolval(a1.Real(lineno) == a2.Real(lineno))
-	case RVAL:
+	case FVAL:
+		return newBoolval(a1.BigFloat(lineno).Cmp(a2.BigFloat(lineno)) == 0)
+	case QVAL:
+		return quantityCompare("==", a1, a2, lineno)
+	case DUVAL:
+		return dualCompare("==", a1, a2, lineno)
+	case UVAL:
+		return newBoolval(a1.Uncertain(lineno).x == a2.Uncertain(lineno).x)
+	case IVLVAL:
+		x, y := a1.Interval(lineno), a2.Interval(lineno)
+		return ivEqual(x, y)
+	case CVAL:
+		return newBoolval(a1.Complex(lineno) == a2.Complex(lineno))
+	case RVAL, DECVAL:
 		return newBoolval(a1.Rat(lineno).Cmp(a2.Rat(lineno)) == 0)
+	case SVAL:
+		return newBoolval(a1.Str(lineno) == a2.Str(lineno))
 	default:
 		panic(badtype("==", lineno))
 	}
 })
 
-var GEOPTOK = TOp2X("ge", ">=", 0, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var GEOPTOK = TOp2X("ge", ">=", relPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
 	case IVAL:
-		return newBoolval(a1.Int(lineno).Cmp(a2.Int(lineno)) >= 0)
+		_, x, y := intOperands(a1, a2, lineno)
+		return newBoolval(x.Cmp(y) >= 0)
 	case DVAL:
 		return newBoolval(a1.Real(lineno) >= a2.Real(lineno))
-	case RVAL:
+	case FVAL:
+		return newBoolval(a1.BigFloat(lineno).Cmp(a2.BigFloat(lineno)) >= 0)
+	case QVAL:
+		return quantityCompare(">=", a1, a2, lineno)
+	case DUVAL:
+		return dualCompare(">=", a1, a2, lineno)
+	case UVAL:
+		return newBoolval(a1.Uncertain(lineno).x >= a2.Uncertain(lineno).x)
+	case IVLVAL:
+		x, y := a1.Interval(lineno), a2.Interval(lineno)
+		return ivLessEq(y, x)
+	case CVAL:
+		panic(fmt.Errorf("Can not compare complex values with >= at line %d", lineno))
+	case RVAL, DECVAL:
 		return newBoolval(a1.Rat(lineno).Cmp(a2.Rat(lineno)) >= 0)
+	case SVAL:
+		return newBoolval(a1.Str(lineno) >= a2.Str(lineno))
 	default:
 		panic(badtype(">=", lineno))
 	}
 })
 
-var GTOPTOK = TOp2X("gt", ">", 0, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var GTOPTOK = TOp2X("gt", ">", relPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
 	case IVAL:
-		return newBoolval(a1.Int(lineno).Cmp(a2.Int(lineno)) > 0)
+		_, x, y := intOperands(a1, a2, lineno)
+		return newBoolval(x.Cmp(y) > 0)
 	case DVAL:
 		return newBoolval(a1.Real(lineno) > a2.Real(lineno))
-	case RVAL:
+	case FVAL:
+		return newBoolval(a1.BigFloat(lineno).Cmp(a2.BigFloat(lineno)) > 0)
+	case QVAL:
+		return quantityCompare(">", a1, a2, lineno)
+	case DUVAL:
+		return dualCompare(">", a1, a2, lineno)
+	case UVAL:
+		return newBoolval(a1.Uncertain(lineno).x > a2.Uncertain(lineno).x)
+	case IVLVAL:
+		x, y := a1.Interval(lineno), a2.Interval(lineno)
+		return ivLess(y, x)
+	case CVAL:
+		panic(fmt.Errorf("Can not compare complex values with > at line %d", lineno))
+	case RVAL, DECVAL:
 		return newBoolval(a1.Rat(lineno).Cmp(a2.Rat(lineno)) > 0)
+	case SVAL:
+		return newBoolval(a1.Str(lineno) > a2.Str(lineno))
 	default:
 		panic(badtype(">", lineno))
 	}
 })
 
-var LEOPTOK = TOp2X("le", "<=", 0, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var LEOPTOK = TOp2X("le", "<=", relPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
 	case IVAL:
-		return newBoolval(a1.Int(lineno).Cmp(a2.Int(lineno)) <= 0)
+		_, x, y := intOperands(a1, a2, lineno)
+		return newBoolval(x.Cmp(y) <= 0)
 	case DVAL:
 		return newBoolval(a1.Real(lineno) <= a2.Real(lineno))
-	case RVAL:
+	case FVAL:
+		return newBoolval(a1.BigFloat(lineno).Cmp(a2.BigFloat(lineno)) <= 0)
+	case QVAL:
+		return quantityCompare("<=", a1, a2, lineno)
+	case DUVAL:
+		return dualCompare("<=", a1, a2, lineno)
+	case UVAL:
+		return newBoolval(a1.Uncertain(lineno).x <= a2.Uncertain(lineno).x)
+	case IVLVAL:
+		x, y := a1.Interval(lineno), a2.Interval(lineno)
+		return ivLessEq(x, y)
+	case CVAL:
+		panic(fmt.Errorf("Can not compare complex values with <= at line %d", lineno))
+	case RVAL, DECVAL:
 		return newBoolval(a1.Rat(lineno).Cmp(a2.Rat(lineno)) <= 0)
+	case SVAL:
+		return newBoolval(a1.Str(lineno) <= a2.Str(lineno))
 	default:
 		panic(badtype("<=", lineno))
 	}
 })
 
-var LTOPTOK = TOp2X("lt", "<", 0, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var LTOPTOK = TOp2X("lt", "<", relPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
 	case IVAL:
-		return newBoolval(a1.Int(lineno).Cmp(a2.Int(lineno)) < 0)
+		_, x, y := intOperands(a1, a2, lineno)
+		return newBoolval(x.Cmp(y) < 0)
 	case DVAL:
 		return newBoolval(a1.Real(lineno) < a2.Real(lineno))
-	case RVAL:
+	case FVAL:
+		return newBoolval(a1.BigFloat(lineno).Cmp(a2.BigFloat(lineno)) < 0)
+	case QVAL:
+		return quantityCompare("<", a1, a2, lineno)
+	case DUVAL:
+		return dualCompare("<", a1, a2, lineno)
+	case UVAL:
+		return newBoolval(a1.Uncertain(lineno).x < a2.Uncertain(lineno).x)
+	case IVLVAL:
+		x, y := a1.Interval(lineno), a2.Interval(lineno)
+		return ivLess(x, y)
+	case CVAL:
+		panic(fmt.Errorf("Can not compare complex values with < at line %d", lineno))
+	case RVAL, DECVAL:
 		return newBoolval(a1.Rat(lineno).Cmp(a2.Rat(lineno)) < 0)
+	case SVAL:
+		return newBoolval(a1.Str(lineno) < a2.Str(lineno))
 	default:
 		panic(badtype("<", lineno))
 	}
 })
 
-var NEOPTOK = TOp2("!=", 0, func(a1, a2 *value, kind valueKind, lineno int) *value {
+var NEOPTOK = TOp2("!=", eqPriority, func(a1, a2 *value, kind valueKind, lineno int) *value {
 	switch kind {
 	case IVAL:
-		return newBoolval(a1.Int(lineno).Cmp(a2.Int(lineno)) != 0)
+		return newBoolval(!intEqual(a1, a2, lineno))
 	case DVAL:
 		return newBoolval(a1.Real(lineno) != a2.Real(lineno))
-	case RVAL:
+	case FVAL:
+		return newBoolval(a1.BigFloat(lineno).Cmp(a2.BigFloat(lineno)) != 0)
+	case QVAL:
+		return quantityCompare("!=", a1, a2, lineno)
+	case DUVAL:
+		return dualCompare("!=", a1, a2, lineno)
+	case UVAL:
+		return newBoolval(a1.Uncertain(lineno).x != a2.Uncertain(lineno).x)
+	case IVLVAL:
+		x, y := a1.Interval(lineno), a2.Interval(lineno)
+		return ivNotEqual(x, y)
+	case CVAL:
+		return newBoolval(a1.Complex(lineno) != a2.Complex(lineno))
+	case RVAL, DECVAL:
 		return newBoolval(a1.Rat(lineno).Cmp(a2.Rat(lineno)) != 0)
+	case SVAL:
+		return newBoolval(a1.Str(lineno) != a2.Str(lineno))
 	default:
 		panic(badtype("!=", lineno))
 	}
@@ -434,15 +993,31 @@ var SUBEQTOK = TSetOp("-=", SUBOPTOK.BinFn)
 var MULEQTOK = TSetOp("*=", MULOPTOK.BinFn)
 var DIVEQTOK = TSetOp("/=", DIVOPTOK.BinFn)
 var MODEQTOK = TSetOp("%=", MODOPTOK.BinFn)
+var POWEQTOK = TSetOp("**=", POWOPTOK.BinFn)
+var BWOREQTOK = TSetOp("|=", BWOROPTOK.BinFn)
+var BWANDEQTOK = TSetOp("&=", BWANDOPTOK.BinFn)
+var BWXOREQTOK = TSetOp("^=", BWXOROPTOK.BinFn)
+var BWANDNOTEQTOK = TSetOp("&^=", BWANDNOTOPTOK.BinFn)
+var SHLEQTOK = TSetOp("<<=", SHLOPTOK.BinFn)
+var SHREQTOK = TSetOp(">>=", SHROPTOK.BinFn)
+
+// Conversion to a unit, parsed specially by parseExpressionConv
+var CONVTOK = T("->")
 
 var COMMATOK = T(",")
 var SCOLTOK = T(";")
 
 var KwdTable = map[string]bool{
-	"if":    true,
-	"else":  true,
-	"while": true,
-	"for":   true,
-	"func":  true,
-	"exit":  true,
+	"if":       true,
+	"else":     true,
+	"while":    true,
+	"for":      true,
+	"in":       true,
+	"local":    true,
+	"global":   true,
+	"func":     true,
+	"exit":     true,
+	"return":   true,
+	"break":    true,
+	"continue": true,
 }
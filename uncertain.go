@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Values with an uncertainty (9.81 ± 0.02). Uncertainties are propagated to first order, assuming that the errors
+// are independent and Gaussian. Every ± introduces a new independent source of error and an uncertain value keeps
+// its sensitivity to each source, so correlations between values computed from the same sources are taken into
+// account: if x = 1 ± 0.1 then x - x is exactly 0 and x*x has twice the relative uncertainty of x.
+
+type uncertain struct {
+	x    float64         // nominal value
+	deps map[int]float64 // contribution of each source of error, never modified after creation
+}
+
+// Number of sources of error created so far, used to give each source a unique id
+var uncertainSources = 0
+
+func newUncertainval(u uncertain) *value {
+	return &value{kind: UVAL, unc: u}
+}
+
+// Returns the standard uncertainty of u
+func (u uncertain) sigma() float64 {
+	// sums the contributions in a fixed order so that the result does not depend on the order of the map
+	ids := make([]int, 0, len(u.deps))
+	for id := range u.deps {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	s := 0.0
+	for _, id := range ids {
+		s += u.deps[id] * u.deps[id]
+	}
+	return math.Sqrt(s)
+}
+
+// Returns x with an additional independent uncertainty sigma
+func uncWithSigma(x uncertain, sigma float64) uncertain {
+	deps := make(map[int]float64, len(x.deps)+1)
+	for id, d := range x.deps {
+		deps[id] = d
+	}
+	if sigma != 0 {
+		uncertainSources++
+		deps[uncertainSources] = sigma
+	}
+	return uncertain{x.x, deps}
+}
+
+// Returns the uncertain value f(a, b) given the partial derivatives dfa and dfb of f
+func uncCombine(f float64, a uncertain, dfa float64, b uncertain, dfb float64) uncertain {
+	deps := make(map[int]float64, len(a.deps)+len(b.deps))
+	for id, d := range a.deps {
+		deps[id] += dfa * d
+	}
+	for id, d := range b.deps {
+		deps[id] += dfb * d
+	}
+	return uncertain{f, deps}
+}
+
+// Returns the uncertain value f(a) given the derivative df of f
+func uncApply(f float64, a uncertain, df float64) uncertain {
+	return uncCombine(f, a, df, uncertain{}, 0)
+}
+
+// Returns the value as an uncertain value, numbers have no uncertainty
+func (vv *value) Uncertain(lineno int) uncertain {
+	if vv.kind == UVAL {
+		return vv.unc
+	}
+	return uncertain{x: vv.Real(lineno)}
+}
+
+// Builds the uncertain value a ± b
+func uncPlusMinus(a1, a2 *value, lineno int) *value {
+	if a2.kind == UVAL {
+		panic(fmt.Errorf("The uncertainty %s can not be uncertain at line %d", a2, lineno))
+	}
+	sigma := a2.Real(lineno)
+	if sigma < 0 || math.IsNaN(sigma) {
+		panic(fmt.Errorf("Negative uncertainty %s at line %d", a2, lineno))
+	}
+	return newUncertainval(uncWithSigma(a1.Uncertain(lineno), sigma))
+}
+
+func uncPow(a, b uncertain) uncertain {
+	f := math.Pow(a.x, b.x)
+	dfa := 0.0
+	if b.x != 0 {
+		dfa = b.x * math.Pow(a.x, b.x-1)
+	}
+	dfb := 0.0
+	if len(b.deps) > 0 {
+		dfb = math.Log(a.x) * f
+	}
+	return uncCombine(f, a, dfa, b, dfb)
+}
+
+// Applies fn, with derivative dfn, to an uncertain value
+func uncApplyFunc(fn, dfn func(float64) float64, a uncertain, lineno int) uncertain {
+	f := fn(a.x)
+	if math.IsNaN(f) {
+		panic(fmt.Errorf("Nominal value %g outside of the domain of the function at line %d", a.x, lineno))
+	}
+	return uncApply(f, a, funcDeriv(fn, dfn, a.x, lineno))
+}
+
+// Formats the uncertain value with the uncertainty rounded to two significant digits and the nominal value rounded
+// to the same decimal place
+func (u uncertain) String() string {
+	sigma := u.sigma()
+	if sigma == 0 || math.IsInf(sigma, 0) || math.IsNaN(sigma) || math.IsInf(u.x, 0) || math.IsNaN(u.x) {
+		return fmtfloatstr(strconv.FormatFloat(u.x, 'g', -1, 64)) + " ± " + fmtfloatstr(strconv.FormatFloat(sigma, 'g', -1, 64))
+	}
+
+	// position of the second significant digit of the uncertainty
+	e := int(math.Floor(math.Log10(sigma))) - 1
+	if math.Round(sigma/math.Pow10(e)) >= 100 {
+		e++
+	}
+
+	// numbers too large or too small for fixed point notation are written as (x ± u)e+NN
+	exp := 0
+	if mag := math.Max(math.Abs(u.x), sigma); mag >= 1e15 || mag < 1e-5 {
+		exp = int(math.Floor(math.Log10(mag)))
+	}
+	scale := math.Pow10(exp)
+	decimals := max(0, exp-e)
+	r := func(x float64) string {
+		return fmtnumstr(strconv.FormatFloat(x/scale, 'f', decimals, 64), false)
+	}
+	if e > exp {
+		// the uncertainty is larger than 100: rounds the digits before the comma too
+		unit := math.Pow10(e)
+		r = func(x float64) string {
+			return fmtnumstr(strconv.FormatFloat(math.Round(x/unit)*unit/scale, 'f', 0, 64), false)
+		}
+	}
+	s := r(u.x) + " ± " + r(sigma)
+	if exp != 0 {
+		return fmt.Sprintf("(%s)e%+03d", s, exp)
+	}
+	return s
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Physical quantities (3.3 V, 5 km/h). A quantity is a number of any numeric kind, its magnitude, followed by a unit.
+// Units are products of named units raised to integer powers, every named unit has a value in SI base units and a
+// dimension, the exponents of the seven SI base units. Sums, differences and comparisons need quantities with the
+// same dimension, products and quotients multiply units. Quantities are kept in the units they were written in and
+// only converted when needed, or when requested with ->, so that no rounding error is introduced by the conversions.
+
+type dimension [7]int
+
+var baseUnitNames = [len(dimension{})]string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+// A named unit
+type unitDef struct {
+	factor *big.Rat // value of the unit in SI base units
+	dim    dimension
+	prefix bool // the unit accepts SI prefixes
+}
+
+// A named unit raised to a power, as part of a unit
+type unitTerm struct {
+	name string
+	exp  int
+	def  *unitDef
+}
+
+type unit struct {
+	terms  []unitTerm
+	factor *big.Rat // value of the unit in SI base units, never modified after creation
+	dim    dimension
+}
+
+type quantity struct {
+	x *value // magnitude, never a quantity
+	u unit
+}
+
+var siPrefixes = []struct {
+	name string
+	exp  int
+}{
+	{"da", 1}, {"h", 2}, {"k", 3}, {"M", 6}, {"G", 9}, {"T", 12}, {"P", 15}, {"E", 18}, {"Z", 21}, {"Y", 24},
+	{"d", -1}, {"c", -2}, {"m", -3}, {"µ", -6}, {"u", -6}, {"n", -9}, {"p", -12}, {"f", -15}, {"a", -18}, {"z", -21}, {"y", -24},
+}
+
+// Returns the dimension m**m * kg**kg * s**s * A**a
+func dims(m, kg, s, a int) dimension {
+	return dimension{m, kg, s, a}
+}
+
+func defUnit(factor string, d dimension, prefix bool) *unitDef {
+	f, ok := new(big.Rat).SetString(factor)
+	if !ok {
+		panic("invalid unit factor " + factor)
+	}
+	return &unitDef{f, d, prefix}
+}
+
+// Built in units, more can be defined with @:unit (for example in the rc file)
+var unitTable = map[string]*unitDef{
+	// SI base units, the kilogram is the gram with the k prefix
+	"m":   defUnit("1", dims(1, 0, 0, 0), true),
+	"g":   defUnit("1/1000", dims(0, 1, 0, 0), true),
+	"s":   defUnit("1", dims(0, 0, 1, 0), true),
+	"A":   defUnit("1", dims(0, 0, 0, 1), true),
+	"K":   defUnit("1", dimension{4: 1}, true),
+	"mol": defUnit("1", dimension{5: 1}, true),
+	"cd":  defUnit("1", dimension{6: 1}, true),
+
+	// SI derived units
+	"Hz":  defUnit("1", dims(0, 0, -1, 0), true),
+	"N":   defUnit("1", dims(1, 1, -2, 0), true),
+	"Pa":  defUnit("1", dims(-1, 1, -2, 0), true),
+	"J":   defUnit("1", dims(2, 1, -2, 0), true),
+	"W":   defUnit("1", dims(2, 1, -3, 0), true),
+	"C":   defUnit("1", dims(0, 0, 1, 1), true),
+	"V":   defUnit("1", dims(2, 1, -3, -1), true),
+	"F":   defUnit("1", dims(-2, -1, 4, 2), true),
+	"Ω":   defUnit("1", dims(2, 1, -3, -2), true),
+	"ohm": defUnit("1", dims(2, 1, -3, -2), true),
+	"S":   defUnit("1", dims(-2, -1, 3, 2), true),
+	"Wb":  defUnit("1", dims(2, 1, -2, -1), true),
+	"T":   defUnit("1", dims(0, 1, -2, -1), true),
+	"H":   defUnit("1", dims(2, 1, -2, -2), true),
+
+	// other units
+	"min":  defUnit("60", dims(0, 0, 1, 0), false),
+	"h":    defUnit("3600", dims(0, 0, 1, 0), false),
+	"day":  defUnit("86400", dims(0, 0, 1, 0), false),
+	"L":    defUnit("1/1000", dims(3, 0, 0, 0), true),
+	"Wh":   defUnit("3600", dims(2, 1, -2, 0), true),
+	"Ah":   defUnit("3600", dims(0, 0, 1, 1), true),
+	"eV":   defUnit("1.602176634e-19", dims(2, 1, -2, 0), true),
+	"cal":  defUnit("4.184", dims(2, 1, -2, 0), true),
+	"bar":  defUnit("100000", dims(-1, 1, -2, 0), true),
+	"atm":  defUnit("101325", dims(-1, 1, -2, 0), false),
+	"inch": defUnit("0.0254", dims(1, 0, 0, 0), false),
+	"ft":   defUnit("0.3048", dims(1, 0, 0, 0), false),
+	"yd":   defUnit("0.9144", dims(1, 0, 0, 0), false),
+	"mi":   defUnit("1609.344", dims(1, 0, 0, 0), false),
+	"lb":   defUnit("0.45359237", dims(0, 1, 0, 0), false),
+	"oz":   defUnit("0.028349523125", dims(0, 1, 0, 0), false),
+}
+
+// Named SI units that products and quotients of quantities are converted to (see simplifyUnit)
+var derivedUnitNames = []string{"N", "Pa", "J", "W", "C", "V", "F", "Ω", "S", "Wb", "T", "H"}
+
+// Returns the unit called name, possibly an SI prefix followed by the name of a unit that accepts prefixes
+func lookupUnit(name string) (*unitDef, bool) {
+	if def, ok := unitTable[name]; ok {
+		return def, true
+	}
+	for _, p := range siPrefixes {
+		if !strings.HasPrefix(name, p.name) {
+			continue
+		}
+		if def, ok := unitTable[name[len(p.name):]]; ok && def.prefix {
+			f := new(big.Rat).SetInt(pow10(int(intAbs(int64(p.exp)))))
+			if p.exp < 0 {
+				f.Inv(f)
+			}
+			return &unitDef{f.Mul(f, def.factor), def.dim, false}, true
+		}
+	}
+	return nil, false
+}
+
+// Makes the unit product of terms
+func newUnit(terms []unitTerm) unit {
+	u := unit{terms: terms, factor: big.NewRat(1, 1)}
+	for _, t := range terms {
+		f := new(big.Rat).SetFrac(new(big.Int).Exp(t.def.factor.Num(), big.NewInt(intAbs(int64(t.exp))), nil), new(big.Int).Exp(t.def.factor.Denom(), big.NewInt(intAbs(int64(t.exp))), nil))
+		if t.exp < 0 {
+			f.Inv(f)
+		}
+		u.factor.Mul(u.factor, f)
+		for i := range u.dim {
+			u.dim[i] += t.exp * t.def.dim[i]
+		}
+	}
+	return u
+}
+
+func namedUnit(name string, def *unitDef) unit {
+	return newUnit([]unitTerm{{name, 1, def}})
+}
+
+// Returns the product of a and b raised to sign (1 or -1), terms with the same name are merged
+func unitMul(a, b unit, sign int) unit {
+	terms := append([]unitTerm{}, a.terms...)
+	for _, t := range b.terms {
+		t.exp *= sign
+		found := false
+		for i := range terms {
+			if terms[i].name == t.name {
+				terms[i].exp += t.exp
+				found = true
+			}
+		}
+		if !found {
+			terms = append(terms, t)
+		}
+	}
+	return newUnit(dropZeroTerms(terms))
+}
+
+func dropZeroTerms(terms []unitTerm) []unitTerm {
+	r := []unitTerm{}
+	for _, t := range terms {
+		if t.exp != 0 {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// Returns u raised to p/q, the second result is false if the exponents of u are not multiples of q
+func unitPow(u unit, p, q int) (unit, bool) {
+	terms := make([]unitTerm, len(u.terms))
+	for i, t := range u.terms {
+		if t.exp*p%q != 0 {
+			return unit{}, false
+		}
+		terms[i] = unitTerm{t.name, t.exp * p / q, t.def}
+	}
+	return newUnit(dropZeroTerms(terms)), true
+}
+
+// Returns the coherent SI unit with dimension d, written with base units
+func siUnit(d dimension) unit {
+	terms := []unitTerm{}
+	// kg*m**2/s**2 rather than m**2*kg/s**2
+	for _, i := range []int{1, 0, 2, 3, 4, 5, 6} {
+		if d[i] != 0 {
+			def, _ := lookupUnit(baseUnitNames[i])
+			terms = append(terms, unitTerm{baseUnitNames[i], d[i], def})
+		}
+	}
+	return newUnit(terms)
+}
+
+// Returns the unit the result of a product or a quotient of quantities is expressed in. Units with the same dimension
+// are merged into the first one (km/h*min is km), then units mixing different names become the named SI unit with
+// their dimension, if there is one (mA*Ω is V).
+func simplifyUnit(u unit) unit {
+	terms := append([]unitTerm{}, u.terms...)
+	for j := range terms {
+		for i := 0; i < j; i++ {
+			if terms[i].exp != 0 && terms[i].def.dim == terms[j].def.dim && terms[i].def.dim != (dimension{}) {
+				terms[i].exp += terms[j].exp
+				terms[j].exp = 0
+				break
+			}
+		}
+	}
+	r := newUnit(dropZeroTerms(terms))
+	if len(r.terms) < 2 {
+		return r
+	}
+	for _, name := range derivedUnitNames {
+		if def := unitTable[name]; def.dim == r.dim {
+			return namedUnit(name, def)
+		}
+	}
+	return r
+}
+
+func (u unit) String() string {
+	num, den := []string{}, []string{}
+	for _, t := range u.terms {
+		if t.exp > 0 {
+			num = append(num, unitTermString(t.name, t.exp))
+		} else {
+			den = append(den, unitTermString(t.name, -t.exp))
+		}
+	}
+	switch {
+	case len(den) == 0:
+		return strings.Join(num, "*")
+	case len(num) == 0:
+		// only negative exponents: s**-1
+		for i, t := range u.terms {
+			den[i] = fmt.Sprintf("%s**%d", t.name, t.exp)
+		}
+		return strings.Join(den, "*")
+	case len(den) == 1:
+		return strings.Join(num, "*") + "/" + den[0]
+	}
+	return strings.Join(num, "*") + "/(" + strings.Join(den, "*") + ")"
+}
+
+func unitTermString(name string, exp int) string {
+	if exp == 1 {
+		return name
+	}
+	return fmt.Sprintf("%s**%d", name, exp)
+}
+
+// Describes u for error messages
+func (u unit) describe() string {
+	if len(u.terms) == 0 {
+		return "a number"
+	}
+	return u.String()
+}
+
+// Makes the quantity x u, quantities without a dimension become numbers
+func newQuantityval(x *value, u unit, lineno int) *value {
+	if u.dim == (dimension{}) {
+		return scaleValue(x, u.factor, lineno)
+	}
+	return &value{kind: QVAL, qty: &quantity{x, u}}
+}
+
+// Returns the value as a quantity, numbers are quantities without a unit
+func (vv *value) Quantity(lineno int) quantity {
+	if vv.kind == QVAL {
+		return *vv.qty
+	}
+	return quantity{vv, newUnit(nil)}
+}
+
+// Applies the binary operator name to the magnitudes x1 and x2
+func magnitudeOp(name string, x1, x2 *value, lineno int) *value {
+	return TokenTypes[name].BinFn(x1, x2, resultKind(x1, x2), lineno)
+}
+
+// Returns x multiplied by f
+func scaleValue(x *value, f *big.Rat, lineno int) *value {
+	if f.Num().Cmp(big.NewInt(1)) != 0 {
+		x = magnitudeOp("*", x, newIntval(*new(big.Int).Set(f.Num()), DECFLV), lineno)
+	}
+	if !f.IsInt() {
+		x = magnitudeOp("/", x, newIntval(*new(big.Int).Set(f.Denom()), DECFLV), lineno)
+		if x.kind == RVAL {
+			// like other rational results that are not integers, shows at least 12 digits
+			x.prec = max(x.prec, 12)
+		}
+	}
+	return x
+}
+
+// Returns the magnitude of q converted to the unit u, which must have the same dimension
+func (q quantity) in(u unit, lineno int) *value {
+	if q.u.dim != u.dim {
+		panic(fmt.Errorf("Dimension error, can not convert %s to %s at line %d", q.u.describe(), u.describe(), lineno))
+	}
+	return scaleValue(q.x, new(big.Rat).Quo(q.u.factor, u.factor), lineno)
+}
+
+// Returns the magnitudes of the operands of a sum, difference or comparison, the second one converted to the unit of
+// the first one, and their unit
+func quantityOperands(name string, a1, a2 *value, lineno int) (*value, *value, unit) {
+	q1, q2 := a1.Quantity(lineno), a2.Quantity(lineno)
+	if q1.u.dim != q2.u.dim {
+		panic(fmt.Errorf("Dimension error, can not apply %s to %s and %s at line %d", name, q1.u.describe(), q2.u.describe(), lineno))
+	}
+	return q1.x, q2.in(q1.u, lineno), q1.u
+}
+
+// Sum or difference of quantities, the result has the unit of the first operand
+func quantityAdd(name string, a1, a2 *value, lineno int) *value {
+	x1, x2, u := quantityOperands(name, a1, a2, lineno)
+	return newQuantityval(magnitudeOp(name, x1, x2, lineno), u, lineno)
+}
+
+func quantityCompare(name string, a1, a2 *value, lineno int) *value {
+	x1, x2, _ := quantityOperands(name, a1, a2, lineno)
+	return magnitudeOp(name, x1, x2, lineno)
+}
+
+// Product (name is "*") or quotient (name is "/") of quantities
+func quantityMul(name string, a1, a2 *value, lineno int) *value {
+	q1, q2 := a1.Quantity(lineno), a2.Quantity(lineno)
+	x := magnitudeOp(name, q1.x, q2.x, lineno)
+	sign := 1
+	if name == "/" {
+		sign = -1
+	}
+	u := unitMul(q1.u, q2.u, sign)
+	if len(q1.u.terms) == 0 || len(q2.u.terms) == 0 {
+		return newQuantityval(x, u, lineno)
+	}
+	su := simplifyUnit(u)
+	return newQuantityval(quantity{x, u}.in(su, lineno), su, lineno)
+}
+
+// Returns q with its unit raised to p/q, converted to SI base units when the exponents of its unit are not multiples of q
+func quantityRootUnit(qy quantity, p, q int, lineno int) (*value, unit) {
+	if u, ok := unitPow(qy.u, p, q); ok {
+		return qy.x, u
+	}
+	si := siUnit(qy.u.dim)
+	if u, ok := unitPow(si, p, q); ok {
+		return qy.in(si, lineno), u
+	}
+	panic(fmt.Errorf("Dimension error, can not raise %s to %d/%d at line %d", qy.u, p, q, lineno))
+}
+
+// Power of a quantity, the exponent must be a number
+func quantityPow(a1, a2 *value, lineno int) *value {
+	if a2.kind == QVAL {
+		panic(fmt.Errorf("Dimension error, the exponent %s is not a number at line %d", a2, lineno))
+	}
+	e := a2.Rat(lineno)
+	if !e.Num().IsInt64() || !e.Denom().IsInt64() || e.Denom().Int64() > 1<<16 || intAbs(e.Num().Int64()) > 1<<16 {
+		panic(fmt.Errorf("Dimension error, can not raise %s to %s at line %d", a1.qty.u, a2, lineno))
+	}
+	x, u := quantityRootUnit(*a1.qty, int(e.Num().Int64()), int(e.Denom().Int64()), lineno)
+	return newQuantityval(magnitudeOp("**", x, a2, lineno), u, lineno)
+}
+
+// Extends the builtin fnv to quantities: fnv is applied to the magnitude of its first argument and the unit is raised
+// to 1/root, for example sqrt(4 m**2) is 2 m and floor(2.5 m) is 2 m
+func quantityFuncValue(root int, fnv *value) *value {
+	bfn := fnv.bval
+	return &value{kind: BVAL, bval: &BuiltinFn{nargs: bfn.nargs, maxargs: bfn.maxargs, fn: func(argv []*value, lineno int) *value {
+		if argv[0].kind != QVAL {
+			return bfn.fn(argv, lineno)
+		}
+		x, u := quantityRootUnit(*argv[0].qty, 1, root, lineno)
+		return newQuantityval(bfn.fn(append([]*value{x}, argv[1:]...), lineno), u, lineno)
+	}}}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"strconv"
 	"strings"
@@ -13,22 +14,46 @@ type value struct {
 	flavor valueFlavor
 	ival   big.Int
 	dval   float64
+	cval   complex128
+	fval   *big.Float // big floating point number, never modified after creation
+	ivl    interval   // bounds of an interval
+	unc    uncertain  // value with uncertainty
+	qty    *quantity  // physical quantity, never modified after creation
+	dual   dual       // dual number, used for automatic differentiation
 	rval   big.Rat
 	nval   *FnDefNode
+	env    *CallFrame // environment captured by a function value
 	dtval  *time.Time
 	bval   *BuiltinFn
+	lval   *[]*value // elements of a list, lists are shared by reference
+	sval   string
+	mval   *valueMap // entries of a map, maps are shared by reference
+	itype  intType   // width and signedness of fixed width integers
 	prec   int
+
+	inexact     bool // integer or rational computed from a floating point approximation
+	modNegative bool // integer reduced by @:mod from a negative number, as an exponent it is still negative
 }
 
 type valueKind uint8
 
 const (
-	IVAL  valueKind = iota // integer
-	DVAL                   // double
-	RVAL                   // rational number
-	PVAL                   // a subprogram
-	BVAL                   // a builtin function
-	DTVAL                  // date
+	IVAL   valueKind = iota // integer
+	DVAL                    // double
+	RVAL                    // rational number
+	PVAL                    // a subprogram
+	BVAL                    // a builtin function
+	DTVAL                   // date
+	LVAL                    // list
+	SVAL                    // string
+	MVAL                    // map
+	CVAL                    // complex number
+	FVAL                    // arbitrary precision floating point number (big float mode)
+	DECVAL                  // fixed point decimal number, ival / 10**prec
+	IVLVAL                  // interval
+	UVAL                    // value with uncertainty
+	QVAL                    // physical quantity, a number with a unit
+	DUVAL                   // dual number x + dx ε
 )
 
 type valueFlavor uint8
@@ -41,16 +66,69 @@ const (
 	TIMEFLV
 )
 
+// Fixed width integer type used in programmer mode, the zero value is an unbounded integer
+type intType struct {
+	bits   int
+	signed bool
+}
+
+func (t intType) String() string {
+	if t.bits == 0 {
+		return "int"
+	}
+	if t.signed {
+		return fmt.Sprintf("i%d", t.bits)
+	}
+	return fmt.Sprintf("u%d", t.bits)
+}
+
+// Returns x wrapped to t using two's complement, x itself is returned if t is unbounded
+func (t intType) convert(x *big.Int) *big.Int {
+	if t.bits == 0 {
+		return x
+	}
+	m := new(big.Int).Lsh(big.NewInt(1), uint(t.bits))
+	r := new(big.Int).Mod(x, m)
+	if t.signed && r.Bit(t.bits-1) == 1 {
+		r.Sub(r, m)
+	}
+	return r
+}
+
+// Sets the integer type of v to t and wraps its value to it. If check is set and overflow errors are enabled (@:overflow)
+// a value that doesn't fit t is an error instead
+func (v *value) setIntType(t intType, check bool, name string, lineno int) *value {
+	v.itype = t
+	if t.bits == 0 {
+		return v
+	}
+	r := t.convert(&v.ival)
+	if check && overflowError && r.Cmp(&v.ival) != 0 {
+		panic(fmt.Errorf("Integer overflow in %s, %s does not fit %s at line %d", name, v.ival.String(), t, lineno))
+	}
+	v.ival.Set(r)
+	return v
+}
+
+// Reduces an integer result modulo the modulus selected with @:mod, fixed width integers are not changed
+func (v *value) reduceMod() *value {
+	if modulus != nil && v.itype.bits == 0 {
+		v.modNegative = v.ival.Sign() < 0
+		v.ival.Mod(&v.ival, modulus)
+	}
+	return v
+}
+
 func newZeroVal(kind valueKind, flavor valueFlavor, prec int) *value {
-	return &value{kind, flavor, big.Int{}, 0, big.Rat{}, nil, nil, nil, prec}
+	return &value{kind: kind, flavor: flavor, prec: prec}
 }
 
 func newDateval(t time.Time) *value {
-	return &value{DTVAL, DECFLV, big.Int{}, 0, big.Rat{}, nil, &t, nil, 0}
+	return &value{kind: DTVAL, dtval: &t}
 }
 
 func newFloatval(x float64, flavor valueFlavor) *value {
-	return &value{DVAL, flavor, big.Int{}, x, big.Rat{}, nil, nil, nil, 0}
+	return &value{kind: DVAL, flavor: flavor, dval: x}
 }
 
 func newFloatvalDerived(x float64, a1, a2 *value) *value {
@@ -61,12 +139,20 @@ func newFloatvalDerived(x float64, a1, a2 *value) *value {
 	return newFloatval(x, flavor)
 }
 
+func newBigFloatval(x *big.Float) *value {
+	return &value{kind: FVAL, fval: x}
+}
+
+func newComplexval(z complex128) *value {
+	return &value{kind: CVAL, cval: z}
+}
+
 func newRatval(v big.Rat, prec int) *value {
-	return &value{RVAL, DECFLV, big.Int{}, 0, v, nil, nil, nil, prec}
+	return &value{kind: RVAL, rval: v, prec: prec}
 }
 
 func newIntval(v big.Int, flavor valueFlavor) *value {
-	return &value{IVAL, flavor, v, 0, big.Rat{}, nil, nil, nil, 0}
+	return &value{kind: IVAL, flavor: flavor, ival: v}
 }
 
 func newBoolval(b bool) *value {
@@ -77,23 +163,77 @@ func newBoolval(b bool) *value {
 	return v
 }
 
+func newFnval(fn *FnDefNode, env *CallFrame) *value {
+	return &value{kind: PVAL, nval: fn, env: env}
+}
+
+func newListval(elems []*value) *value {
+	return &value{kind: LVAL, lval: &elems}
+}
+
+func newStrval(s string) *value {
+	return &value{kind: SVAL, sval: s}
+}
+
+func newMapval() *value {
+	return &value{kind: MVAL, mval: &valueMap{map[string]*value{}, map[string]*value{}, []string{}}}
+}
+
 func makeFuncValue(nargs int, fn BuiltinFunc) *value {
-	return &value{BVAL, DECFLV, big.Int{}, 0, big.Rat{}, nil, nil, &BuiltinFn{nargs: nargs, fn: fn}, 0}
+	return &value{kind: BVAL, bval: &BuiltinFn{nargs: nargs, maxargs: nargs, fn: fn}}
+}
+
+// Makes a builtin function accepting between minargs and maxargs arguments, if maxargs is negative there is no upper limit
+func makeVariadicFuncValue(minargs, maxargs int, fn BuiltinFunc) *value {
+	return &value{kind: BVAL, bval: &BuiltinFn{nargs: minargs, maxargs: maxargs, fn: fn}}
+}
+
+// Makes a builtin function that receives the call stack, see makeVariadicFuncValue for minargs and maxargs
+func makeStackFuncValue(minargs, maxargs int, fn BuiltinStackFunc) *value {
+	return &value{kind: BVAL, bval: &BuiltinFn{nargs: minargs, maxargs: maxargs, stackFn: fn}}
 }
 
 func resultKind(a1, a2 *value) valueKind {
 	for _, v := range []*value{a1, a2} {
-		for _, kind := range []valueKind{PVAL, BVAL, DTVAL} {
+		for _, kind := range []valueKind{PVAL, BVAL, DTVAL, LVAL, SVAL, MVAL} {
 			if v.kind == kind {
 				return kind
 			}
 		}
 	}
 
+	if a1.kind == QVAL || a2.kind == QVAL {
+		return QVAL
+	}
+
+	if a1.kind == DUVAL || a2.kind == DUVAL {
+		return DUVAL
+	}
+
+	if a1.kind == IVLVAL || a2.kind == IVLVAL {
+		return IVLVAL
+	}
+
+	if a1.kind == UVAL || a2.kind == UVAL {
+		return UVAL
+	}
+
+	if a1.kind == CVAL || a2.kind == CVAL {
+		return CVAL
+	}
+
 	if a1.kind == DVAL || a2.kind == DVAL {
 		return DVAL
 	}
 
+	if a1.kind == FVAL || a2.kind == FVAL {
+		return FVAL
+	}
+
+	if a1.kind == DECVAL || a2.kind == DECVAL {
+		return DECVAL
+	}
+
 	if a1.kind == RVAL || a2.kind == RVAL {
 		return RVAL
 	}
@@ -102,11 +242,21 @@ func resultKind(a1, a2 *value) valueKind {
 }
 
 func (vv *value) String() string {
+	return vv.format(programmerMode)
+}
+
+// Returns the printable representation of the value, prog selects programmer mode output
+func (vv *value) format(prog bool) string {
+	if vv.inexact {
+		exact := *vv
+		exact.inexact = false
+		return "~" + exact.format(prog)
+	}
 	switch vv.kind {
 	case IVAL:
 		switch vv.flavor {
 		case HEXFLV:
-			if programmerMode {
+			if prog {
 				return fmt.Sprintf("%d\t%#x", &vv.ival, &vv.ival)
 			} else {
 				return fmt.Sprintf("%#x", &vv.ival)
@@ -127,7 +277,7 @@ func (vv *value) String() string {
 			}
 			return fmt.Sprintf("%02d:%02d", &m, &s)
 		default:
-			if programmerMode {
+			if prog {
 
 				return fmt.Sprintf("%s\t%#x", fmtfloatstr(vv.ival.String()), &vv.ival)
 			} else {
@@ -142,12 +292,169 @@ func (vv *value) String() string {
 		}
 	case RVAL:
 		return fmtfloatstr(vv.rval.FloatString(vv.prec))
+	case DECVAL:
+		return fmtdecimal(&vv.ival, vv.prec)
+	case IVLVAL:
+		return vv.ivl.String()
+	case UVAL:
+		return vv.unc.String()
+	case QVAL:
+		return vv.qty.x.format(prog) + " " + vv.qty.u.String()
+	case DUVAL:
+		return vv.dual.String()
+	case CVAL:
+		if CommaMode == rationalComma {
+			// complex numbers are floating point approximations, shown like inexact rationals
+			return "~" + fmtcomplexRat(vv.cval)
+		}
+		return fmtcomplex(vv.cval)
+	case FVAL:
+		return fmtfloatstr(vv.fval.Text('g', bigFloatDigits(vv.fval)))
 	case DTVAL:
 		return "$" + vv.dtval.Format("20060102")
+	case LVAL:
+		elems := make([]string, len(*vv.lval))
+		for i, e := range *vv.lval {
+			elems[i] = e.format(prog)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case SVAL:
+		return strconv.Quote(vv.sval)
+	case MVAL:
+		entries := make([]string, len(vv.mval.order))
+		for i, k := range vv.mval.order {
+			entries[i] = vv.mval.keys[k].format(prog) + ": " + vv.mval.vals[k].format(prog)
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
 	}
 	return fmt.Sprintf("@")
 }
 
+// A map from values to values, keys are compared by value (see mapKey)
+type valueMap struct {
+	keys  map[string]*value // original key values indexed by mapKey
+	vals  map[string]*value // values indexed by mapKey
+	order []string          // mapKey of each entry, in insertion order
+}
+
+// Returns a string that is the same for keys that compare equal:
+// integers, rationals and floats with the same value map to the same key, as do strings and dates with the same content
+func mapKey(k *value, lineno int) string {
+	switch k.kind {
+	case IVAL:
+		return "n" + k.ival.String()
+	case RVAL, DECVAL:
+		return "n" + k.Rat(lineno).RatString()
+	case DVAL:
+		var r big.Rat
+		if math.IsInf(k.dval, 0) || math.IsNaN(k.dval) {
+			return "f" + strconv.FormatFloat(k.dval, 'g', -1, 64)
+		}
+		r.SetFloat64(k.dval)
+		return "n" + r.RatString()
+	case FVAL:
+		if k.fval.IsInf() {
+			return "f" + k.fval.String()
+		}
+		r, _ := k.fval.Rat(nil)
+		return "n" + r.RatString()
+	case SVAL:
+		return "s" + k.sval
+	case DTVAL:
+		return "d" + k.dtval.Format("20060102")
+	case CVAL:
+		if imag(k.cval) == 0 {
+			return mapKey(newFloatval(real(k.cval), DECFLV), lineno)
+		}
+		return "c" + strconv.FormatComplex(k.cval, 'g', -1, 128)
+	}
+	panic(fmt.Errorf("Can not use value %s as a map key at line %d", k, lineno))
+}
+
+func (m *valueMap) get(k *value, lineno int) (*value, bool) {
+	vv, ok := m.vals[mapKey(k, lineno)]
+	return vv, ok
+}
+
+func (m *valueMap) set(k, vv *value, lineno int) {
+	mk := mapKey(k, lineno)
+	if _, ok := m.vals[mk]; !ok {
+		key := *k
+		m.keys[mk] = &key
+		m.order = append(m.order, mk)
+	}
+	m.vals[mk] = vv
+}
+
+func (m *valueMap) remove(k *value, lineno int) bool {
+	mk := mapKey(k, lineno)
+	if _, ok := m.vals[mk]; !ok {
+		return false
+	}
+	delete(m.keys, mk)
+	delete(m.vals, mk)
+	for i := range m.order {
+		if m.order[i] == mk {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Returns the keys of the map in insertion order
+func (m *valueMap) keyList() []*value {
+	r := make([]*value, len(m.order))
+	for i, mk := range m.order {
+		r[i] = m.keys[mk]
+	}
+	return r
+}
+
+// Returns the number of significant decimal digits of x
+func bigFloatDigits(x *big.Float) int {
+	return int(float64(x.Prec()) * math.Log10(2))
+}
+
+// Formats a complex number as a+bi, the real part is omitted when it is zero
+func fmtcomplex(z complex128) string {
+	if imag(z) == 0 {
+		// -0 prints as 0
+		z = complex(real(z), 0)
+	}
+	im := fmtfloatstr(strconv.FormatFloat(imag(z), 'g', -1, 64)) + "i"
+	if real(z) == 0 {
+		return im
+	}
+	if !math.Signbit(imag(z)) {
+		im = "+" + im
+	}
+	return fmtfloatstr(strconv.FormatFloat(real(z), 'g', -1, 64)) + im
+}
+
+// Formats a complex number like fmtcomplex, with the parts rounded to 12 digits after the comma like inexact rationals
+func fmtcomplexRat(z complex128) string {
+	part := func(x float64) string {
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return strconv.FormatFloat(x, 'g', -1, 64)
+		}
+		r := fmtfloatstr(new(big.Rat).SetFloat64(x).FloatString(12))
+		if strings.Trim(r, "-0.") == "" {
+			// tiny negative parts round to 0, not -0
+			r = strings.TrimPrefix(r, "-")
+		}
+		return r
+	}
+	im := part(imag(z)) + "i"
+	if real(z) == 0 {
+		return im
+	}
+	if im[0] != '-' {
+		im = "+" + im
+	}
+	return part(real(z)) + im
+}
+
 func max(v ...int) int {
 	if len(v) == 0 {
 		return 0
@@ -175,6 +482,12 @@ func min(v ...int) int {
 }
 
 func fmtfloatstr(s string) string {
+	return fmtnumstr(s, true)
+}
+
+// Inserts a ' separator every three digits in the integral part of the number s,
+// if trim is true trailing zeros of the fractional part are also removed
+func fmtnumstr(s string, trim bool) string {
 	if strings.Index(s, "e") >= 0 || strings.Index(s, "E") >= 0 {
 		return s
 	}
@@ -193,12 +506,11 @@ func fmtfloatstr(s string) string {
 		integral = integral[1:]
 	}
 
-	found := false
-	for i := len(frac) - 1; i >= 1; i-- {
+	found := !trim
+	for i := len(frac) - 1; i >= 1 && !found; i-- {
 		if frac[i] != '0' {
 			frac = frac[:i+1]
 			found = true
-			break
 		}
 	}
 	if !found {